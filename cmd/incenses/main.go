@@ -0,0 +1,26 @@
+package main
+
+import (
+	gContext "context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rentiansheng/incenses/src/cli"
+	"github.com/rentiansheng/incenses/src/context"
+	_ "github.com/rentiansheng/incenses/src/plugins"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc: 只包含内置的插件，使用自定义collect 插件的时候，参考这里在自己的main 中import 插件
+
+***************************/
+
+func main() {
+	ctx, cancel := signal.NotifyContext(gContext.Background(), os.Interrupt, syscall.SIGTERM)
+	code := cli.Run(context.NewContexts(ctx), os.Args[1:], os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
@@ -69,7 +69,7 @@ func main() {
 	})
 
 	core.SetClient(cache)
-	event, err := core.New(mysqlTaskHandle)
+	event, err := core.New(mysqlTaskHandle, nil)
 	if err != nil {
 		fmt.Println("event init error", err)
 		return
@@ -149,11 +149,8 @@ func initExampleTask(ctx context.Context, MysqlDSN, tableName string) (define.Me
 	outputMysql.SetDB(db)
 
 	/***  optional: start add storage calculate metric value ***/
-	sqls := outputMysql.InitSQL(ctx)
-	for _, sql := range sqls {
-		if err := db.Exec(sql).Error; err != nil {
-			return nil, fmt.Errorf("execute init output tab error. %s", err.Error())
-		}
+	if err := outputMysql.InitTables(ctx, db, ""); err != nil {
+		return nil, fmt.Errorf("execute init output tab error. %s", err.Error())
 	}
 	/*	end add storage calculate metric value  */
 
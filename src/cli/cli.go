@@ -0,0 +1,116 @@
+package cli
+
+import (
+	gContext "context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/context/log"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc: incenses 命令行工具，创建表，管理任务，手动执行和回填任务。
+    collect 插件需要使用方实现，在自己的main 中import 插件后调用Run
+
+***************************/
+
+// errUsage 参数错误，使用说明已经输出
+var errUsage = errors.New("usage error")
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+var commands = []command{
+	{name: "init-schema", usage: "create the task, history, lock and mysql output tables", run: initSchema},
+	{name: "task", usage: "add|list|show|pause|resume|delete tasks", run: taskCommand},
+	{name: "plugins", usage: "list registered plugins", run: pluginsCommand},
+	{name: "run", usage: "run a task once: run -task NAME -once", run: runCommand},
+	{name: "backfill", usage: "recalculate cycles: backfill -task NAME -from TIME -to TIME", run: backfillCommand},
+}
+
+// Run 执行命令，args 不包含程序的名字，返回进程的退出码，参数错误返回2，执行失败返回1
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("incenses", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", configFilename(), "json config file, default $"+configFileEnv+" or "+defaultConfigFile)
+	logLevel := flags.String("log-level", "error", "log level written to stderr, [debug, info, error]")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: incenses [-config FILE] [-log-level LEVEL] COMMAND [ARGS]\n\ncommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(stderr, "  %-12s %s\n", cmd.name, cmd.usage)
+		}
+		fmt.Fprintf(stderr, "\nflags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	context.SetLog(log.NewLog(log.NewWriterCore(stderr, level)))
+	// ctx 在设置日志之前创建，重新包装之后派生的context 才使用新的日志
+	stdCtx, cancel := gContext.WithCancel(ctx)
+	defer cancel()
+	ctx = context.NewContexts(stdCtx)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	name := flags.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		e := &env{stdout: stdout, stderr: stderr, configFile: *configFile}
+		err := cmd.run(ctx, e, flags.Args()[1:])
+		switch {
+		case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+			return 2
+		case err != nil:
+			fmt.Fprintf(stderr, "incenses %s: %s\n", name, err.Error())
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n", name)
+	flags.Usage()
+	return 2
+}
+
+func configFilename() string {
+	if filename := os.Getenv(configFileEnv); filename != "" {
+		return filename
+	}
+	return defaultConfigFile
+}
+
+// newFlagSet 子命令的参数，错误信息输出到stderr
+func (e *env) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("incenses "+name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	return flags
+}
+
+// parseFlags 解析子命令的参数，失败的时候返回errUsage
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/core"
+	"github.com/rentiansheng/incenses/src/define"
+	lockMemory "github.com/rentiansheng/incenses/src/handle/lock/memory"
+	_ "github.com/rentiansheng/incenses/src/plugins"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+	"github.com/rentiansheng/incenses/src/plugins/outputs"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+type memoryTasks struct {
+	mutex sync.Mutex
+	tasks []define.MetricTask
+}
+
+func (m *memoryTasks) Get(ctx context.Context) ([]define.MetricTask, error) {
+	return m.List(ctx)
+}
+
+func (m *memoryTasks) GetByName(ctx context.Context, name string) (define.MetricTask, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, task := range m.tasks {
+		if task.TaskName == name {
+			return task, nil
+		}
+	}
+	return define.MetricTask{}, define.ErrTaskNotFound
+}
+
+func (m *memoryTasks) TaskDone(ctx context.Context, name string, nextCycleTime, lastFinishTime uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx := range m.tasks {
+		if m.tasks[idx].TaskName == name {
+			m.tasks[idx].TaskStart, m.tasks[idx].LastFinishTime = nextCycleTime, lastFinishTime
+		}
+	}
+	return nil
+}
+
+func (m *memoryTasks) Add(ctx context.Context, info define.MetricTask, extra map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tasks = append(m.tasks, info)
+	return nil
+}
+
+func (m *memoryTasks) ModifyOutputIndexName(ctx context.Context, taskName, indexName string) error {
+	return nil
+}
+
+func (m *memoryTasks) List(ctx context.Context) ([]define.MetricTask, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	tasks := make([]define.MetricTask, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		if task.TaskStatus != define.StatusEnumTypeDelete {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+func (m *memoryTasks) Update(ctx context.Context, info define.MetricTask, user string) error {
+	return nil
+}
+
+func (m *memoryTasks) ChangeStatus(ctx context.Context, name string, from, to define.StatusEnumType, user string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx := range m.tasks {
+		if m.tasks[idx].TaskName != name {
+			continue
+		}
+		if m.tasks[idx].TaskStatus != from && m.tasks[idx].TaskStatus != to {
+			return define.ErrTaskStatusChanged
+		}
+		m.tasks[idx].TaskStatus = to
+		return nil
+	}
+	return define.ErrTaskNotFound
+}
+
+type testCollect struct{}
+
+func (testCollect) Name() string                                    { return "cli_test" }
+func (testCollect) Description() string                             { return "cli test collect" }
+func (testCollect) SetConfig(ctx context.Context, cfg []byte) error { return nil }
+func (testCollect) Keys(ctx context.Context) ([]string, error)      { return []string{"k1"}, nil }
+func (testCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	input <- define.NewRecord("1", map[string]string{}, map[string]float64{})
+	return nil
+}
+
+// failCollect 收集数据总是失败
+type failCollect struct {
+	testCollect
+}
+
+func (failCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	return errors.New("collect error")
+}
+
+var testWrites = make(chan define.OutputData, 100)
+
+type testOutput struct{}
+
+func (testOutput) Name() string                                    { return "cli_test" }
+func (testOutput) Description() string                             { return "" }
+func (testOutput) SetConfig(ctx context.Context, cfg []byte) error { return nil }
+func (testOutput) Exists(ctx context.Context, key string) (bool, error) {
+	return false, nil
+}
+func (testOutput) IndexName(ctx context.Context) (string, error) { return "", nil }
+func (testOutput) SetMetricMetadata(ctx context.Context, data define.MetricMetadata) error {
+	return nil
+}
+func (testOutput) Write(ctx context.Context, data define.OutputData) error {
+	testWrites <- data
+	return nil
+}
+
+func init() {
+	collects.Add("cli_test", func() define.Collect { return testCollect{} })
+	collects.Add("cli_test_fail", func() define.Collect { return failCollect{} })
+	outputs.Add("cli_test", func() define.Output { return testOutput{} })
+}
+
+func newTestEnv(t *testing.T) (*env, *memoryTasks, *bytes.Buffer) {
+	tasks := &memoryTasks{}
+	event, err := core.New(tasks, lockMemory.New())
+	require.NoError(t, err, "new event")
+	stdout := &bytes.Buffer{}
+	return &env{stdout: stdout, stderr: &bytes.Buffer{}, taskHandle: tasks, engine: event}, tasks, stdout
+}
+
+func testTask() define.MetricTask {
+	return define.MetricTask{
+		TaskName:       "cli",
+		TaskCycle:      define.TaskCycleTypeDay,
+		CycleMode:      define.CycleModeTypeEnd,
+		CalculateCycle: 1,
+		Collect:        define.MetricTaskPluginCollectConfig{Name: "cli_test"},
+		Aggregators: define.MetricTaskPluginAggregatorConfigArr{
+			{Name: "count", Config: define.RAWConfig(`{"output_key":"cnt"}`)},
+		},
+		Output: define.MetricTaskPluginOutputConfig{Name: "cli_test"},
+	}
+}
+
+func TestTaskCommand(t *testing.T) {
+	ctx := context.Background()
+	e, tasks, stdout := newTestEnv(t)
+
+	data, err := json.Marshal(testTask())
+	require.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "task.json")
+	require.NoError(t, os.WriteFile(filename, data, 0644))
+	require.NoError(t, taskCommand(ctx, e, []string{"add", "-f", filename, "-user", "ops"}), "add")
+	state := core.TaskState{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &state), stdout.String())
+	require.Equal(t, define.StatusEnumTypeNormal, state.Task.TaskStatus, "default status")
+	require.Equal(t, 1, len(state.Cycles), "cycles")
+	require.NotNil(t, state.Lock, "memory lock inspector")
+	require.Error(t, taskCommand(ctx, e, []string{"add", "-f", filename}), "task exists")
+
+	stdout.Reset()
+	require.NoError(t, taskCommand(ctx, e, []string{"list"}), "list")
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 2, len(lines), stdout.String())
+	require.Equal(t, []string{"cli", "normal", "day", "end", "0"}, strings.Fields(lines[1])[:5])
+
+	require.NoError(t, taskCommand(ctx, e, []string{"pause", "cli"}), "pause")
+	require.Equal(t, define.StatusEnumTypePaused, tasks.tasks[0].TaskStatus)
+	require.NoError(t, taskCommand(ctx, e, []string{"resume", "-user", "ops", "cli"}), "resume")
+	require.Equal(t, define.StatusEnumTypeNormal, tasks.tasks[0].TaskStatus)
+	require.Equal(t, define.ErrTaskNotFound, taskCommand(ctx, e, []string{"show", "nope"}), "show not found")
+	require.Equal(t, errUsage, taskCommand(ctx, e, []string{"show"}), "show without name")
+	require.Equal(t, errUsage, taskCommand(ctx, e, []string{"show", "a", "b"}), "show with two names")
+	require.Equal(t, errUsage, taskCommand(ctx, e, []string{"stop", "cli"}), "unknown action")
+
+	require.NoError(t, taskCommand(ctx, e, []string{"pause", "cli"}), "pause")
+	require.NoError(t, taskCommand(ctx, e, []string{"delete", "cli"}), "delete paused task")
+	require.Equal(t, define.StatusEnumTypeDelete, tasks.tasks[0].TaskStatus)
+	stdout.Reset()
+	require.NoError(t, taskCommand(ctx, e, []string{"list"}), "list")
+	require.Equal(t, 1, len(strings.Split(strings.TrimSpace(stdout.String()), "\n")), "deleted task not listed")
+}
+
+func TestRunCommand(t *testing.T) {
+	ctx := context.Background()
+	e, tasks, stdout := newTestEnv(t)
+	task := testTask()
+	task.TaskStatus = define.StatusEnumTypePaused
+	// 周期还没有结束，手动执行的时候也会计算
+	task.TaskStart = uint64(time.Now().Unix())
+	tasks.tasks = append(tasks.tasks, task)
+
+	require.Equal(t, errUsage, runCommand(ctx, e, []string{"-task", "cli"}), "without -once")
+	require.NoError(t, runCommand(ctx, e, []string{"--task", "cli", "--once"}), "run")
+	require.Contains(t, stdout.String(), "task cli finished")
+	select {
+	case data := <-testWrites:
+		require.Equal(t, "k1", data.MetricKey)
+	default:
+		t.Fatal("task not executed")
+	}
+	require.Equal(t, define.ErrTaskNotFound, runCommand(ctx, e, []string{"-task", "nope", "-once"}), "not found")
+
+	// key 失败，周期没有完成的时候返回错误，命令的退出码不为0
+	task.TaskName = "cli_fail"
+	task.Collect.Name = "cli_test_fail"
+	tasks.tasks = append(tasks.tasks, task)
+	stdout.Reset()
+	require.ErrorIs(t, runCommand(ctx, e, []string{"-task", "cli_fail", "-once"}), core.ErrTaskFailed, "failed run")
+	require.NotContains(t, stdout.String(), "finished")
+}
+
+func TestBackfillCommand(t *testing.T) {
+	ctx := context.Background()
+	e, tasks, stdout := newTestEnv(t)
+	tasks.tasks = append(tasks.tasks, testTask())
+
+	require.Equal(t, errUsage, backfillCommand(ctx, e, []string{"-task", "cli", "-from", "2022-10-01"}), "without -to")
+	require.Error(t, backfillCommand(ctx, e, []string{"-task", "cli", "-from", "2022-10-03", "-to", "2022-10-01"}), "from after to")
+	require.NoError(t, backfillCommand(ctx, e, []string{"-task", "cli", "-from", "2022-10-01", "-to", "2022-10-02T12:00:00+08:00"}))
+	require.Contains(t, stdout.String(), "[2/2] cycle")
+	require.Contains(t, stdout.String(), "backfill cli finished, 2 cycles")
+	for len(testWrites) != 0 {
+		<-testWrites
+	}
+}
+
+func TestPluginsCommand(t *testing.T) {
+	e, _, stdout := newTestEnv(t)
+	require.NoError(t, pluginsCommand(context.Background(), e, []string{"list"}))
+	require.Contains(t, stdout.String(), "collect        cli_test\n")
+	require.Contains(t, stdout.String(), "aggregator     count\n")
+	stdout.Reset()
+	require.NoError(t, pluginsCommand(context.Background(), e, []string{"list", "-v"}))
+	require.Contains(t, stdout.String(), "collect cli_test\ncli test collect\n")
+	require.Equal(t, errUsage, pluginsCommand(context.Background(), e, nil))
+}
+
+func TestParseTime(t *testing.T) {
+	ts, err := parseTime("1664582400")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1664582400), ts)
+	ts, err = parseTime("2022-10-01T00:00:00Z")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1664582400), ts)
+	day, err := time.ParseInLocation("2006-01-02", "2022-10-01", time.Local)
+	require.NoError(t, err)
+	ts, err = parseTime("2022-10-01")
+	require.NoError(t, err)
+	require.Equal(t, uint64(day.Unix()), ts)
+	_, err = parseTime("yesterday")
+	require.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "incenses.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"mysql":{"dsn":"metric:metric@tcp(127.0.0.1:3306)/metric"}}`), 0644))
+	cfg, err := loadConfig(filename)
+	require.NoError(t, err)
+	require.Equal(t, defaultTaskTable, cfg.MySQL.TaskTable, "default task table")
+
+	require.NoError(t, os.WriteFile(filename, []byte(`{"redis":{"addr":"127.0.0.1:6379"}}`), 0644))
+	_, err = loadConfig(filename)
+	require.Error(t, err, "dsn is empty")
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, 2, Run(ctx, nil, stdout, stderr), "no command")
+	require.Contains(t, stderr.String(), "commands:")
+	require.Equal(t, 2, Run(ctx, []string{"nope"}, stdout, stderr), "unknown command")
+	require.Equal(t, 2, Run(ctx, []string{"-log-level", "verbose", "task", "list"}, stdout, stderr), "unknown log level")
+
+	stderr.Reset()
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	require.Equal(t, 1, Run(ctx, []string{"-config", missing, "task", "list"}, stdout, stderr), "config not found")
+	require.Contains(t, stderr.String(), "incenses task: read config file error.")
+	require.Equal(t, 0, Run(ctx, []string{"plugins", "list"}, stdout, stderr), "plugins do not need config")
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/core"
+	historyMysql "github.com/rentiansheng/incenses/src/handle/history/mysql"
+	lockMysql "github.com/rentiansheng/incenses/src/handle/lock/mysql"
+	taskMysql "github.com/rentiansheng/incenses/src/handle/task/mysql"
+	"github.com/rentiansheng/incenses/src/plugins/aggregators"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+	"github.com/rentiansheng/incenses/src/plugins/filters"
+	"github.com/rentiansheng/incenses/src/plugins/metric_filters"
+	"github.com/rentiansheng/incenses/src/plugins/outputs"
+	outputMysql "github.com/rentiansheng/incenses/src/plugins/outputs/mysql"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// initSchema 创建任务表，配置了执行记录和锁的表时一起创建，以及mysql output 的分表，表已经存在的时候补充新增的字段
+func initSchema(ctx context.Context, e *env, args []string) error {
+	if err := parseFlags(e.newFlagSet("init-schema"), args); err != nil {
+		return err
+	}
+	db, err := e.mysql()
+	if err != nil {
+		return err
+	}
+	cfg := *e.cfg
+	// 任务表已经存在的时候补充新增的字段
+	if err := taskMysql.New(db, cfg.MySQL.TaskTable).InitTable(ctx); err != nil {
+		return fmt.Errorf("create task table error. table: %s, err: %w", cfg.MySQL.TaskTable, err)
+	}
+	fmt.Fprintf(e.stdout, "task table %s ready\n", cfg.MySQL.TaskTable)
+	tables := make([]struct{ kind, name, sql string }, 0)
+	if cfg.MySQL.HistoryTable != "" {
+		tables = append(tables, struct{ kind, name, sql string }{
+			kind: "history", name: cfg.MySQL.HistoryTable, sql: historyMysql.CreateTableSQL(cfg.MySQL.HistoryTable)})
+	}
+	if cfg.MySQL.LockTable != "" {
+		tables = append(tables, struct{ kind, name, sql string }{
+			kind: "lock", name: cfg.MySQL.LockTable, sql: lockMysql.CreateTableSQL(cfg.MySQL.LockTable)})
+	}
+	for _, table := range tables {
+		if err := db.Exec(table.sql).Error; err != nil {
+			return fmt.Errorf("create %s table error. table: %s, err: %w", table.kind, table.name, err)
+		}
+		fmt.Fprintf(e.stdout, "%s table %s ready\n", table.kind, table.name)
+	}
+
+	formats := cfg.MySQL.OutputTableFormats
+	if len(formats) == 0 {
+		// 使用默认的表名格式
+		formats = []string{""}
+	}
+	for _, format := range formats {
+		if err := outputMysql.InitTables(ctx, db, format); err != nil {
+			return fmt.Errorf("create output table error. %w", err)
+		}
+	}
+	fmt.Fprintf(e.stdout, "output tables ready, %d tables\n", len(formats)*len(outputMysql.InitSQL(ctx)))
+	return nil
+}
+
+// pluginsCommand 列出已经注册的插件，只有plugins list 一个子命令
+func pluginsCommand(ctx context.Context, e *env, args []string) error {
+	flags := e.newFlagSet("plugins list")
+	verbose := flags.Bool("v", false, "print plugin descriptions")
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(flags.Output(), "usage: incenses plugins list [-v]")
+		return errUsage
+	}
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	plugins := make([]struct{ kind, name, description string }, 0)
+	add := func(kind string, names []string, description func(name string) string) {
+		for _, name := range names {
+			plugins = append(plugins, struct{ kind, name, description string }{kind, name, description(name)})
+		}
+	}
+	add("collect", collects.Names(), func(name string) string { return collects.Get(name).Description() })
+	add("filter", filters.Names(), func(name string) string { return filters.Get(name).Description() })
+	add("aggregator", aggregators.Names(), func(name string) string { return aggregators.Get(name).Description() })
+	add("metric_filter", metric_filters.Names(), func(name string) string { return metric_filters.Get(name).Description() })
+	add("output", outputs.Names(), func(name string) string { return outputs.Get(name).Description() })
+
+	// 描述是多行的文本，不能放在表格中
+	if *verbose {
+		for _, plugin := range plugins {
+			fmt.Fprintf(e.stdout, "%s %s\n%s\n\n", plugin.kind, plugin.name, strings.TrimSpace(plugin.description))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME")
+	for _, plugin := range plugins {
+		fmt.Fprintf(w, "%s\t%s\n", plugin.kind, plugin.name)
+	}
+	return w.Flush()
+}
+
+// runCommand 在当前进程中执行一次任务，周期没有结束的时候也会执行
+func runCommand(ctx context.Context, e *env, args []string) error {
+	flags := e.newFlagSet("run")
+	name := flags.String("task", "", "task name")
+	once := flags.Bool("once", false, "run the task once and exit, required")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *name == "" || !*once || flags.NArg() != 0 {
+		flags.Usage()
+		return errUsage
+	}
+	event, err := e.event()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := event.RunOnce(ctx, *name); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "task %s finished in %s\n", *name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// backfillCommand 重新计算[from, to] 时间范围内的周期，每个周期结束的时候输出进度
+func backfillCommand(ctx context.Context, e *env, args []string) error {
+	flags := e.newFlagSet("backfill")
+	name := flags.String("task", "", "task name")
+	from := flags.String("from", "", "start time, unix seconds, 2006-01-02 or RFC3339")
+	to := flags.String("to", "", "end time, unix seconds, 2006-01-02 or RFC3339")
+	concurrency := flags.Int("concurrency", 1, "cycles calculated at the same time")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *name == "" || *from == "" || *to == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return errUsage
+	}
+	start, err := parseTime(*from)
+	if err != nil {
+		return fmt.Errorf("parse -from error. %w", err)
+	}
+	end, err := parseTime(*to)
+	if err != nil {
+		return fmt.Errorf("parse -to error. %w", err)
+	}
+	if start > end {
+		return fmt.Errorf("-from %s is after -to %s", *from, *to)
+	}
+	event, err := e.event()
+	if err != nil {
+		return err
+	}
+	opt := core.BackfillOption{
+		Concurrency: *concurrency,
+		Progress: func(progress core.BackfillProgress) {
+			result := "ok"
+			if progress.Err != nil {
+				result = "failed: " + progress.Err.Error()
+			}
+			fmt.Fprintf(e.stdout, "[%d/%d] cycle %s - %s %s\n", progress.Finished, progress.Total,
+				formatTime(progress.CycleStart), formatTime(progress.CycleEnd), result)
+		},
+	}
+	progress, err := event.Backfill(ctx, *name, start, end, opt)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "backfill %s finished, %d cycles\n", *name, progress.Total)
+	return nil
+}
+
+// parseTime 支持unix 秒，本地时间的2006-01-02 和RFC3339
+func parseTime(value string) (uint64, error) {
+	if ts, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return ts, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return uint64(t.Unix()), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("unknown time format %q", value)
+	}
+	return uint64(t.Unix()), nil
+}
@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-redis/redis/v9"
+	gormMysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/core"
+	"github.com/rentiansheng/incenses/src/define"
+	historyMysql "github.com/rentiansheng/incenses/src/handle/history/mysql"
+	lockMemory "github.com/rentiansheng/incenses/src/handle/lock/memory"
+	lockMysql "github.com/rentiansheng/incenses/src/handle/lock/mysql"
+	taskMysql "github.com/rentiansheng/incenses/src/handle/task/mysql"
+	outputMysql "github.com/rentiansheng/incenses/src/plugins/outputs/mysql"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+const (
+	// defaultConfigFile 没有指定-config 和INCENSES_CONFIG 的时候使用
+	defaultConfigFile = "incenses.json"
+	configFileEnv     = "INCENSES_CONFIG"
+	defaultTaskTable  = "metric_task_tab"
+)
+
+type config struct {
+	MySQL mysqlConfig `json:"mysql"`
+	// Redis addr 不为空的时候，任务锁，checkpoint 等使用redis
+	Redis redisConfig `json:"redis"`
+}
+
+type mysqlConfig struct {
+	DSN string `json:"dsn"`
+	// TaskTable 任务表，默认metric_task_tab
+	TaskTable string `json:"task_table"`
+	// HistoryTable 执行记录表，为空的时候不记录
+	HistoryTable string `json:"history_table"`
+	// LockTable 任务锁的表，不为空的时候使用mysql 实现的锁
+	LockTable string `json:"lock_table"`
+	// OutputTableFormats mysql output 分表名字的格式，init-schema 使用，为空的时候使用默认的格式
+	OutputTableFormats []string `json:"output_table_formats"`
+}
+
+type redisConfig struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// loadConfig 读取json 配置文件
+func loadConfig(filename string) (config, error) {
+	cfg := config{}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cfg, fmt.Errorf("read config file error. %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("unmarshal config file error. file: %s, err: %w", filename, err)
+	}
+	if cfg.MySQL.DSN == "" {
+		return cfg, errors.New("mysql.dsn is empty")
+	}
+	if cfg.MySQL.TaskTable == "" {
+		cfg.MySQL.TaskTable = defaultTaskTable
+	}
+	return cfg, nil
+}
+
+// engine 命令使用的任务引擎方法
+type engine interface {
+	AddTask(ctx context.Context, taskInfo define.MetricTask, user string) error
+	TaskState(ctx context.Context, name string) (core.TaskState, error)
+	PauseTask(ctx context.Context, name, user string) error
+	ResumeTask(ctx context.Context, name, user string) error
+	DeleteTask(ctx context.Context, name, user string) error
+	RunOnce(ctx context.Context, name string) error
+	Backfill(ctx context.Context, taskName string, start, end uint64, opt core.BackfillOption) (core.BackfillProgress, error)
+}
+
+// env 命令执行的环境，连接在第一次使用的时候创建
+type env struct {
+	stdout     io.Writer
+	stderr     io.Writer
+	configFile string
+	cfg        *config
+	db         *gorm.DB
+	taskHandle define.MetricTaskImpl
+	engine     engine
+}
+
+func (e *env) config() (config, error) {
+	if e.cfg == nil {
+		cfg, err := loadConfig(e.configFile)
+		if err != nil {
+			return cfg, err
+		}
+		e.cfg = &cfg
+	}
+	return *e.cfg, nil
+}
+
+func (e *env) mysql() (*gorm.DB, error) {
+	if e.db != nil {
+		return e.db, nil
+	}
+	cfg, err := e.config()
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(gormMysql.Open(cfg.MySQL.DSN), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("connect mysql error. %w", err)
+	}
+	// output 插件写入同一个数据库
+	outputMysql.SetDB(db)
+	e.db = db
+	return db, nil
+}
+
+func (e *env) tasks() (define.MetricTaskImpl, error) {
+	if e.taskHandle != nil {
+		return e.taskHandle, nil
+	}
+	db, err := e.mysql()
+	if err != nil {
+		return nil, err
+	}
+	e.taskHandle = taskMysql.New(db, e.cfg.MySQL.TaskTable)
+	return e.taskHandle, nil
+}
+
+// event 任务引擎，锁优先使用mysql，其次redis，都没有配置的时候使用内存锁，只适合单机
+func (e *env) event() (engine, error) {
+	if e.engine != nil {
+		return e.engine, nil
+	}
+	taskHandle, err := e.tasks()
+	if err != nil {
+		return nil, err
+	}
+	cfg := *e.cfg
+	var lock define.Lock
+	if cfg.MySQL.LockTable != "" {
+		lock = lockMysql.New(e.db, cfg.MySQL.LockTable)
+	}
+	if cfg.Redis.Addr != "" {
+		core.SetClient(redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		}))
+	} else if lock == nil {
+		lock = lockMemory.New()
+	}
+	event, err := core.New(taskHandle, lock)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MySQL.HistoryTable != "" {
+		event.SetRunHistory(historyMysql.New(e.db, cfg.MySQL.HistoryTable))
+	}
+	e.engine = event
+	return event, nil
+}
@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+const taskUsage = `usage:
+  incenses task add -f FILE [-user USER]     add a task from a json file, - reads stdin
+  incenses task list                         list tasks except deleted ones
+  incenses task show NAME                    show task config, cycles, running and lock state
+  incenses task pause NAME [-user USER]
+  incenses task resume NAME [-user USER]
+  incenses task delete NAME [-user USER]     mark the task deleted, it will not be scheduled
+`
+
+func taskCommand(ctx context.Context, e *env, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(e.stderr, taskUsage)
+		return errUsage
+	}
+	action, args := args[0], args[1:]
+	flags := e.newFlagSet("task " + action)
+	flags.Usage = func() { fmt.Fprint(e.stderr, taskUsage) }
+	operator := flags.String("user", defaultUser(), "operator saved as creator or modifier")
+	file := flags.String("f", "", "task json file, - reads stdin")
+
+	switch action {
+	case "add":
+		if err := parseFlags(flags, args); err != nil {
+			return err
+		}
+		if *file == "" {
+			flags.Usage()
+			return errUsage
+		}
+		taskInfo, err := readTask(*file)
+		if err != nil {
+			return err
+		}
+		event, err := e.event()
+		if err != nil {
+			return err
+		}
+		if err := event.AddTask(ctx, taskInfo, *operator); err != nil {
+			return err
+		}
+		return showTask(ctx, event, taskInfo.TaskName, e.stdout)
+	case "list":
+		if err := parseFlags(flags, args); err != nil {
+			return err
+		}
+		taskHandle, err := e.tasks()
+		if err != nil {
+			return err
+		}
+		tasks, err := taskHandle.List(ctx)
+		if err != nil {
+			return err
+		}
+		writeTaskList(e.stdout, tasks)
+		return nil
+	case "show", "pause", "resume", "delete":
+		name, err := parseNameFlags(flags, args)
+		if err != nil {
+			return err
+		}
+		event, err := e.event()
+		if err != nil {
+			return err
+		}
+		switch action {
+		case "pause":
+			err = event.PauseTask(ctx, name, *operator)
+		case "resume":
+			err = event.ResumeTask(ctx, name, *operator)
+		case "delete":
+			err = event.DeleteTask(ctx, name, *operator)
+		}
+		if err != nil {
+			return err
+		}
+		return showTask(ctx, event, name, e.stdout)
+	}
+	fmt.Fprintf(e.stderr, "unknown task command %q\n", action)
+	flags.Usage()
+	return errUsage
+}
+
+// parseNameFlags 解析NAME [flags]，任务名字可以在参数的前面或者后面
+func parseNameFlags(flags *flag.FlagSet, args []string) (string, error) {
+	name := ""
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return "", err
+	}
+	if name == "" && flags.NArg() == 1 {
+		name = flags.Arg(0)
+	} else if flags.NArg() != 0 {
+		name = ""
+	}
+	if name == "" {
+		flags.Usage()
+		return "", errUsage
+	}
+	return name, nil
+}
+
+func readTask(filename string) (define.MetricTask, error) {
+	taskInfo := define.MetricTask{}
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return taskInfo, fmt.Errorf("read task file error. %w", err)
+	}
+	if err := json.Unmarshal(data, &taskInfo); err != nil {
+		return taskInfo, fmt.Errorf("unmarshal task file error. %w", err)
+	}
+	return taskInfo, nil
+}
+
+func showTask(ctx context.Context, event engine, name string, stdout io.Writer) error {
+	state, err := event.TaskState(ctx, name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
+
+func writeTaskList(stdout io.Writer, tasks []define.MetricTask) {
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tCYCLE\tMODE\tWEIGHT\tTASK_START\tLAST_FINISH")
+	for _, task := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", task.TaskName, statusName(task.TaskStatus),
+			cycleName(task.TaskCycle), cycleModeName(task.CycleMode), task.Weight,
+			formatTime(task.TaskStart), formatTime(task.LastFinishTime))
+	}
+	_ = w.Flush()
+}
+
+func statusName(status define.StatusEnumType) string {
+	switch status {
+	case define.StatusEnumTypeNormal:
+		return "normal"
+	case define.StatusEnumTypePaused:
+		return "paused"
+	case define.StatusEnumTypeDelete:
+		return "deleted"
+	}
+	return fmt.Sprintf("unknown(%d)", status)
+}
+
+func cycleName(cycle define.TaskCycleType) string {
+	switch cycle {
+	case define.TaskCycleTypeYear:
+		return "year"
+	case define.TaskCycleTypeQuarter:
+		return "quarter"
+	case define.TaskCycleTypeMonthly:
+		return "month"
+	case define.TaskCycleTypeWeekly:
+		return "week"
+	case define.TaskCycleTypeDay:
+		return "day"
+	case define.TaskCycleTypeHour:
+		return "hour"
+	}
+	return fmt.Sprintf("unknown(%d)", cycle)
+}
+
+func cycleModeName(mode define.CycleModeType) string {
+	switch mode {
+	case define.CycleModeTypeEnd:
+		return "end"
+	case define.CycleModeTypeInnerDay:
+		return "inner_day"
+	case define.CycleModeTypeAlways:
+		return "always"
+	}
+	return fmt.Sprintf("unknown(%d)", mode)
+}
+
+func formatTime(ts uint64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(int64(ts), 0).Format(time.RFC3339)
+}
+
+// defaultUser 没有指定-user 的时候使用当前系统用户
+func defaultUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "incenses"
+}
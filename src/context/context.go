@@ -23,8 +23,15 @@ const (
 
 var (
 	defaultLog log.LogImpl
+	// defaultLogLevel 没有通过WithLogLevel 设置级别的context 使用的日志级别
+	defaultLogLevel = log.DebugLevel
 )
 
+// logFieldsKey, logLevelKey 日志字段和级别保存在context 的值中，
+// 通过NewContexts 包装派生的标准库context 或者Detach 之后仍然可以获取
+type logFieldsKey struct{}
+type logLevelKey struct{}
+
 type CancelFunc = context.CancelFunc
 
 type Context interface {
@@ -34,7 +41,12 @@ type Context interface {
 	IsDone() bool
 	WithTimeout(timeout time.Duration)
 	WithValue(key string, val interface{})
+	// WithLogFields 返回新的context，输出的日志带上fields，派生的context 继承
+	WithLogFields(fields ...log.FieldImpl) Context
+	// WithLogLevel 返回新的context，低于level 的日志不输出，派生的context 继承
+	WithLogLevel(level log.Level) Context
 
+	// Log 输出的日志带上log_id 和WithLogFields 设置的字段
 	Log() log.LogImpl
 }
 
@@ -46,6 +58,11 @@ func SetLog(log log.LogImpl) {
 	defaultLog = log
 }
 
+// SetLogLevel 修改默认的日志级别，对之后创建的context 生效
+func SetLogLevel(level log.Level) {
+	defaultLogLevel = level
+}
+
 func Background() Context {
 	return NewContexts(context.Background())
 }
@@ -60,17 +77,62 @@ func NewContexts(ctx context.Context) Context {
 
 	var rootID string
 	ctx, rootID = setLogID(ctx, rootIDKey)
-	return &contexts{
+	c := &contexts{
 		ctx:    ctx,
 		log:    defaultLog,
 		rootID: rootID,
 	}
+	c.initLogger()
+	return c
+}
+
+// Detach 生成不会被取消的context，保留原context 中的值和日志。
+// 任务超时或者被取消后，释放锁等清理操作需要继续执行
+// 日志字段和级别在context 的值中，不需要复制
+func Detach(ctx Context) Context {
+	l := defaultLog
+	if c, ok := ctx.(*contexts); ok {
+		l = c.log
+	}
+	if l == nil {
+		l = log.DefaultLog()
+	}
+	c := &contexts{
+		ctx:    detachedContext{parent: ctx},
+		log:    l,
+		rootID: CtxLogID(ctx),
+	}
+	c.initLogger()
+	return c
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (d detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (d detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (d detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
 }
 
 type contexts struct {
 	ctx    context.Context
 	rootID string
 	log    log.LogImpl
+	// logger 带上log_id，日志字段和级别的日志，在创建context 和修改日志字段，级别的时候生成，
+	// Log 直接返回，不需要每次输出日志都重新生成
+	logger log.LogImpl
 }
 
 func (c contexts) SubCtx(prefix string) Context {
@@ -118,21 +180,57 @@ func (c contexts) setRootLogIDPrefix(ctx context.Context, prefix string) Context
 	newC := c.clone()
 	newC.ctx = ctx
 	newC.rootID = requestID
+	newC.initLogger()
+	return newC
+}
+
+func (c contexts) WithLogFields(fields ...log.FieldImpl) Context {
+	old := ctxLogFields(c.ctx)
+	newC := c.clone()
+	newC.ctx = context.WithValue(c.ctx, logFieldsKey{}, append(old[:len(old):len(old)], fields...))
+	newC.initLogger()
+	return newC
+}
+
+func (c contexts) WithLogLevel(level log.Level) Context {
+	newC := c.clone()
+	newC.ctx = context.WithValue(c.ctx, logLevelKey{}, level)
+	newC.initLogger()
 	return newC
 }
 
 func (c contexts) Log() log.LogImpl {
-	return c.log
+	return c.logger
+}
+
+// initLogger 生成Log 返回的日志，SetLogLevel 修改的默认级别对之后创建的context 生效
+func (c *contexts) initLogger() {
+	l := c.log.Field("log_id", c.rootID)
+	if fields := ctxLogFields(c.ctx); len(fields) > 0 {
+		l = l.Fields(fields...)
+	}
+	level, ok := c.ctx.Value(logLevelKey{}).(log.Level)
+	if !ok {
+		level = defaultLogLevel
+	}
+	c.logger = log.WithLevel(l, level)
 }
 
 func (c contexts) clone() *contexts {
 	newC := &contexts{
-		ctx: c.ctx,
-		log: c.log,
+		ctx:    c.ctx,
+		log:    c.log,
+		rootID: c.rootID,
+		logger: c.logger,
 	}
 	return newC
 }
 
+func ctxLogFields(ctx context.Context) []log.FieldImpl {
+	fields, _ := ctx.Value(logFieldsKey{}).([]log.FieldImpl)
+	return fields
+}
+
 func setLogID(ctx context.Context, key string) (context.Context, string) {
 	if ctx == nil {
 		ctx = context.TODO()
@@ -0,0 +1,57 @@
+package context
+
+import (
+	"bytes"
+	osContext "context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context/log"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func TestLogFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	old := defaultLog
+	SetLog(log.NewLog(log.NewWriterCore(buf, log.DebugLevel)))
+	defer SetLog(old)
+
+	ctx := Background().SubCtx("t1").WithLogFields(log.Field("task_name", "t1"))
+	keyCtx := ctx.SubCtx("k1").WithLogFields(log.Field("key", "k1")).WithLogLevel(log.InfoLevel)
+	keyCtx.Log().Debug("dropped")
+	keyCtx.Log().Info("key")
+	// 插件中的标准库context 重新包装后保留字段和级别
+	stdCtx, cancel := osContext.WithCancel(keyCtx)
+	defer cancel()
+	NewContexts(stdCtx).Log().Debug("dropped")
+	NewContexts(stdCtx).Log().Info("wrapped")
+	Detach(keyCtx).Log().Info("detached")
+	ctx.Log().Debug("task")
+
+	lines := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		item := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &item), line)
+		lines = append(lines, item)
+	}
+	require.Equal(t, 4, len(lines), buf.String())
+	for idx, msg := range []string{"key", "wrapped", "detached"} {
+		require.Equal(t, msg, lines[idx]["msg"])
+		require.Equal(t, "t1", lines[idx]["task_name"], msg)
+		require.Equal(t, "k1", lines[idx]["key"], msg)
+		require.Equal(t, CtxLogID(keyCtx), lines[idx]["log_id"], msg)
+	}
+	require.Equal(t, "task", lines[3]["msg"])
+	require.Nil(t, lines[3]["key"], "parent context not changed")
+	require.Equal(t, CtxLogID(ctx), lines[3]["log_id"])
+	require.True(t, strings.HasSuffix(CtxLogID(keyCtx), ":t1:k1"), "sub log id")
+}
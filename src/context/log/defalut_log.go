@@ -21,6 +21,8 @@ var (
 type log struct {
 	fields []FieldImpl
 	log    *zap.Logger
+	// level 低于level 的日志不输出
+	level Level
 }
 
 func DefaultLog() LogImpl {
@@ -31,7 +33,8 @@ func DefaultLog() LogImpl {
 	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(os.Stdout), zapcore.DebugLevel)
 	logger := zap.New(core, zap.WithCaller(true), zap.AddCallerSkip(1))
 	defaultLog = log{
-		log: logger,
+		log:   logger,
+		level: DebugLevel,
 	}
 	return defaultLog
 }
@@ -40,18 +43,25 @@ func NewLog(core zapcore.Core) LogImpl {
 	l := zap.New(core, zap.WithCaller(true), zap.AddCallerSkip(1))
 
 	defaultLog = log{
-		log: l,
+		log:   l,
+		level: DebugLevel,
 	}
 	return defaultLog
 }
 
+// Field 和Fields 限制原来切片的容量，从同一个日志派生的日志不会共用底层数组
 func (l log) Field(key string, val interface{}) LogImpl {
-	l.fields = append(l.fields, Field(key, val))
+	l.fields = append(l.fields[:len(l.fields):len(l.fields)], Field(key, val))
 	return l
 }
 
 func (l log) Fields(fields ...FieldImpl) LogImpl {
-	l.fields = append(l.fields, fields...)
+	l.fields = append(l.fields[:len(l.fields):len(l.fields)], fields...)
+	return l
+}
+
+func (l log) withLevel(level Level) LogImpl {
+	l.level = level
 	return l
 }
 
@@ -64,29 +74,47 @@ func (l log) toZAPFields() []zap.Field {
 }
 
 func (l log) Errorf(format string, args ...interface{}) {
+	if l.level > ErrorLevel {
+		return
+	}
 	msg := fmt.Sprintf(format, args...)
 	l.log.Error(msg, l.toZAPFields()...)
 }
 
 func (l log) Infof(format string, args ...interface{}) {
+	if l.level > InfoLevel {
+		return
+	}
 	msg := fmt.Sprintf(format, args...)
 	l.log.Info(msg, l.toZAPFields()...)
 }
 
 func (l log) Debugf(format string, args ...interface{}) {
+	if l.level > DebugLevel {
+		return
+	}
 	msg := fmt.Sprintf(format, args...)
 	l.log.Debug(msg, l.toZAPFields()...)
 }
 
 func (l log) Error(msg string) {
+	if l.level > ErrorLevel {
+		return
+	}
 	l.log.Error(msg, l.toZAPFields()...)
 }
 
 func (l log) Info(msg string) {
+	if l.level > InfoLevel {
+		return
+	}
 	l.log.Info(msg, l.toZAPFields()...)
 }
 
 func (l log) Debug(msg string) {
+	if l.level > DebugLevel {
+		return
+	}
 	l.log.Debug(msg, l.toZAPFields()...)
 }
 
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// Level 日志级别，值和zapcore.Level 相同
+type Level int8
+
+const (
+	DebugLevel Level = -1
+	InfoLevel  Level = 0
+	ErrorLevel Level = 2
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", l)
+}
+
+// ParseLevel 支持debug, info, error，不区分大小写
+func ParseLevel(text string) (Level, error) {
+	switch strings.ToLower(text) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return DebugLevel, fmt.Errorf("unknown log level %q", text)
+}
+
+// levelLog 自己可以按照级别过滤的LogImpl，不需要包装，包装后日志中的调用位置不正确
+type levelLog interface {
+	withLevel(level Level) LogImpl
+}
+
+// leveled 丢弃低于level 的日志，Panicf 总是输出
+type leveled struct {
+	LogImpl
+	level Level
+}
+
+// WithLevel 返回只输出大于等于level 的日志的LogImpl。
+// 只能在l 的基础上减少输出，l 的输出端没有输出的级别不会因此输出
+func WithLevel(l LogImpl, level Level) LogImpl {
+	if ll, ok := l.(levelLog); ok {
+		return ll.withLevel(level)
+	}
+	if inner, ok := l.(leveled); ok {
+		l = inner.LogImpl
+	}
+	if level <= DebugLevel {
+		return l
+	}
+	return leveled{LogImpl: l, level: level}
+}
+
+func (l leveled) Field(key string, val interface{}) LogImpl {
+	return leveled{LogImpl: l.LogImpl.Field(key, val), level: l.level}
+}
+
+func (l leveled) Fields(fields ...FieldImpl) LogImpl {
+	return leveled{LogImpl: l.LogImpl.Fields(fields...), level: l.level}
+}
+
+func (l leveled) Errorf(format string, args ...interface{}) {
+	if l.level <= ErrorLevel {
+		l.LogImpl.Errorf(format, args...)
+	}
+}
+
+func (l leveled) Infof(format string, args ...interface{}) {
+	if l.level <= InfoLevel {
+		l.LogImpl.Infof(format, args...)
+	}
+}
+
+func (l leveled) Debugf(format string, args ...interface{}) {
+	if l.level <= DebugLevel {
+		l.LogImpl.Debugf(format, args...)
+	}
+}
+
+func (l leveled) Error(msg string) {
+	if l.level <= ErrorLevel {
+		l.LogImpl.Error(msg)
+	}
+}
+
+func (l leveled) Info(msg string) {
+	if l.level <= InfoLevel {
+		l.LogImpl.Info(msg)
+	}
+}
+
+func (l leveled) Debug(msg string) {
+	if l.level <= DebugLevel {
+		l.LogImpl.Debug(msg)
+	}
+}
+
+var _ LogImpl = leveled{}
@@ -0,0 +1,138 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	lines := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		item := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &item), line)
+		lines = append(lines, item)
+	}
+	return lines
+}
+
+func TestParseLevel(t *testing.T) {
+	for text, level := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "Error": ErrorLevel} {
+		parsed, err := ParseLevel(text)
+		require.NoError(t, err, text)
+		require.Equal(t, level, parsed, text)
+	}
+	_, err := ParseLevel("warn")
+	require.Error(t, err, "unknown level")
+	require.Equal(t, "info", InfoLevel.String())
+}
+
+func TestWithLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	base := NewLog(NewWriterCore(buf, DebugLevel)).Field("task_name", "t1")
+	l := WithLevel(base, InfoLevel)
+	l.Debugf("debug %d", 1)
+	l.Field("key", "k1").Infof("info %d", 2)
+	WithLevel(l, DebugLevel).Debug("debug again")
+	WithLevel(base, ErrorLevel).Info("dropped")
+
+	lines := decodeLines(t, buf)
+	require.Equal(t, 2, len(lines), buf.String())
+	require.Equal(t, "info 2", lines[0]["msg"])
+	require.Equal(t, "t1", lines[0]["task_name"])
+	require.Equal(t, "k1", lines[0]["key"])
+	require.Contains(t, lines[0]["caller"], "log_test.go", "caller is not the wrapper")
+	require.Equal(t, "debug again", lines[1]["msg"], "lower level again")
+	require.Nil(t, lines[1]["key"], "fields not shared")
+}
+
+type memoryLog struct {
+	LogImpl
+	msgs *[]string
+}
+
+func (m memoryLog) Field(string, interface{}) LogImpl { return m }
+func (m memoryLog) Debug(msg string)                  { *m.msgs = append(*m.msgs, msg) }
+func (m memoryLog) Info(msg string)                   { *m.msgs = append(*m.msgs, msg) }
+func (m memoryLog) Error(msg string)                  { *m.msgs = append(*m.msgs, msg) }
+
+func TestWithLevelWrapper(t *testing.T) {
+	msgs := make([]string, 0)
+	l := WithLevel(memoryLog{msgs: &msgs}, InfoLevel)
+	l.Debug("debug")
+	l.Field("k", "v").Info("info")
+	WithLevel(l, ErrorLevel).Info("dropped")
+	WithLevel(l, DebugLevel).Debug("debug again")
+	require.Equal(t, []string{"info", "debug again"}, msgs)
+}
+
+func TestRotateFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "logs", "incenses.log")
+	file, err := NewRotateFile(filename, 10, 2)
+	require.NoError(t, err, "new rotate file")
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err, "write")
+	}
+	require.NoError(t, file.Close(), "close")
+	_, err = file.Write([]byte("closed\n"))
+	require.Error(t, err, "write after close")
+
+	for name, content := range map[string]string{
+		filename:        "dddddd\n",
+		filename + ".1": "cccccc\n",
+		filename + ".2": "bbbbbb\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err, name)
+		require.Equal(t, content, string(data), name)
+	}
+	_, err = os.Stat(filename + ".3")
+	require.True(t, os.IsNotExist(err), "max backups")
+
+	// 重新打开的时候从已有的大小开始计算
+	file, err = NewRotateFile(filename, 10, 0)
+	require.NoError(t, err, "reopen")
+	_, err = file.Write([]byte("eeeeee\n"))
+	require.NoError(t, err, "write")
+	require.NoError(t, file.Close(), "close")
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "eeeeee\n", string(data), "no backup")
+	data, err = os.ReadFile(filename + ".1")
+	require.NoError(t, err)
+	require.Equal(t, "cccccc\n", string(data), "backups untouched")
+}
+
+func TestFileCore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "incenses.log")
+	core, file, err := NewFileCore(filename, 1024, 1, InfoLevel)
+	require.NoError(t, err, "new file core")
+	l := NewLog(core)
+	l.Debug("dropped")
+	l.Error("saved")
+	l.Sync()
+	require.NoError(t, file.Close())
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := decodeLines(t, bytes.NewBuffer(data))
+	require.Equal(t, 1, len(lines), string(data))
+	require.Equal(t, "saved", lines[0]["msg"])
+	require.Equal(t, "error", lines[0]["level"])
+}
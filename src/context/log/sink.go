@@ -0,0 +1,152 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// NewWriterCore json 格式写入w，低于level 的日志不输出。多个输出端使用zapcore.NewTee 合并后传给NewLog
+func NewWriterCore(w io.Writer, level Level) zapcore.Core {
+	return zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(w), zapcore.Level(level))
+}
+
+// NewStderrCore 输出到标准错误
+func NewStderrCore(level Level) zapcore.Core {
+	return NewWriterCore(zapcore.Lock(os.Stderr), level)
+}
+
+// NewFileCore 输出到按照大小切割的文件，参数见NewRotateFile。程序退出前需要调用返回的RotateFile 的Close
+func NewFileCore(filename string, maxSize int64, maxBackups int, level Level) (zapcore.Core, *RotateFile, error) {
+	file, err := NewRotateFile(filename, maxSize, maxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewWriterCore(file, level), file, nil
+}
+
+// RotateFile 按照大小切割的日志文件。写入后超过maxSize 时，当前文件重命名为filename.1，
+// 之前的备份依次重命名为filename.2, filename.3 ...，最多保留maxBackups 个备份
+type RotateFile struct {
+	mutex      sync.Mutex
+	filename   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotateFile maxSize 单位字节，小于等于0 的时候不切割。maxBackups 为0 的时候切割后不保留备份
+func NewRotateFile(filename string, maxSize int64, maxBackups int) (*RotateFile, error) {
+	r := &RotateFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, fmt.Errorf("create log dir error. %w", err)
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotateFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil && r.file == nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotateFile) Sync() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+func (r *RotateFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotateFile) open() error {
+	file, err := os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open log file error. %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("stat log file error. %w", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate 备份从后往前重命名，超过maxBackups 的备份被覆盖。重命名失败的时候重新打开当前文件继续写入
+func (r *RotateFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("close log file error. %w", err)
+	}
+	r.file = nil
+	renameErr := r.shiftBackups()
+	if err := r.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+func (r *RotateFile) shiftBackups() error {
+	if r.maxBackups <= 0 {
+		if err := os.Remove(r.filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove log file error. %w", err)
+		}
+		return nil
+	}
+	for idx := r.maxBackups - 1; idx > 0; idx-- {
+		err := os.Rename(r.backupName(idx), r.backupName(idx+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rename log backup file error. %w", err)
+		}
+	}
+	if err := os.Rename(r.filename, r.backupName(1)); err != nil {
+		return fmt.Errorf("rename log file error. %w", err)
+	}
+	return nil
+}
+
+func (r *RotateFile) backupName(idx int) string {
+	return fmt.Sprintf("%s.%d", r.filename, idx)
+}
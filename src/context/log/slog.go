@@ -0,0 +1,114 @@
+//go:build go1.21
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// slogLog 通过log/slog 输出日志
+type slogLog struct {
+	logger *slog.Logger
+	fields []FieldImpl
+	level  Level
+}
+
+// NewSlogLog 日志交给logger 的Handler 处理，可以和使用slog 的程序共用输出端
+func NewSlogLog(logger *slog.Logger) LogImpl {
+	return slogLog{
+		logger: logger,
+		level:  DebugLevel,
+	}
+}
+
+func (l slogLog) Field(key string, val interface{}) LogImpl {
+	l.fields = append(l.fields[:len(l.fields):len(l.fields)], Field(key, val))
+	return l
+}
+
+func (l slogLog) Fields(fields ...FieldImpl) LogImpl {
+	l.fields = append(l.fields[:len(l.fields):len(l.fields)], fields...)
+	return l
+}
+
+func (l slogLog) withLevel(level Level) LogImpl {
+	l.level = level
+	return l
+}
+
+func (l slogLog) Sync() {}
+
+func (l slogLog) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (l slogLog) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (l slogLog) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (l slogLog) Error(msg string) {
+	l.log(ErrorLevel, msg)
+}
+
+func (l slogLog) Info(msg string) {
+	l.log(InfoLevel, msg)
+}
+
+func (l slogLog) Debug(msg string) {
+	l.log(DebugLevel, msg)
+}
+
+func (l slogLog) Panicf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.log(ErrorLevel, msg)
+	panic(msg)
+}
+
+// log 直接生成slog.Record，调用位置是调用LogImpl 方法的地方
+func (l slogLog) log(level Level, msg string) {
+	if level < l.level {
+		return
+	}
+	ctx := context.Background()
+	slogLevel := toSlogLevel(level)
+	if !l.logger.Enabled(ctx, slogLevel) {
+		return
+	}
+	var pcs [1]uintptr
+	// 跳过runtime.Callers, log 和LogImpl 的方法
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), slogLevel, msg, pcs[0])
+	for _, field := range l.fields {
+		key, val := field.Field()
+		record.AddAttrs(slog.Any(key, val))
+	}
+	_ = l.logger.Handler().Handle(ctx, record)
+}
+
+func toSlogLevel(level Level) slog.Level {
+	switch {
+	case level <= DebugLevel:
+		return slog.LevelDebug
+	case level <= InfoLevel:
+		return slog.LevelInfo
+	default:
+		return slog.LevelError
+	}
+}
+
+var _ LogImpl = slogLog{}
@@ -0,0 +1,44 @@
+//go:build go1.21
+
+package log
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func TestSlogLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, Level: slog.LevelInfo})
+	base := NewSlogLog(slog.New(handler)).Field("task_name", "t1")
+	base.Debug("dropped by handler")
+	base.Field("key", "k1").Infof("info %d", 1)
+	WithLevel(base, ErrorLevel).Info("dropped by level")
+	base.Error("failed")
+	require.Panics(t, func() {
+		base.Panicf("panic %s", "msg")
+	})
+
+	lines := decodeLines(t, buf)
+	require.Equal(t, 3, len(lines), buf.String())
+	require.Equal(t, "info 1", lines[0]["msg"])
+	require.Equal(t, "INFO", lines[0]["level"])
+	require.Equal(t, "t1", lines[0]["task_name"])
+	require.Equal(t, "k1", lines[0]["key"])
+	source, _ := lines[0]["source"].(map[string]interface{})
+	require.Contains(t, source["file"], "slog_test.go", "source is the caller")
+	require.Equal(t, "failed", lines[1]["msg"])
+	require.Nil(t, lines[1]["key"], "fields not shared")
+	require.Equal(t, "panic msg", lines[2]["msg"])
+	require.Equal(t, "ERROR", lines[2]["level"])
+}
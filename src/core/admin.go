@@ -0,0 +1,513 @@
+package core
+
+import (
+	gContext "context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+var (
+	// ErrTaskRunning 任务正在执行或者等待执行
+	ErrTaskRunning = errors.New("task is running")
+	// ErrTaskNotRunning 任务没有在当前节点上执行
+	ErrTaskNotRunning = errors.New("task is not running")
+	// ErrEventNotStarted 引擎没有Start
+	ErrEventNotStarted = errors.New("event not started")
+	// ErrTaskExists 添加任务的时候，同名的任务已经存在
+	ErrTaskExists = errors.New("metric task already exists")
+	// ErrTaskInvalid 任务配置错误
+	ErrTaskInvalid = errors.New("metric task invalid")
+	// ErrAdminUnauthorized 任务管理的请求没有通过认证
+	ErrAdminUnauthorized = errors.New("admin request unauthorized")
+)
+
+const (
+	// adminUserHeader 请求中操作人的header，没有的时候使用defaultAdminUser
+	adminUserHeader  = "X-Incenses-User"
+	defaultAdminUser = "admin"
+)
+
+// AdminAuthFunc 任务管理请求的认证，返回请求的操作人，认证失败的时候返回错误
+type AdminAuthFunc func(r *http.Request) (user string, err error)
+
+// AdminTokenAuth 使用固定token 的认证，请求需要带Authorization: Bearer {token}，
+// 操作人来自X-Incenses-User header，没有的时候使用admin
+func AdminTokenAuth(token string) AdminAuthFunc {
+	return func(r *http.Request) (string, error) {
+		auth := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			return "", ErrAdminUnauthorized
+		}
+		return headerAdminUser(r), nil
+	}
+}
+
+// runningTask 当前节点正在执行的任务
+type runningTask struct {
+	runID     string
+	manual    bool
+	startTime time.Time
+	cancel    context.CancelFunc
+}
+
+// runningTasks 当前节点正在执行的任务，手动执行和周期调度可能同时执行同一个任务，每次执行单独记录
+type runningTasks struct {
+	mutex sync.Mutex
+	tasks map[string][]*runningTask
+}
+
+func (r *runningTasks) add(name string, run *runningTask) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.tasks == nil {
+		r.tasks = make(map[string][]*runningTask)
+	}
+	r.tasks[name] = append(r.tasks[name], run)
+}
+
+// remove 只删除run 对应的记录，同一个任务其他执行的记录不受影响
+func (r *runningTasks) remove(name string, run *runningTask) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	runs := r.tasks[name]
+	for idx := range runs {
+		if runs[idx] == run {
+			runs = append(runs[:idx:idx], runs[idx+1:]...)
+			break
+		}
+	}
+	if len(runs) == 0 {
+		delete(r.tasks, name)
+		return
+	}
+	r.tasks[name] = runs
+}
+
+func (r *runningTasks) list(name string) []*runningTask {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*runningTask{}, r.tasks[name]...)
+}
+
+// RunningTask 正在执行任务的信息
+type RunningTask struct {
+	RunID string `json:"run_id"`
+	// RunType schedule 周期调度，manual 手动触发
+	RunType string `json:"run_type"`
+	// StartTime 开始执行的时间，单位秒
+	StartTime int64 `json:"start_time"`
+}
+
+// TaskCycle 任务下次执行时计算的周期
+type TaskCycle struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// TaskState 任务的配置和当前的执行状态
+type TaskState struct {
+	Task define.MetricTask `json:"task"`
+	// Cycles 下次执行时计算的周期，第一个是最新的周期
+	Cycles []TaskCycle `json:"cycles"`
+	// Running 当前节点上正在执行的情况，按开始时间排序，没有执行的时候为空
+	Running []RunningTask `json:"running"`
+	// Lock 任务锁的持有情况，锁没有实现define.LockInspector 的时候为nil
+	Lock *define.LockInfo `json:"lock"`
+}
+
+// SetAdminAddr Start 的时候在addr 上启动任务管理的http 服务，Stop 的时候关闭。
+// 没有SetAdminAuth 的时候只能监听本机地址，例如127.0.0.1:8081，否则Start 返回错误
+func (e *event) SetAdminAddr(addr string) {
+	e.adminAddr = addr
+}
+
+// SetAdminAuth 任务管理请求的认证，认证失败的请求返回401。
+// 没有设置的时候不做认证，操作人来自X-Incenses-User header，只适合本机访问。
+// POST, PUT 请求的Content-Type 必须是application/json，否则返回415
+func (e *event) SetAdminAuth(auth AdminAuthFunc) {
+	e.adminAuth = auth
+}
+
+// startAdminServer 调用方需要持有e.mutex
+func (e *event) startAdminServer(ctx context.Context) error {
+	if e.adminAddr == "" {
+		return nil
+	}
+	if e.adminAuth == nil && !isLoopbackAddr(e.adminAddr) {
+		return fmt.Errorf("admin server without auth must listen on loopback address. addr: %s", e.adminAddr)
+	}
+	listener, err := net.Listen("tcp", e.adminAddr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: e.AdminHandler()}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ctx.Log().Errorf("admin server error. addr: %s, err: %s", e.adminAddr, err.Error())
+		}
+	}()
+	e.adminServer = server
+	return nil
+}
+
+// isLoopbackAddr addr 是否只监听本机地址，host 为空的时候监听所有地址
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// stopAdminServer 调用方需要持有e.mutex
+func (e *event) stopAdminServer() {
+	if e.adminServer == nil {
+		return
+	}
+	_ = e.adminServer.Close()
+	e.adminServer = nil
+}
+
+// TriggerTask 立即执行一次任务，周期没有结束的时候也会执行，执行记录的类型是define.RunTypeManual。
+// 暂停的任务也可以手动执行，需要先Start
+func (e *event) TriggerTask(ctx context.Context, name string) error {
+	e.mutex.Lock()
+	queueCtx, taskCtx := e.queueCtx, e.taskCtx
+	e.mutex.Unlock()
+	if queueCtx == nil {
+		return ErrEventNotStarted
+	}
+	taskInfo, err := e.taskHandle.GetByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if taskInfo.TaskStatus == define.StatusEnumTypeDelete {
+		return define.ErrTaskNotFound
+	}
+	submitted := e.scheduler.Submit(queueCtx, name, int(taskInfo.Weight), func(gContext.Context) {
+		if err := e.runTask(taskCtx, taskInfo, true); err != nil {
+			taskCtx.Log().Field("task", taskInfo).Errorf("manual task execute error. err: %s", err.Error())
+		}
+	})
+	if !submitted {
+		return ErrTaskRunning
+	}
+	return nil
+}
+
+// RunOnce 在当前协程中执行一次任务，不需要Start，周期没有结束的时候也会执行。
+// 其他节点正在执行的时候返回ErrTaskRunning
+func (e *event) RunOnce(ctx context.Context, name string) error {
+	taskInfo, err := e.taskHandle.GetByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if taskInfo.TaskStatus == define.StatusEnumTypeDelete {
+		return define.ErrTaskNotFound
+	}
+	return e.runTask(ctx, taskInfo, true)
+}
+
+// CancelTask 取消当前节点上任务所有正在执行的，其他节点上的执行不受影响
+func (e *event) CancelTask(name string) error {
+	runs := e.running.list(name)
+	if len(runs) == 0 {
+		return ErrTaskNotRunning
+	}
+	for _, run := range runs {
+		run.cancel()
+	}
+	return nil
+}
+
+// TaskState 任务的配置，下次执行时计算的周期，当前节点上的执行情况和任务锁的持有情况
+func (e *event) TaskState(ctx context.Context, name string) (TaskState, error) {
+	taskInfo, err := e.taskHandle.GetByName(ctx, name)
+	if err != nil {
+		return TaskState{}, err
+	}
+	state := TaskState{Task: taskInfo, Cycles: make([]TaskCycle, 0, taskInfo.CalculateCycle)}
+	cycles, err := e.initTaskInstanceCycles(ctx, taskInfo)
+	if err != nil {
+		return state, err
+	}
+	for _, cycle := range cycles {
+		state.Cycles = append(state.Cycles, TaskCycle{Start: cycle.Begin, End: cycle.End})
+	}
+	for _, running := range e.running.list(name) {
+		runType := define.RunTypeSchedule
+		if running.manual {
+			runType = define.RunTypeManual
+		}
+		state.Running = append(state.Running, RunningTask{
+			RunID:     running.runID,
+			RunType:   runTypeName(runType),
+			StartTime: running.startTime.Unix(),
+		})
+	}
+	if inspector, ok := e.lock.(define.LockInspector); ok {
+		info, err := inspector.Inspect(ctx, define.LockKeyPrefix+name)
+		if err != nil {
+			return state, fmt.Errorf("inspect task lock error. %w", err)
+		}
+		state.Lock = &info
+	}
+	return state, nil
+}
+
+// validateTask 检查任务的配置和插件的配置
+func (e *event) validateTask(ctx context.Context, taskInfo define.MetricTask) error {
+	if err := taskInfo.Validate(); err != nil {
+		return fmt.Errorf("%w. %s", ErrTaskInvalid, err.Error())
+	}
+	if _, err := e.taskParams(ctx, taskInfo); err != nil {
+		return fmt.Errorf("%w. plugin config error. %s", ErrTaskInvalid, err.Error())
+	}
+	return nil
+}
+
+// AddTask 检查任务配置后添加任务，任务状态为空的时候为正常，开始时间为空的时候使用当前时间
+func (e *event) AddTask(ctx context.Context, taskInfo define.MetricTask, user string) error {
+	if taskInfo.TaskStatus == 0 {
+		taskInfo.TaskStatus = define.StatusEnumTypeNormal
+	}
+	if taskInfo.TaskStart == 0 {
+		taskInfo.TaskStart = uint64(time.Now().Unix())
+	}
+	if err := e.validateTask(ctx, taskInfo); err != nil {
+		return err
+	}
+	if _, err := e.taskHandle.GetByName(ctx, taskInfo.TaskName); err == nil {
+		return ErrTaskExists
+	} else if !errors.Is(err, define.ErrTaskNotFound) {
+		return err
+	}
+	now := time.Now().Unix()
+	extra := map[string]interface{}{
+		"creator":  user,
+		"modifier": user,
+		"ctime":    now,
+		"mtime":    now,
+	}
+	return e.taskHandle.Add(ctx, taskInfo, extra)
+}
+
+// UpdateTask 检查任务配置后修改任务，不修改任务的状态和周期状态
+func (e *event) UpdateTask(ctx context.Context, taskInfo define.MetricTask, user string) error {
+	old, err := e.taskHandle.GetByName(ctx, taskInfo.TaskName)
+	if err != nil {
+		return err
+	}
+	if old.TaskStatus == define.StatusEnumTypeDelete {
+		return define.ErrTaskNotFound
+	}
+	taskInfo.TaskStatus, taskInfo.TaskStart = old.TaskStatus, old.TaskStart
+	taskInfo.LastFinishTime, taskInfo.OutputIndexName = old.LastFinishTime, old.OutputIndexName
+	if err := e.validateTask(ctx, taskInfo); err != nil {
+		return err
+	}
+	return e.taskHandle.Update(ctx, taskInfo, user)
+}
+
+// PauseTask 暂停任务，正在执行的任务不受影响
+func (e *event) PauseTask(ctx context.Context, name, user string) error {
+	return e.taskHandle.ChangeStatus(ctx, name, define.StatusEnumTypeNormal, define.StatusEnumTypePaused, user)
+}
+
+// ResumeTask 恢复暂停的任务
+func (e *event) ResumeTask(ctx context.Context, name, user string) error {
+	return e.taskHandle.ChangeStatus(ctx, name, define.StatusEnumTypePaused, define.StatusEnumTypeNormal, user)
+}
+
+// DeleteTask 任务状态修改为待删除，不再调度执行
+func (e *event) DeleteTask(ctx context.Context, name, user string) error {
+	err := e.taskHandle.ChangeStatus(ctx, name, define.StatusEnumTypeNormal, define.StatusEnumTypeDelete, user)
+	if errors.Is(err, define.ErrTaskStatusChanged) {
+		err = e.taskHandle.ChangeStatus(ctx, name, define.StatusEnumTypePaused, define.StatusEnumTypeDelete, user)
+	}
+	return err
+}
+
+// AdminHandler 任务管理的http.Handler，可以挂载到调用方的http 服务中。请求和返回都是json，
+// 失败的时候返回{"error": "..."}。设置了SetAdminAuth 的时候，操作人是认证返回的用户，
+// 没有设置的时候不做认证，操作人来自X-Incenses-User header，挂载到对外的服务中时需要调用方认证。
+//
+//	GET  /tasks                     全部任务
+//	POST /tasks                     添加任务
+//	GET  /tasks/{name}              任务配置和执行状态
+//	PUT  /tasks/{name}              修改任务
+//	POST /tasks/{name}/pause        暂停任务
+//	POST /tasks/{name}/resume       恢复任务
+//	POST /tasks/{name}/trigger      立即执行一次
+//	POST /tasks/{name}/cancel       取消当前节点上的执行
+func (e *event) AdminHandler() http.Handler {
+	return http.HandlerFunc(e.serveAdmin)
+}
+
+func (e *event) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx := context.NewContexts(r.Context()).SubCtx("admin")
+	user := headerAdminUser(r)
+	if e.adminAuth != nil {
+		var err error
+		if user, err = e.adminAuth(r); err != nil {
+			ctx.Log().Errorf("admin request unauthorized. path: %s, remote: %s, err: %s", r.URL.Path, r.RemoteAddr, err.Error())
+			writeAdminError(w, http.StatusUnauthorized, ErrAdminUnauthorized)
+			return
+		}
+	}
+	// 修改状态的请求只接受json，浏览器跨站发送json 需要CORS 预检，防止没有认证时的CSRF
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !isAdminJSONRequest(r) {
+		writeAdminError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+		return
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "tasks" || len(parts) > 3 {
+		writeAdminError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		tasks, err := e.taskHandle.List(ctx)
+		if err != nil {
+			writeAdminError(w, adminErrorStatus(err), err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, tasks)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		taskInfo := define.MetricTask{}
+		if err := json.NewDecoder(r.Body).Decode(&taskInfo); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("decode task error. %w", err))
+			return
+		}
+		if err := e.AddTask(ctx, taskInfo, user); err != nil {
+			writeAdminError(w, adminErrorStatus(err), err)
+			return
+		}
+		e.writeTaskState(ctx, w, http.StatusCreated, taskInfo.TaskName)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		e.writeTaskState(ctx, w, http.StatusOK, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodPut:
+		taskInfo := define.MetricTask{}
+		if err := json.NewDecoder(r.Body).Decode(&taskInfo); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("decode task error. %w", err))
+			return
+		}
+		if taskInfo.TaskName == "" {
+			taskInfo.TaskName = parts[1]
+		}
+		if taskInfo.TaskName != parts[1] {
+			writeAdminError(w, http.StatusBadRequest, errors.New("task name can not be changed"))
+			return
+		}
+		if err := e.UpdateTask(ctx, taskInfo, user); err != nil {
+			writeAdminError(w, adminErrorStatus(err), err)
+			return
+		}
+		e.writeTaskState(ctx, w, http.StatusOK, parts[1])
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		e.serveTaskAction(ctx, w, parts[1], parts[2], user)
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// serveTaskAction 处理任务的pause, resume, trigger, cancel
+func (e *event) serveTaskAction(ctx context.Context, w http.ResponseWriter, name, action, user string) {
+	var err error
+	status := http.StatusOK
+	switch action {
+	case "pause":
+		err = e.PauseTask(ctx, name, user)
+	case "resume":
+		err = e.ResumeTask(ctx, name, user)
+	case "trigger":
+		err = e.TriggerTask(ctx, name)
+		status = http.StatusAccepted
+	case "cancel":
+		err = e.CancelTask(name)
+	default:
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	if err != nil {
+		ctx.Log().Errorf("task %s error. name: %s, user: %s, err: %s", action, name, user, err.Error())
+		writeAdminError(w, adminErrorStatus(err), err)
+		return
+	}
+	ctx.Log().Infof("task %s. name: %s, user: %s", action, name, user)
+	e.writeTaskState(ctx, w, status, name)
+}
+
+func (e *event) writeTaskState(ctx context.Context, w http.ResponseWriter, status int, name string) {
+	state, err := e.TaskState(ctx, name)
+	if err != nil {
+		writeAdminError(w, adminErrorStatus(err), err)
+		return
+	}
+	writeAdminJSON(w, status, state)
+}
+
+// adminErrorStatus 错误对应的http 状态码，任务配置错误以外的未知错误返回500
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, define.ErrTaskNotFound), errors.Is(err, ErrTaskNotRunning):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTaskExists), errors.Is(err, ErrTaskRunning), errors.Is(err, define.ErrTaskStatusChanged):
+		return http.StatusConflict
+	case errors.Is(err, ErrEventNotStarted):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrTaskInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// isAdminJSONRequest 请求的Content-Type 是否为application/json
+func isAdminJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// headerAdminUser 请求中X-Incenses-User header 的操作人，没有的时候使用admin
+func headerAdminUser(r *http.Request) string {
+	user := r.Header.Get(adminUserHeader)
+	if user == "" {
+		user = defaultAdminUser
+	}
+	return user
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	gContext "context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// blockHooks BeforeKey 阻塞到执行被取消
+type blockHooks struct {
+	define.NopHooks
+	once    sync.Once
+	started chan struct{}
+}
+
+func (h *blockHooks) BeforeKey(ctx context.Context, info define.HookInfo) error {
+	h.once.Do(func() { close(h.started) })
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestAdminAuth(t *testing.T) {
+	e, _ := newTestEvent(t, newTestTask("core_test_admin_auth", &testCollect{keys: []string{"k1"}, records: 1}, newTestSink()))
+
+	e.SetAdminAddr(":0")
+	require.Error(t, e.Start(gContext.Background()), "listen on all address without auth")
+	e.SetAdminAddr("127.0.0.1:0")
+	require.NoError(t, e.Start(gContext.Background()), "listen on loopback without auth")
+	require.NoError(t, e.Stop(time.Second), "stop")
+
+	e.SetAdminAuth(AdminTokenAuth("secret"))
+	handler := e.AdminHandler()
+	tests := []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer other", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+	for idx, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		require.Equal(t, tt.status, resp.Code, "status. index: %d", idx)
+	}
+}
+
+func TestAdminContentType(t *testing.T) {
+	name := "core_test_admin_content_type"
+	e, tasks := newTestEvent(t, newTestTask(name, &testCollect{keys: []string{"k1"}, records: 1}, newTestSink()))
+	handler := e.AdminHandler()
+	tests := []struct {
+		contentType string
+		status      int
+		paused      bool
+	}{
+		{"", http.StatusUnsupportedMediaType, false},
+		{"text/plain", http.StatusUnsupportedMediaType, false},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType, false},
+		{"application/json; charset=utf-8", http.StatusOK, true},
+	}
+	for idx, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+name+"/pause", nil)
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		require.Equal(t, tt.status, resp.Code, "status. index: %d", idx)
+		taskInfo, err := tasks.GetByName(context.Background(), name)
+		require.NoError(t, err, "get task. index: %d", idx)
+		require.Equal(t, tt.paused, taskInfo.TaskStatus == define.StatusEnumTypePaused, "paused. index: %d", idx)
+	}
+}
+
+func TestRunningPerRun(t *testing.T) {
+	name := "core_test_running_per_run"
+	e, _ := newTestEvent(t, newTestTask(name, &testCollect{keys: []string{"k1"}, records: 1}, newTestSink()))
+	hooks := &blockHooks{started: make(chan struct{})}
+	e.AddHooks(hooks)
+	history := &memoryHistory{}
+	e.SetRunHistory(history)
+
+	ctx := context.Background()
+	done := make(chan error, 1)
+	go func() { done <- e.RunOnce(ctx, name) }()
+	select {
+	case <-hooks.started:
+	case <-time.After(time.Second * 10):
+		t.Fatal("task not started")
+	}
+
+	// 重叠的执行没有获取到任务锁，结束的时候不能删除正在执行的记录
+	require.Equal(t, ErrTaskRunning, e.RunOnce(ctx, name), "overlap run")
+	state, err := e.TaskState(ctx, name)
+	require.NoError(t, err, "task state")
+	require.Len(t, state.Running, 1, "running after overlap run")
+	require.Equal(t, "manual", state.Running[0].RunType, "run type")
+	require.Len(t, history.histories, 1, "histories")
+	require.Equal(t, history.histories[0].RunID, state.Running[0].RunID, "run id")
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/"+name, nil)
+	resp := httptest.NewRecorder()
+	e.AdminHandler().ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, "admin task state")
+	respState := TaskState{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&respState), "decode task state")
+	require.Equal(t, state.Running, respState.Running, "admin running")
+
+	require.NoError(t, e.CancelTask(name), "cancel task")
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatal("task not canceled")
+	}
+	state, err = e.TaskState(ctx, name)
+	require.NoError(t, err, "task state")
+	require.Empty(t, state.Running, "running after task end")
+	require.Equal(t, ErrTaskNotRunning, e.CancelTask(name), "cancel not running task")
+}
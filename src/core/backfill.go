@@ -0,0 +1,172 @@
+package core
+
+import (
+	gContext "context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	timeCycle "github.com/rentiansheng/incenses/src/libs/time_cycle"
+	"github.com/rentiansheng/incenses/src/libs/worker"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/21
+    @desc:
+
+***************************/
+
+var (
+	// ErrBackfillRunning 同一个任务已经有回填在执行
+	ErrBackfillRunning = errors.New("task backfill is running")
+)
+
+// BackfillOption 回填的参数
+type BackfillOption struct {
+	// Concurrency 同时计算的周期数量，小于1 按照1 处理
+	Concurrency int
+	// Progress 每个周期结束的时候调用，调用是串行的
+	Progress func(progress BackfillProgress)
+}
+
+// BackfillProgress 回填的进度
+type BackfillProgress struct {
+	TaskName string `json:"task_name"`
+	// Total 需要计算的周期数量
+	Total int `json:"total"`
+	// Finished 已经结束的周期数量，包含失败的周期
+	Finished int `json:"finished"`
+	// Failed 失败的周期数量
+	Failed int `json:"failed"`
+	// CycleStart, CycleEnd 刚结束的周期
+	CycleStart uint64 `json:"cycle_start"`
+	CycleEnd   uint64 `json:"cycle_end"`
+	// Err 刚结束周期的错误
+	Err error `json:"-"`
+}
+
+// Backfill 重新计算任务在[start, end] 时间范围内的所有周期，结果通过任务配置的output 写入。
+// 每个周期使用单独的任务实例，不修改任务的周期状态(TaskDone)，不使用checkpoint，已经存在的结果会重新计算。
+// 回填使用单独的锁，不会阻塞周期任务。每个周期计算前获取任务fencing token 计数器的新值，
+// 和周期任务写入同一个周期时，后开始计算的一方token 更大，output 开启check_fencing 的时候拒绝先开始的一方的写入
+func (e *event) Backfill(ctx context.Context, taskName string, start, end uint64, opt BackfillOption) (BackfillProgress, error) {
+	progress := BackfillProgress{TaskName: taskName}
+	// 执行过程中会取消ctx，不能影响调用方
+	ctx = ctx.SubCtx(taskName)
+	taskInfo, err := e.taskHandle.GetByName(ctx, taskName)
+	if err != nil {
+		ctx.Log().Errorf("get task error. name: %s, err: %s", taskName, err.Error())
+		return progress, err
+	}
+	cycles, err := timeCycle.GetTimeIntervalRange(start, end, timeCycle.CycleType(taskInfo.TaskCycle))
+	if err != nil {
+		ctx.Log().Errorf("get task cycle range error. name: %s, err: %s", taskName, err.Error())
+		return progress, err
+	}
+	progress.Total = len(cycles)
+
+	policy := taskInfo.Policy.Merge(e.taskPolicy)
+	lockKey := define.BackfillLockKeyPrefix + taskName
+	_, locked, err := e.lock.Lock(ctx, lockKey, define.FencingKey(taskName), policy.LockLeaseDuration())
+	if err != nil {
+		ctx.Log().Errorf("get backfill locked error. name: %s, err: %s", taskName, err.Error())
+		e.metrics.lockFailure(taskName, "backfill", err)
+		return progress, err
+	}
+	if !locked {
+		e.metrics.lockFailure(taskName, "backfill", nil)
+		return progress, ErrBackfillRunning
+	}
+	defer func() {
+		if err := e.lock.Unlock(context.Detach(ctx), lockKey); err != nil {
+			ctx.Log().Errorf("release backfill locked error. name: %s, err: %s", taskName, err.Error())
+		}
+	}()
+	cancelFn := ctx.Cancel()
+	defer cancelFn()
+	stopLease := e.keepLease(ctx, taskName, lockKey, policy.LockLeaseDuration(), cancelFn)
+	defer stopLease()
+
+	concurrency := opt.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	mutex := sync.Mutex{}
+	workers := worker.NewWaitExecWorker(concurrency)
+	for _, cycle := range cycles {
+		if ctx.IsDone() {
+			break
+		}
+		cycle := cycle
+		workers.Run(ctx, func(gContext.Context) error {
+			cycleCtx := ctx.SubCtx(strconv.FormatUint(cycle.Begin, 10))
+			err := e.backfillCycle(cycleCtx, taskInfo, cycle)
+			if err != nil {
+				cycleCtx.Log().Errorf("backfill cycle error. name: %s, start: %d, end: %d, err: %s",
+					taskName, cycle.Begin, cycle.End, err.Error())
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			progress.Finished++
+			if err != nil {
+				progress.Failed++
+			}
+			progress.CycleStart, progress.CycleEnd, progress.Err = cycle.Begin, cycle.End, err
+			if opt.Progress != nil {
+				opt.Progress(progress)
+			}
+			return nil
+		})
+	}
+	_ = workers.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	progress.CycleStart, progress.CycleEnd, progress.Err = 0, 0, nil
+	if progress.Finished != progress.Total {
+		return progress, fmt.Errorf("backfill canceled. finished: %d, total: %d", progress.Finished, progress.Total)
+	}
+	if progress.Failed != 0 {
+		return progress, fmt.Errorf("backfill cycle failed. failed: %d, total: %d", progress.Failed, progress.Total)
+	}
+	return progress, nil
+}
+
+// backfillCycle 计算单个周期，周期的锁只用来获取fencing token，回填的锁已经保证了周期不会被其他回填计算
+func (e *event) backfillCycle(ctx context.Context, taskInfo define.MetricTask, cycle timeCycle.TimeInterval) error {
+	t, err := e.taskParams(ctx, taskInfo)
+	if err != nil {
+		return err
+	}
+	lockKey := fmt.Sprintf("%s%s:%d", define.BackfillLockKeyPrefix, taskInfo.TaskName, cycle.Begin)
+	fencingToken, locked, err := e.lock.Lock(ctx, lockKey, define.FencingKey(taskInfo.TaskName), t.policy.LockLeaseDuration())
+	if err != nil {
+		ctx.Log().Errorf("get backfill cycle locked error. name: %s, err: %s", taskInfo.TaskName, err.Error())
+		return err
+	}
+	if !locked {
+		return ErrBackfillRunning
+	}
+	defer func() {
+		if err := e.lock.Unlock(context.Detach(ctx), lockKey); err != nil {
+			ctx.Log().Errorf("release backfill cycle locked error. name: %s, err: %s", taskInfo.TaskName, err.Error())
+		}
+	}()
+	t.backfill = true
+	t.metricMetadataArr = []define.MetricMetadata{{
+		MetricName:     taskInfo.TaskName,
+		Start:          cycle.Begin,
+		End:            cycle.End,
+		Cycle:          taskInfo.TaskCycle,
+		CycleMode:      taskInfo.CycleMode,
+		LastFinishTime: taskInfo.LastFinishTime,
+		FencingToken:   fencingToken,
+	}}
+
+	return t.runCycles(ctx)
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	sink := newTestSink()
+	taskInfo := newTestTask("backfill", &testCollect{keys: []string{"k1", "k2"}, records: 2}, sink)
+	e, tasks := newTestEvent(t, taskInfo)
+
+	now := uint64(time.Now().Unix())
+	progressCnt := 0
+	progress, err := e.Backfill(ctx, taskInfo.TaskName, now-86400*4, now-86400*2, BackfillOption{
+		Concurrency: 2,
+		Progress:    func(progress BackfillProgress) { progressCnt++ },
+	})
+	require.NoError(t, err, "backfill")
+	require.True(t, progress.Total > 1, "cycle count")
+	require.Equal(t, progress.Total, progress.Finished, "finished")
+	require.Equal(t, 0, progress.Failed, "failed")
+	require.Equal(t, progress.Total, progressCnt, "progress callback")
+	require.Equal(t, []string{"k1", "k2"}, sink.keys())
+	require.Equal(t, float64(2), sink.get("k1").Value["cnt"])
+	require.Equal(t, 0, tasks.doneCount(), "backfill not change task cycle")
+
+	// 每个周期使用任务计数器的新token
+	tokens := make(map[uint64]bool)
+	var maxToken uint64
+	for _, metadata := range sink.metadata {
+		require.True(t, metadata.FencingToken > 0, "backfill fencing token")
+		tokens[metadata.FencingToken] = true
+		if metadata.FencingToken > maxToken {
+			maxToken = metadata.FencingToken
+		}
+	}
+	require.Equal(t, progress.Total, len(tokens), "token per cycle")
+
+	// 回填之后的周期任务使用更大的token
+	require.NoError(t, e.runTask(ctx, taskInfo, false))
+	require.Equal(t, 1, tasks.doneCount(), "scheduled run")
+	last := sink.metadata[len(sink.metadata)-1]
+	require.True(t, last.FencingToken > maxToken, "scheduled token after backfill")
+
+	// 同一个任务同时只能有一个回填
+	_, locked, err := e.lock.Lock(context.Background(), define.BackfillLockKeyPrefix+taskInfo.TaskName,
+		define.FencingKey(taskInfo.TaskName), time.Minute)
+	require.NoError(t, err)
+	require.True(t, locked)
+	_, err = e.Backfill(ctx, taskInfo.TaskName, now-86400*4, now-86400*2, BackfillOption{})
+	require.Equal(t, ErrBackfillRunning, err, "backfill running")
+
+	_, err = e.Backfill(ctx, "not_exists", now-86400*4, now-86400*2, BackfillOption{})
+	require.Equal(t, define.ErrTaskNotFound, err, "task not found")
+}
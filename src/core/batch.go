@@ -0,0 +1,117 @@
+package core
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// recordEmitter 把collect 插件收集的数据按照批次发送给filter
+type recordEmitter struct {
+	output    chan []define.Record
+	batchSize int
+	// onSend 发送前调用，full 表示chan 的缓冲已满
+	onSend func(full bool)
+	// sent 已经有数据发送给filter，插件返回后读取
+	sent bool
+}
+
+func newRecordEmitter(output chan []define.Record, batchSize int, onSend func(full bool)) *recordEmitter {
+	return &recordEmitter{
+		output:    output,
+		batchSize: batchSize,
+		onSend:    onSend,
+	}
+}
+
+// Emit 实现define.RecordEmitter，复制records 后按照批次大小拆分发送
+func (e *recordEmitter) Emit(ctx context.Context, records []define.Record) error {
+	for len(records) > 0 {
+		size := e.batchSize
+		if len(records) < size {
+			size = len(records)
+		}
+		batch := make([]define.Record, size)
+		copy(batch, records[:size])
+		if err := e.send(ctx, batch); err != nil {
+			return err
+		}
+		records = records[size:]
+	}
+	return nil
+}
+
+func (e *recordEmitter) send(ctx context.Context, batch []define.Record) error {
+	e.onSend(len(e.output) == cap(e.output))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case e.output <- batch:
+		e.sent = true
+		return nil
+	}
+}
+
+// pipeMetricData 聚合结果合并成批次发送给output，发送的时机和recordEmitter.pipe 相同。
+// input 关闭后关闭output，任务取消后继续读取并丢弃数据，避免aggregator 阻塞在发送数据上
+func pipeMetricData(ctx context.Context, input chan define.MetricData, output chan []define.MetricData,
+	batchSize int, onSend func(full bool)) {
+	defer close(output)
+	batch := make([]define.MetricData, 0, batchSize)
+	for data := range input {
+		if ctx.IsDone() {
+			continue
+		}
+		batch = append(batch, data)
+		if len(batch) < batchSize && len(input) > 0 {
+			continue
+		}
+		onSend(len(output) == cap(output))
+		select {
+		case <-ctx.Done():
+			continue
+		case output <- batch:
+		}
+		batch = make([]define.MetricData, 0, batchSize)
+	}
+}
+
+// runRecords 执行逐条发送数据的插件，收到的数据合并成批次后发送
+func (e *recordEmitter) runRecords(ctx context.Context, run func(input chan define.Record) error) error {
+	input := make(chan define.Record, e.batchSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.pipe(ctx, input)
+	}()
+	defer func() {
+		close(input)
+		<-done
+	}()
+	return run(input)
+}
+
+// pipe 批次已满，或者chan 中没有可以立即读取的数据时发送当前批次，数据少的时候不等待凑满批次。
+// 任务取消后继续读取并丢弃数据，避免插件阻塞在发送数据上
+func (e *recordEmitter) pipe(ctx context.Context, input chan define.Record) {
+	batch := make([]define.Record, 0, e.batchSize)
+	for record := range input {
+		if ctx.IsDone() {
+			continue
+		}
+		batch = append(batch, record)
+		if len(batch) < e.batchSize && len(input) > 0 {
+			continue
+		}
+		if err := e.send(ctx, batch); err != nil {
+			continue
+		}
+		batch = make([]define.Record, 0, e.batchSize)
+	}
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// batchCollect 通过RecordEmitter 一次发送key 的全部数据
+type batchCollect struct {
+	*testCollect
+}
+
+func (c *batchCollect) RunBatch(ctx context.Context, key string, start, end uint64, emitter define.RecordEmitter) error {
+	records := make([]define.Record, 0, c.records)
+	for idx := 0; idx < c.records; idx++ {
+		records = append(records, define.NewRecord(fmt.Sprintf("%s-%d", key, idx),
+			map[string]string{"key": key}, map[string]float64{"value": float64(idx)}))
+	}
+	return emitter.Emit(ctx, records)
+}
+
+func batchSizes(output chan []define.Record) []int {
+	sizes := make([]int, 0)
+	for len(output) > 0 {
+		sizes = append(sizes, len(<-output))
+	}
+	return sizes
+}
+
+func TestRecordEmitter(t *testing.T) {
+	ctx := context.Background()
+	output := make(chan []define.Record, 10)
+	sends := 0
+	emitter := newRecordEmitter(output, 2, func(full bool) { sends++ })
+
+	records := make([]define.Record, 5)
+	for idx := range records {
+		records[idx] = define.NewRecord(fmt.Sprintf("%d", idx), nil, nil)
+	}
+	require.NoError(t, emitter.Emit(ctx, records), "emit")
+	require.Equal(t, []int{2, 2, 1}, batchSizes(output), "split by batch size")
+	require.Equal(t, 3, sends, "send count")
+
+	err := emitter.runRecords(ctx, func(input chan define.Record) error {
+		for _, record := range records {
+			input <- record
+		}
+		return nil
+	})
+	require.NoError(t, err, "run records")
+	total := 0
+	for _, size := range batchSizes(output) {
+		require.True(t, size > 0 && size <= 2, "batch size %d", size)
+		total += size
+	}
+	require.Equal(t, len(records), total, "records")
+
+	canceled := context.Background()
+	cancel := canceled.Cancel()
+	cancel()
+	require.Error(t, newRecordEmitter(make(chan []define.Record), 2, func(bool) {}).Emit(canceled, records), "canceled")
+}
+
+func TestBatchCollect(t *testing.T) {
+	sink := newTestSink()
+	collect := &testCollect{keys: []string{"k1", "k2"}, records: 5}
+	taskInfo := newTestTask("batch_collect", collect, sink)
+	taskInfo.Policy.BatchSize = 2
+	taskInfo.Policy.BatchBuffer = 1
+	e, tasks := newTestEvent(t, taskInfo)
+	collects.Add(taskInfo.Collect.Name, func() define.Collect { return &batchCollect{testCollect: collect} })
+
+	require.NoError(t, e.runTask(context.Background(), taskInfo, false), "run task")
+	require.Equal(t, []string{"k1", "k2"}, sink.keys(), "keys")
+	require.Equal(t, float64(5), sink.get("k1").Value["cnt"], "k1 count")
+	require.Equal(t, float64(5), sink.get("k2").Value["cnt"], "k2 count")
+	require.Equal(t, 0, collect.runCount("k1"), "Run not called")
+	require.Equal(t, 1, tasks.doneCount(), "cycle finalized")
+}
+
+func TestPipeMetricData(t *testing.T) {
+	input := make(chan define.MetricData, 5)
+	output := make(chan []define.MetricData, 10)
+	for idx := 0; idx < 5; idx++ {
+		input <- define.MetricData{MetricKey: fmt.Sprintf("k%d", idx)}
+	}
+	close(input)
+	sends := 0
+	pipeMetricData(context.Background(), input, output, 2, func(full bool) { sends++ })
+
+	// 数据已经在chan 中，按照批次大小合并，input 关闭后关闭output
+	keys := make([]string, 0)
+	sizes := make([]int, 0)
+	for batch := range output {
+		sizes = append(sizes, len(batch))
+		for _, data := range batch {
+			keys = append(keys, data.MetricKey)
+		}
+	}
+	require.Equal(t, []int{2, 2, 1}, sizes, "batch sizes")
+	require.Equal(t, []string{"k0", "k1", "k2", "k3", "k4"}, keys, "keys")
+	require.Equal(t, 3, sends, "send count")
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/18
+    @desc:
+
+***************************/
+
+// finishedKeys 获取周期中已经完成的key, 出现错误的时候重新计算全部key。回填和预览不使用checkpoint
+func (t *task) finishedKeys(ctx context.Context, metricMetadata define.MetricMetadata) map[string]struct{} {
+	if t.event.checkpoint == nil || t.recompute() {
+		return nil
+	}
+	finished, err := t.event.checkpoint.Finished(ctx, define.CheckpointRunKey(metricMetadata))
+	if err != nil {
+		ctx.Log().Field("metric metadata", metricMetadata).
+			Errorf("get checkpoint error, execute all keys. name: %s, err: %s", t.name, err.Error())
+		return nil
+	}
+	if len(finished) != 0 {
+		ctx.Log().Infof("resume task from checkpoint. name: %s, finished key count: %d", t.name, len(finished))
+	}
+	return finished
+}
+
+// checkpointDone 记录key 已经完成，错误不影响结果，最多是重复执行一次计算
+func (t *task) checkpointDone(ctx context.Context, metricMetadata define.MetricMetadata, key string) {
+	if t.event.checkpoint == nil || t.recompute() {
+		return
+	}
+	if err := t.event.checkpoint.Done(ctx, define.CheckpointRunKey(metricMetadata), key); err != nil {
+		ctx.Log().Errorf("save checkpoint error. name: %s, key: %s, err: %s", t.name, key, err.Error())
+	}
+}
+
+// clearCheckpoint 周期完成，清理所有周期的记录
+func (t *task) clearCheckpoint(ctx context.Context) {
+	if t.event.checkpoint == nil || t.recompute() {
+		return
+	}
+	for _, metricMetadata := range t.metricMetadataArr {
+		if err := t.event.checkpoint.Clear(ctx, define.CheckpointRunKey(metricMetadata)); err != nil {
+			ctx.Log().Errorf("clear checkpoint error. name: %s, err: %s", t.name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/dedup"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+// newDedup 根据执行策略生成去重实例
+func (t *task) newDedup() dedup.Deduper {
+	switch t.policy.Dedup {
+	case define.DedupTypeNone:
+		return dedup.NewNone()
+	case define.DedupTypeBloom:
+		return dedup.NewBloom(t.policy.DedupCapacity, t.policy.DedupErrorRate)
+	case define.DedupTypeDisk:
+		return dedup.NewDisk(t.policy.DedupMemoryLimit, "")
+	default:
+		return dedup.NewMemory()
+	}
+}
+
+// openCycleDedup DedupScopeTypeTask 的时候，生成周期中全部key 共用的去重实例
+func (t *task) openCycleDedup() {
+	if t.policy.DedupScope == define.DedupScopeTypeTask {
+		t.cycleDedup = t.newDedup()
+	}
+}
+
+func (t *task) closeCycleDedup(ctx context.Context) {
+	if t.cycleDedup == nil {
+		return
+	}
+	t.closeDedup(ctx, t.cycleDedup)
+	t.cycleDedup = nil
+}
+
+// keyDedup key 使用的去重实例，返回的函数在key 的数据处理完成后调用
+func (t *task) keyDedup() (dedup.Deduper, func(ctx context.Context)) {
+	if t.cycleDedup != nil {
+		return t.cycleDedup, func(ctx context.Context) {}
+	}
+	d := t.newDedup()
+	return d, func(ctx context.Context) {
+		t.closeDedup(ctx, d)
+	}
+}
+
+// closeDedup 记录占用内存的最大值后释放去重实例
+func (t *task) closeDedup(ctx context.Context, d dedup.Deduper) {
+	t.stats.max(taskStageDedupMemory, d.MemoryUsage())
+	if err := d.Close(); err != nil {
+		ctx.Log().Errorf("close dedup error. task name: %s, err: %s", t.name, err.Error())
+	}
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// dupCollect 每个key 产生uuids 对应的数据，uuid 可以重复
+type dupCollect struct {
+	*testCollect
+	uuids []string
+}
+
+func (c *dupCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	for _, uuid := range c.uuids {
+		input <- define.NewRecord(uuid, map[string]string{"key": key}, map[string]float64{"value": 1})
+	}
+	return nil
+}
+
+func TestDedup(t *testing.T) {
+	collect := &testCollect{keys: []string{"k1", "k2"}}
+	taskInfo := newTestTask("dedup", collect, newTestSink())
+	collects.Add(taskInfo.Collect.Name, func() define.Collect {
+		// 全部uuid 在每个key 中都出现，u0 在key 中重复
+		return &dupCollect{testCollect: collect, uuids: []string{"u0", "u1", "u0", "u2", "u3"}}
+	})
+	e, _ := newTestEvent(t, taskInfo)
+
+	tests := []struct {
+		name       string
+		dedup      define.DedupType
+		scope      define.DedupScopeType
+		duplicated int64
+		// counts 每个key 的count 结果，nil 的时候只检查全部key 的总数
+		counts []float64
+	}{
+		{"none", define.DedupTypeNone, define.DedupScopeTypeKey, 0, []float64{5, 5}},
+		{"memory key", define.DedupTypeMemory, define.DedupScopeTypeKey, 2, []float64{4, 4}},
+		{"memory task", define.DedupTypeMemory, define.DedupScopeTypeTask, 6, nil},
+		{"bloom key", define.DedupTypeBloom, define.DedupScopeTypeKey, 2, []float64{4, 4}},
+		{"disk key", define.DedupTypeDisk, define.DedupScopeTypeKey, 2, []float64{4, 4}},
+	}
+	for _, tt := range tests {
+		taskInfo.Policy.Dedup, taskInfo.Policy.DedupScope = tt.dedup, tt.scope
+		// 磁盘去重内存中只保存1 条，其他的写入磁盘
+		taskInfo.Policy.DedupMemoryLimit = 1
+		result, err := e.Preview(context.Background(), taskInfo, PreviewOption{})
+		require.NoError(t, err, "preview. name: %s", tt.name)
+		require.Equal(t, int64(10), result.Stats.Collected, "collected. name: %s", tt.name)
+		require.Equal(t, tt.duplicated, result.Stats.Duplicated, "duplicated. name: %s", tt.name)
+		require.Equal(t, 10-tt.duplicated, result.Stats.Filtered, "filtered. name: %s", tt.name)
+		if tt.dedup == define.DedupTypeNone {
+			require.Equal(t, int64(0), result.Stats.DedupMemory, "dedup memory. name: %s", tt.name)
+		} else if tt.dedup == define.DedupTypeMemory {
+			require.True(t, result.Stats.DedupMemory > 0, "dedup memory. name: %s", tt.name)
+		}
+		if tt.counts == nil {
+			total := float64(0)
+			for _, data := range result.Data {
+				total += data.Value["cnt"]
+			}
+			require.Equal(t, float64(10-tt.duplicated), total, "total count. name: %s", tt.name)
+			continue
+		}
+		require.Len(t, result.Data, len(tt.counts), "data. name: %s", tt.name)
+		for idx, cnt := range tt.counts {
+			require.Equal(t, cnt, result.Data[idx].Value["cnt"], "count. name: %s, key: %s", tt.name, result.Data[idx].MetricKey)
+		}
+	}
+}
+
+// flakyCollect 第一次收集发送数据后返回错误，之后正常收集
+type flakyCollect struct {
+	*testCollect
+}
+
+func (c *flakyCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	c.mutex.Lock()
+	if c.runs == nil {
+		c.runs = make(map[string]int)
+	}
+	c.runs[key]++
+	first := c.runs[key] == 1
+	c.mutex.Unlock()
+	for idx := 0; idx < c.records; idx++ {
+		input <- define.NewRecord(fmt.Sprintf("%s-%d", key, idx),
+			map[string]string{"key": key}, map[string]float64{"value": float64(idx)})
+	}
+	if first {
+		return fmt.Errorf("collect %s error", key)
+	}
+	return nil
+}
+
+func TestDedupNoneCollectRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		dedup define.DedupType
+		// runs 第一次执行collect 的次数
+		runs int
+	}{
+		{"none", define.DedupTypeNone, 1},
+		{"memory", define.DedupTypeMemory, 2},
+	}
+	for _, tt := range tests {
+		sink := newTestSink()
+		collect := &testCollect{keys: []string{"k1"}, records: 3}
+		taskInfo := newTestTask("dedup_retry_"+tt.name, collect, sink)
+		taskInfo.Policy.Dedup = tt.dedup
+		taskInfo.Policy.RetryNum = 3
+		collects.Add(taskInfo.Collect.Name, func() define.Collect { return &flakyCollect{testCollect: collect} })
+		e, tasks := newTestEvent(t, taskInfo)
+		ctx := context.Background()
+
+		err := e.runTask(ctx, taskInfo, false)
+		require.Equal(t, tt.runs, collect.runCount("k1"), "collect runs. name: %s", tt.name)
+		if tt.dedup == define.DedupTypeNone {
+			// 发送过数据后不重试，key 失败，下次执行重新计算
+			require.ErrorIs(t, err, ErrTaskFailed, "first run. name: %s", tt.name)
+			require.Empty(t, sink.keys(), "output. name: %s", tt.name)
+			taskInfo, err = tasks.GetByName(ctx, taskInfo.TaskName)
+			require.NoError(t, err, "get task. name: %s", tt.name)
+			require.NoError(t, e.runTask(ctx, taskInfo, false), "retry failed key. name: %s", tt.name)
+		} else {
+			require.NoError(t, err, "first run. name: %s", tt.name)
+		}
+		require.Equal(t, float64(3), sink.get("k1").Value["cnt"], "count. name: %s", tt.name)
+	}
+}
@@ -4,20 +4,32 @@ import (
 	gContext "context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v9"
+	"github.com/google/uuid"
 
 	"github.com/rentiansheng/incenses/src/context"
 	"github.com/rentiansheng/incenses/src/define"
+	checkpointRedis "github.com/rentiansheng/incenses/src/handle/checkpoint/redis"
+	failedKeyRedis "github.com/rentiansheng/incenses/src/handle/failed_key/redis"
+	incrementalRedis "github.com/rentiansheng/incenses/src/handle/incremental/redis"
+	shardRedis "github.com/rentiansheng/incenses/src/handle/shard/redis"
+	"github.com/rentiansheng/incenses/src/libs/dedup"
 	"github.com/rentiansheng/incenses/src/libs/redislock"
+	"github.com/rentiansheng/incenses/src/libs/scheduler"
 	timeCycle "github.com/rentiansheng/incenses/src/libs/time_cycle"
+	"github.com/rentiansheng/incenses/src/libs/trace"
 	_ "github.com/rentiansheng/incenses/src/plugins"
 	"github.com/rentiansheng/incenses/src/plugins/aggregators"
 	"github.com/rentiansheng/incenses/src/plugins/collects"
 	"github.com/rentiansheng/incenses/src/plugins/filters"
+	"github.com/rentiansheng/incenses/src/plugins/metric_filters"
 	"github.com/rentiansheng/incenses/src/plugins/outputs"
 )
 
@@ -30,21 +42,103 @@ import (
 
 var cache *redis.Client
 
+const (
+	// maxAggregatorChainDepth 多级聚合最大的层级，避免配置错误导致无限嵌套
+	maxAggregatorChainDepth = 8
+	// defaultTaskConcurrency 默认同时执行任务的权重之和
+	defaultTaskConcurrency = 10
+	// defaultShutdownTimeout 退出时等待正在执行任务结束的时间，超过后取消任务
+	defaultShutdownTimeout = time.Minute
+)
+
+// defaultTaskPolicy 任务执行策略的默认值
+var defaultTaskPolicy = define.MetricTaskPolicy{
+	WorkerNum:    10,
+	RetryNum:     3,
+	RetryDelay:   100,
+	RetryBackoff: 1,
+	CycleTimeout: 600,
+	LockLease:    120,
+
+	Finalize:         define.FinalizePolicyTypeAll,
+	KeyRetryNum:      10,
+	KeyRetryDelay:    60,
+	KeyRetryMaxDelay: 3600,
+
+	Dedup:            define.DedupTypeMemory,
+	DedupScope:       define.DedupScopeTypeKey,
+	DedupErrorRate:   dedup.DefaultBloomErrorRate,
+	DedupCapacity:    dedup.DefaultBloomCapacity,
+	DedupMemoryLimit: dedup.DefaultDiskMemoryLimit,
+
+	BatchSize:   100,
+	BatchBuffer: 10,
+}
+
 type event struct {
-	collectWorkerNum int
-	collectRetryNum  int
+	// taskPolicy 任务没有配置执行策略时使用的值
+	taskPolicy define.MetricTaskPolicy
 	// intervalDelay    time.Duration
 
 	taskHandle define.MetricTaskImpl
-	//lock define.Lock
+	// lock 保证同一个任务在多个节点上互斥执行
+	lock define.Lock
+	// checkpoint 记录任务已经完成的key，任务超时后，下次从中断的地方继续执行
+	checkpoint define.Checkpoint
+	// failedKey 记录执行失败的key，为nil 的时候不记录，失败的key 在下一次执行中直接重新计算
+	failedKey define.FailedKeyImpl
+	// incrementalState 增量计算的进度，为nil 的时候全部任务都全量计算
+	incrementalState define.IncrementalStateImpl
+	// shard 记录已经完成的分片，为nil 的时候任务不分片
+	shard define.ShardImpl
+	// history 任务执行记录，为nil 的时候不记录
+	history define.RunHistoryImpl
+	// node 当前节点的名字，保存在执行记录中
+	node string
+	// scheduler 限制同时执行的任务，同一个任务执行没有结束的时候不会再次执行
+	scheduler scheduler.Scheduler
+	// shutdownTimeout Stop 等待正在执行任务结束的时间
+	shutdownTimeout time.Duration
+	// hooks 所有任务都会执行的回调
+	hooks []define.Hooks
+	// metrics 监控指标，为nil 的时候不上报
+	metrics *engineMetrics
+	// metricsAddr 不为空的时候，Start 在这个地址上启动监控指标的http 服务
+	metricsAddr string
+	// tracer 记录执行过程，为nil 的时候不记录
+	tracer *trace.Tracer
+	// running 当前节点正在执行的任务
+	running runningTasks
+	// adminAddr 不为空的时候，Start 在这个地址上启动任务管理的http 服务
+	adminAddr string
+	// adminAuth 任务管理请求的认证，为nil 的时候不认证
+	adminAuth AdminAuthFunc
+
+	// mutex 保护下面Start/Stop 使用的状态
+	mutex sync.Mutex
+	// stopLoop 停止调度新的任务，同时放弃等待执行的任务
+	stopLoop gContext.CancelFunc
+	// cancelTasks 取消正在执行的任务
+	cancelTasks gContext.CancelFunc
+	// loopDone 调度循环退出后关闭，为nil 的时候表示没有启动
+	loopDone chan struct{}
+	// queueCtx, taskCtx 和调度循环使用的相同，手动触发的任务使用
+	queueCtx gContext.Context
+	taskCtx  context.Context
+	// metricsServer 监控指标的http 服务
+	metricsServer *http.Server
+	// adminServer 任务管理的http 服务
+	adminServer *http.Server
 }
 
-func defaultEvent() event {
-	return event{
-		collectWorkerNum: 10,
-		collectRetryNum:  2,
+func defaultEvent() *event {
+	return &event{
+		taskPolicy: defaultTaskPolicy,
 		//intervalDelay:    ,
-		taskHandle: nil,
+		taskHandle:      nil,
+		scheduler:       scheduler.New(defaultTaskConcurrency),
+		shutdownTimeout: defaultShutdownTimeout,
+		node:            nodeName(),
 	}
 }
 
@@ -52,53 +146,178 @@ func SetClient(c *redis.Client) {
 	cache = c
 }
 
-func New(taskHandle define.MetricTaskImpl) (*event, error) {
+// New 生成任务执行引擎，lock 为nil 的时候，使用SetClient 设置的redis 实现的锁
+func New(taskHandle define.MetricTaskImpl, lock define.Lock) (*event, error) {
 	e := defaultEvent()
 	if taskHandle == nil {
 		return nil, errors.New("metric task handle not implement")
 	}
 	e.taskHandle = taskHandle
-	if cache == nil {
-		return nil, errors.New("redis handle not init")
+	if cache != nil {
+		redislock.SetClient(cache)
+		e.checkpoint = checkpointRedis.New(cache, 0)
+		e.failedKey = failedKeyRedis.New(cache, 0)
+		e.incrementalState = incrementalRedis.New(cache, 0)
+		e.shard = shardRedis.New(cache, 0)
+		if lock == nil {
+			lock = redislock.New(cache)
+		}
+	}
+	if lock == nil {
+		return nil, errors.New("task lock not implement")
 	}
-	redislock.SetClient(cache)
-	return &e, nil
+	e.lock = lock
+	return e, nil
+}
+
+// SetCheckpoint 修改记录任务已经完成key 的存储，为nil 的时候不记录，任务超时后整个周期重新执行
+func (e *event) SetCheckpoint(checkpoint define.Checkpoint) {
+	e.checkpoint = checkpoint
+}
+
+// SetConcurrency 修改同时执行任务的权重之和，需要在Run 之前调用
+func (e *event) SetConcurrency(limit int) {
+	e.scheduler = scheduler.New(limit)
+}
+
+// SchedulerStats 任务调度的情况，包含正在执行和等待执行的任务数量
+func (e *event) SchedulerStats() scheduler.Stats {
+	return e.scheduler.Stats()
+}
+
+// SetTaskPolicy 修改任务执行策略的默认值，policy 中没有配置的字段使用内置的默认值
+func (e *event) SetTaskPolicy(policy define.MetricTaskPolicy) {
+	e.taskPolicy = policy.Merge(defaultTaskPolicy)
 }
 
-func (e event) Run(gctx gContext.Context) {
+// SetShutdownTimeout 修改Run 收到退出信号后，等待正在执行任务结束的时间
+func (e *event) SetShutdownTimeout(timeout time.Duration) {
+	e.shutdownTimeout = timeout
+}
+
+// Run 启动任务调度并阻塞，收到SIGTERM/SIGINT 的时候停止，gctx 结束的时候取消所有任务后退出
+func (e *event) Run(gctx gContext.Context) {
 	ctx := context.NewContexts(gctx)
-	// 处理quit信号
-	e.cancel(ctx)
+	if err := e.Start(gctx); err != nil {
+		ctx.Log().Errorf("start event error. err: %s", err.Error())
+		return
+	}
 
-	// 与e.run 行程任务按顺序循环执行
-	for {
-		st := time.Now()
-		e.run(ctx)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(quit)
 
-		execTs := time.Now().Sub(st)
-		// 小于30 秒，delay 1s
-		if execTs.Seconds() < 30 {
-			time.Sleep(time.Second * 1)
+	select {
+	case sig := <-quit:
+		ctx.Log().Infof("Server is shutting down... signal: %s", sig)
+		if err := e.Stop(e.shutdownTimeout); err != nil {
+			ctx.Log().Errorf("stop event error. err: %s", err.Error())
 		}
+	case <-gctx.Done():
 	}
+	e.Wait()
 }
 
-func (e event) cancel(ctx context.Context) {
-	cancelFn := ctx.Cancel()
+// Start 在后台循环调度任务，不会阻塞。gctx 结束的时候会取消正在执行的任务
+func (e *event) Start(gctx gContext.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.loopDone != nil {
+		return errors.New("event already started")
+	}
+	if err := e.startMetricsServer(context.NewContexts(gctx)); err != nil {
+		return fmt.Errorf("start metrics server error. %w", err)
+	}
+	if err := e.startAdminServer(context.NewContexts(gctx)); err != nil {
+		e.stopMetricsServer()
+		return fmt.Errorf("start admin server error. %w", err)
+	}
+
+	taskCtx, cancelTasks := gContext.WithCancel(gctx)
+	loopCtx, stopLoop := gContext.WithCancel(taskCtx)
+	loopDone := make(chan struct{})
+	e.cancelTasks, e.stopLoop, e.loopDone = cancelTasks, stopLoop, loopDone
+	e.queueCtx, e.taskCtx = loopCtx, context.NewContexts(taskCtx)
 
 	go func() {
-		quit := make(chan os.Signal, 1)
+		defer close(loopDone)
+		e.loop(loopCtx, e.taskCtx)
+	}()
 
-		signal.Notify(quit)
-		//等待接收到退出信号：
-		<-quit
-		ctx.Log().Info("Server is shutting down...")
-		cancelFn()
+	return nil
+}
+
+// Stop 停止调度新的任务，等待正在执行的任务在timeout 内结束，超时后取消正在执行的任务。
+// 被取消的任务不会更新周期和checkpoint，任务锁在任务退出时释放
+func (e *event) Stop(timeout time.Duration) error {
+	e.mutex.Lock()
+	if e.loopDone == nil {
+		e.mutex.Unlock()
+		return errors.New("event not started")
+	}
+	stopLoop, cancelTasks, loopDone := e.stopLoop, e.cancelTasks, e.loopDone
+	e.mutex.Unlock()
 
+	stopLoop()
+	<-loopDone
+
+	drained := make(chan struct{})
+	go func() {
+		e.scheduler.Wait()
+		close(drained)
 	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-drained:
+	case <-timer.C:
+		err = fmt.Errorf("wait running task timeout, task canceled. timeout: %s", timeout)
+		cancelTasks()
+		<-drained
+	}
+	cancelTasks()
+
+	e.mutex.Lock()
+	// 允许再次Start
+	if e.loopDone == loopDone {
+		e.loopDone = nil
+		e.queueCtx, e.taskCtx = nil, nil
+		e.stopMetricsServer()
+		e.stopAdminServer()
+	}
+	e.mutex.Unlock()
+
+	return err
+}
+
+// Wait 等待调度循环退出并且所有任务结束，没有启动的时候直接返回
+func (e *event) Wait() {
+	e.mutex.Lock()
+	loopDone := e.loopDone
+	e.mutex.Unlock()
+	if loopDone == nil {
+		return
+	}
+	<-loopDone
+	e.scheduler.Wait()
 }
 
-func (e event) run(ctx context.Context) {
+// loop 把任务提交给scheduler 并发执行，没有结束的任务下次循环的时候会跳过
+func (e *event) loop(loopCtx gContext.Context, ctx context.Context) {
+	for {
+		e.run(ctx, loopCtx)
+		select {
+		case <-loopCtx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// run 提交需要执行的任务，queueCtx 结束的时候放弃还在排队的任务，正在执行的任务使用ctx
+func (e *event) run(ctx context.Context, queueCtx gContext.Context) {
 
 	defer ctx.Log().Sync()
 
@@ -112,17 +331,26 @@ func (e event) run(ctx context.Context) {
 	}
 
 	for _, task := range tasks {
-
-		if err := e.runTask(ctx, task); err != nil {
-			ctx.Log().Field("task", task).Errorf("task execute error. err: %s", err.Error())
-			continue
+		if queueCtx.Err() != nil {
+			return
+		}
+		task := task
+		submitted := e.scheduler.Submit(queueCtx, task.TaskName, int(task.Weight), func(gContext.Context) {
+			if err := e.runTask(ctx, task, false); err != nil {
+				ctx.Log().Field("task", task).Errorf("task execute error. err: %s", err.Error())
+			}
+		})
+		if !submitted {
+			ctx.Log().Debugf("task is running or waiting, skip. task: %s", task.TaskName)
 		}
-
 	}
-
+	stats := e.scheduler.Stats()
+	ctx.Log().Infof("task scheduler. running: %d, waiting: %d, used: %d, capacity: %d",
+		stats.Running, stats.Waiting, stats.Used, stats.Capacity)
 }
 
-func (e event) runTask(ctx context.Context, taskInfo define.MetricTask) error {
+// runTask manual 为true 的时候是手动触发执行，周期没有结束的时候也会执行
+func (e *event) runTask(ctx context.Context, taskInfo define.MetricTask, manual bool) error {
 	name := taskInfo.TaskName
 	ctx = ctx.SubCtx(name)
 	ctx.Log().Infof("start %s task", name)
@@ -133,6 +361,18 @@ func (e event) runTask(ctx context.Context, taskInfo define.MetricTask) error {
 		ctx.Log().Field("task", taskInfo).Errorf("taskParams execute error. err: %s", err.Error())
 		return err
 	}
+	task.manual = manual
+	// 可以通过CancelTask 取消
+	cancel := ctx.Cancel()
+	defer cancel()
+	run := &runningTask{
+		runID:     task.runID,
+		manual:    manual,
+		startTime: time.Now(),
+		cancel:    cancel,
+	}
+	e.running.add(name, run)
+	defer e.running.remove(name, run)
 	if err := task.Run(ctx); err != nil {
 		ctx.Log().Field("task", taskInfo).Errorf("task execute error. err: %s", err.Error())
 		return err
@@ -140,7 +380,27 @@ func (e event) runTask(ctx context.Context, taskInfo define.MetricTask) error {
 	return nil
 }
 
-func (e event) taskParams(ctx context.Context, taskInfo define.MetricTask) (*task, error) {
+func (e *event) taskParams(ctx context.Context, taskInfo define.MetricTask) (*task, error) {
+	taskInstance, err := e.taskPipelineParams(ctx, taskInfo)
+	if err != nil {
+		return nil, err
+	}
+	outputPlugins, err := e.initOutputPlugins(ctx, taskInfo)
+	if err != nil {
+		return nil, err
+	}
+	taskInstance.outputPlugins = outputPlugins
+	taskInstance.hooks = e.initTaskHooks(taskInstance)
+	taskInstance.metrics = e.metrics
+	taskInstance.tracer = e.tracer
+	taskInstance.stats.report = e.metrics.statsReporter(taskInstance.name)
+
+	return taskInstance, nil
+}
+
+// taskPipelineParams 初始化除output 之外的插件。
+// 预览直接使用，不写入数据，不执行回调，不上报监控指标，这些由taskParams 设置
+func (e *event) taskPipelineParams(ctx context.Context, taskInfo define.MetricTask) (*task, error) {
 	taskInstance, err := e.initTaskInstance(ctx, taskInfo)
 	if err != nil {
 		return nil, err
@@ -160,15 +420,7 @@ func (e event) taskParams(ctx context.Context, taskInfo define.MetricTask) (*tas
 		return nil, err
 	}
 
-	outputPluginInstance := outputs.Get(taskInfo.Output.Name)
-	if outputPluginInstance == nil {
-		err := fmt.Errorf("output plugin not found. task: %s, plugin name: %s", taskName, taskInfo.Output.Name)
-		ctx.Log().Error(err.Error())
-		return nil, err
-	}
-
 	taskInstance.collectPlugin = collectPluginInstance
-	taskInstance.outputPlugin = outputPluginInstance
 
 	for _, plugin := range taskInfo.Filters {
 		f := filters.Get(plugin.Name)
@@ -190,13 +442,52 @@ func (e event) taskParams(ctx context.Context, taskInfo define.MetricTask) (*tas
 		return nil, err
 	}
 
+	for _, plugin := range taskInfo.MetricFilters {
+		f := metric_filters.Get(plugin.Name)
+		if f == nil {
+			err := fmt.Errorf("metric filters plugin not found. task: %s, plugin name: %s", taskName, plugin.Name)
+			ctx.Log().Error(err.Error())
+			return nil, err
+		}
+		if err := f.SetConfig(ctx, []byte(plugin.Config)); err != nil {
+			ctx.Log().Errorf("metric filters plugin set config error. task: %s, plugin name: %s, config: %s, err: %s",
+				taskName, plugin.Name, plugin.Config, err.Error())
+			return nil, err
+		}
+		taskInstance.metricFilterPlugin = append(taskInstance.metricFilterPlugin, f)
+	}
+
 	taskInstance.aggregatorPlugin = aggs
 
 	return taskInstance, nil
 
 }
 
-func (e event) initTaskInstance(ctx context.Context, taskInfo define.MetricTask) (*task, error) {
+func (e *event) initOutputPlugins(ctx context.Context, taskInfo define.MetricTask) ([]*taskOutput, error) {
+	taskName := taskInfo.TaskName
+	outputPlugins := make([]*taskOutput, 0, len(taskInfo.OutputConfigs()))
+	for _, plugin := range taskInfo.OutputConfigs() {
+		outputPluginInstance := outputs.Get(plugin.Name)
+		if outputPluginInstance == nil {
+			err := fmt.Errorf("output plugin not found. task: %s, plugin name: %s", taskName, plugin.Name)
+			ctx.Log().Error(err.Error())
+			return nil, err
+		}
+		if err := outputPluginInstance.SetConfig(ctx, []byte(plugin.Config)); err != nil {
+			ctx.Log().Errorf("output plugin set config error. task: %s, plugin name: %s, config: %s, err: %s",
+				taskName, plugin.Name, plugin.Config, err.Error())
+			return nil, err
+		}
+		outputPlugins = append(outputPlugins, &taskOutput{
+			plugin:        outputPluginInstance,
+			failurePolicy: plugin.FailurePolicy,
+		})
+	}
+
+	return outputPlugins, nil
+}
+
+func (e *event) initTaskInstance(ctx context.Context, taskInfo define.MetricTask) (*task, error) {
 	taskName := taskInfo.TaskName
 
 	timeCycles, err := e.initTaskInstanceCycles(ctx, taskInfo)
@@ -206,14 +497,21 @@ func (e event) initTaskInstance(ctx context.Context, taskInfo define.MetricTask)
 	}
 
 	taskInstance := &task{
-		event:              &e,
+		event:              e,
 		taskLastFinishTime: int64(taskInfo.LastFinishTime),
 		name:               taskName,
+		runID:              uuid.NewString(),
+		policy:             taskInfo.Policy.Merge(e.taskPolicy),
 		filterPlugin:       nil,
 		aggregatorPlugin:   nil,
 
 		indexName: taskInfo.OutputIndexName,
 	}
+	taskInstance.logLevels, err = parseTaskLogLevels(taskInstance.policy)
+	if err != nil {
+		ctx.Log().Errorf("parse task log level error. name: %s, err: %s", taskName, err.Error())
+		return nil, err
+	}
 	for _, cycle := range timeCycles {
 		if cycle.Begin > uint64(taskInfo.TaskStart) {
 			// 设置未最大时间周期
@@ -232,27 +530,14 @@ func (e event) initTaskInstance(ctx context.Context, taskInfo define.MetricTask)
 	return taskInstance, nil
 }
 
-func (e event) initAggregatorPlugin(ctx context.Context, taskInfo define.MetricTask) ([]AggregatorFn, error) {
+func (e *event) initAggregatorPlugin(ctx context.Context, taskInfo define.MetricTask) ([]AggregatorFn, error) {
 	aggsPlugins := make([]AggregatorFn, 0, len(taskInfo.Aggregators))
 	taskName := taskInfo.TaskName
 
 	for _, plugin := range taskInfo.Aggregators {
 		fnPlugin := plugin
-		aggFn := func(fCtx context.Context) (define.Aggregator, error) {
-			f := aggregators.Get(fnPlugin.Name)
-			if f == nil {
-				err := fmt.Errorf("aggregator plugin not found. task: %s, plugin name: %s", taskName, plugin.Name)
-				ctx.Log().Error(err.Error())
-				return nil, err
-			}
-
-			if err := f.SetConfig(ctx, []byte(fnPlugin.Config)); err != nil {
-				ctx.Log().Errorf("aggregator plugin set config error. task: %s, plugin name: %s, config: %s, err: %s",
-					taskName, fnPlugin.Name, string(fnPlugin.Config), err.Error())
-				return nil, err
-			}
-
-			return f, nil
+		aggFn := func(fCtx context.Context) (*define.AggregatorChain, error) {
+			return e.newAggregatorChain(fCtx, taskName, &fnPlugin)
 		}
 
 		// 校验配置是否有问题
@@ -266,7 +551,42 @@ func (e event) initAggregatorPlugin(ctx context.Context, taskInfo define.MetricT
 	return aggsPlugins, nil
 }
 
-func (e event) initTaskInstanceCycles(ctx context.Context, taskInfo define.MetricTask) ([]timeCycle.TimeInterval, error) {
+// newAggregatorChain 根据配置递归生成多级聚合插件，每一个key 都需要生成新的实例
+func (e *event) newAggregatorChain(ctx context.Context, taskName string, plugin *define.MetricTaskPluginAggregatorConfig) (*define.AggregatorChain, error) {
+	var head, tail *define.AggregatorChain
+	for depth := 0; plugin != nil; depth++ {
+		if depth >= maxAggregatorChainDepth {
+			err := fmt.Errorf("aggregator plugin chain too deep. task: %s, max depth: %d", taskName, maxAggregatorChainDepth)
+			ctx.Log().Error(err.Error())
+			return nil, err
+		}
+		f := aggregators.Get(plugin.Name)
+		if f == nil {
+			err := fmt.Errorf("aggregator plugin not found. task: %s, plugin name: %s", taskName, plugin.Name)
+			ctx.Log().Error(err.Error())
+			return nil, err
+		}
+
+		if err := f.SetConfig(ctx, []byte(plugin.Config)); err != nil {
+			ctx.Log().Errorf("aggregator plugin set config error. task: %s, plugin name: %s, config: %s, err: %s",
+				taskName, plugin.Name, string(plugin.Config), err.Error())
+			return nil, err
+		}
+
+		node := &define.AggregatorChain{Plugin: f}
+		if head == nil {
+			head = node
+		} else {
+			tail.Next = node
+		}
+		tail = node
+		plugin = plugin.Next
+	}
+
+	return head, nil
+}
+
+func (e *event) initTaskInstanceCycles(ctx context.Context, taskInfo define.MetricTask) ([]timeCycle.TimeInterval, error) {
 
 	timeRange := make([]timeCycle.TimeInterval, 0, taskInfo.CalculateCycle)
 	// db 中的start 是最后一个周期
@@ -0,0 +1,308 @@
+package core
+
+import (
+	gContext "context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	lockMemory "github.com/rentiansheng/incenses/src/handle/lock/memory"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+	"github.com/rentiansheng/incenses/src/plugins/outputs"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc: core 测试使用的任务存储，collect 和output 插件
+
+***************************/
+
+type memoryTasks struct {
+	mutex sync.Mutex
+	tasks []define.MetricTask
+	// done TaskDone 的调用记录
+	done []uint64
+}
+
+func (m *memoryTasks) Get(ctx context.Context) ([]define.MetricTask, error) {
+	return m.List(ctx)
+}
+
+func (m *memoryTasks) GetByName(ctx context.Context, name string) (define.MetricTask, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, task := range m.tasks {
+		if task.TaskName == name {
+			return task, nil
+		}
+	}
+	return define.MetricTask{}, define.ErrTaskNotFound
+}
+
+func (m *memoryTasks) TaskDone(ctx context.Context, name string, nextCycleTime, lastFinishTime uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx := range m.tasks {
+		if m.tasks[idx].TaskName == name {
+			m.tasks[idx].TaskStart, m.tasks[idx].LastFinishTime = nextCycleTime, lastFinishTime
+			m.done = append(m.done, nextCycleTime)
+		}
+	}
+	return nil
+}
+
+func (m *memoryTasks) Add(ctx context.Context, info define.MetricTask, extra map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tasks = append(m.tasks, info)
+	return nil
+}
+
+func (m *memoryTasks) ModifyOutputIndexName(ctx context.Context, taskName, indexName string) error {
+	return nil
+}
+
+func (m *memoryTasks) List(ctx context.Context) ([]define.MetricTask, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]define.MetricTask{}, m.tasks...), nil
+}
+
+func (m *memoryTasks) Update(ctx context.Context, info define.MetricTask, user string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx := range m.tasks {
+		if m.tasks[idx].TaskName == info.TaskName {
+			m.tasks[idx] = info
+			return nil
+		}
+	}
+	return define.ErrTaskNotFound
+}
+
+func (m *memoryTasks) ChangeStatus(ctx context.Context, name string, from, to define.StatusEnumType, user string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx := range m.tasks {
+		if m.tasks[idx].TaskName != name {
+			continue
+		}
+		if m.tasks[idx].TaskStatus != from && m.tasks[idx].TaskStatus != to {
+			return define.ErrTaskStatusChanged
+		}
+		m.tasks[idx].TaskStatus = to
+		return nil
+	}
+	return define.ErrTaskNotFound
+}
+
+func (m *memoryTasks) doneCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.done)
+}
+
+// testCollect 每个key 产生records 条数据，failKeys 中的key 返回错误
+type testCollect struct {
+	name     string
+	keys     []string
+	records  int
+	failKeys map[string]bool
+
+	mutex sync.Mutex
+	// runs 每个key 执行的次数
+	runs map[string]int
+}
+
+func (c *testCollect) Name() string                                    { return c.name }
+func (c *testCollect) Description() string                             { return "core test collect" }
+func (c *testCollect) SetConfig(ctx context.Context, cfg []byte) error { return nil }
+func (c *testCollect) Keys(ctx context.Context) ([]string, error)      { return c.keys, nil }
+func (c *testCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	c.mutex.Lock()
+	if c.runs == nil {
+		c.runs = make(map[string]int)
+	}
+	c.runs[key]++
+	fail := c.failKeys[key]
+	c.mutex.Unlock()
+	if fail {
+		return fmt.Errorf("collect %s error", key)
+	}
+	for idx := 0; idx < c.records; idx++ {
+		input <- define.NewRecord(fmt.Sprintf("%s-%d", key, idx),
+			map[string]string{"key": key}, map[string]float64{"value": float64(idx)})
+	}
+	return nil
+}
+
+func (c *testCollect) setFail(key string, fail bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.failKeys == nil {
+		c.failKeys = make(map[string]bool)
+	}
+	c.failKeys[key] = fail
+}
+
+func (c *testCollect) runCount(key string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.runs[key]
+}
+
+// testSink 保存output 写入的数据，多个output 实例共享
+type testSink struct {
+	mutex    sync.Mutex
+	writes   map[string]define.OutputData
+	metadata []define.MetricMetadata
+	// writeErr 不为nil 的时候写入返回错误
+	writeErr func(data define.OutputData) error
+	// exists 为true 的时候，已经写入的key Exists 返回true
+	exists bool
+}
+
+func newTestSink() *testSink {
+	return &testSink{writes: make(map[string]define.OutputData)}
+}
+
+func (s *testSink) keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]string, 0, len(s.writes))
+	for key := range s.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *testSink) get(key string) define.OutputData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.writes[key]
+}
+
+type testOutput struct {
+	name string
+	sink *testSink
+}
+
+func (o *testOutput) Name() string                                    { return o.name }
+func (o *testOutput) Description() string                             { return "core test output" }
+func (o *testOutput) SetConfig(ctx context.Context, cfg []byte) error { return nil }
+func (o *testOutput) Exists(ctx context.Context, key string) (bool, error) {
+	o.sink.mutex.Lock()
+	defer o.sink.mutex.Unlock()
+	_, ok := o.sink.writes[key]
+	return o.sink.exists && ok, nil
+}
+func (o *testOutput) IndexName(ctx context.Context) (string, error) { return "", nil }
+func (o *testOutput) SetMetricMetadata(ctx context.Context, data define.MetricMetadata) error {
+	o.sink.mutex.Lock()
+	defer o.sink.mutex.Unlock()
+	o.sink.metadata = append(o.sink.metadata, data)
+	return nil
+}
+func (o *testOutput) Write(ctx context.Context, data define.OutputData) error {
+	if o.sink.writeErr != nil {
+		if err := o.sink.writeErr(data); err != nil {
+			return err
+		}
+	}
+	o.sink.mutex.Lock()
+	defer o.sink.mutex.Unlock()
+	o.sink.writes[data.MetricKey] = data
+	return nil
+}
+
+// newTestTask 注册以任务名字命名的collect 和output 插件，返回使用count 聚合的任务
+func newTestTask(name string, collect *testCollect, sink *testSink) define.MetricTask {
+	collect.name = name
+	collects.Add(name, func() define.Collect { return collect })
+	outputs.Add(name, func() define.Output { return &testOutput{name: name, sink: sink} })
+	return define.MetricTask{
+		TaskName:       name,
+		TaskCycle:      define.TaskCycleTypeDay,
+		CycleMode:      define.CycleModeTypeEnd,
+		CalculateCycle: 1,
+		TaskStatus:     define.StatusEnumTypeNormal,
+		TaskStart:      uint64(time.Now().AddDate(0, 0, -3).Unix()),
+		Collect:        define.MetricTaskPluginCollectConfig{Name: name},
+		Aggregators: define.MetricTaskPluginAggregatorConfigArr{
+			{Name: "count", Config: define.RAWConfig(`{"output_key":"cnt"}`)},
+		},
+		Output: define.MetricTaskPluginOutputConfig{Name: name},
+	}
+}
+
+func newTestEvent(t *testing.T, tasks ...define.MetricTask) (*event, *memoryTasks) {
+	handle := &memoryTasks{tasks: tasks}
+	e, err := New(handle, lockMemory.New())
+	require.NoError(t, err, "new event")
+	return e, handle
+}
+
+// blockCollect Run 阻塞到release 关闭或者执行被取消
+type blockCollect struct {
+	*testCollect
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	c.started <- struct{}{}
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.testCollect.Run(ctx, key, start, end, input)
+}
+
+func TestLifecycle(t *testing.T) {
+	collect := &testCollect{keys: []string{"k1"}, records: 1}
+	sink := newTestSink()
+	taskInfo := newTestTask("lifecycle", collect, sink)
+	block := &blockCollect{testCollect: collect, started: make(chan struct{}, 10), release: make(chan struct{})}
+	collects.Add(taskInfo.Collect.Name, func() define.Collect { return block })
+	e, tasks := newTestEvent(t, taskInfo)
+	waitStarted := func(msg string) {
+		select {
+		case <-block.started:
+		case <-time.After(time.Second * 10):
+			t.Fatal(msg)
+		}
+	}
+
+	require.Error(t, e.Stop(time.Second), "stop before start")
+
+	// 超时后取消正在执行的任务，周期没有完成
+	require.NoError(t, e.Start(gContext.Background()), "start")
+	require.Error(t, e.Start(gContext.Background()), "start twice")
+	waitStarted("task not started")
+	require.Error(t, e.Stop(time.Millisecond*100), "stop timeout")
+	require.Equal(t, 0, tasks.doneCount(), "canceled task")
+	require.Empty(t, sink.keys(), "canceled task output")
+
+	// Stop 之后可以再次Start，deadline 之前结束的任务正常完成
+	require.NoError(t, e.Start(gContext.Background()), "restart")
+	waitStarted("task not started after restart")
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		close(block.release)
+	}()
+	require.NoError(t, e.Stop(time.Second*10), "stop drain")
+	require.Equal(t, 1, tasks.doneCount(), "drained task")
+	require.Equal(t, []string{"k1"}, sink.keys(), "drained task output")
+
+	require.Error(t, e.Stop(time.Second), "stop stopped event")
+	e.Wait()
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/23
+    @desc:
+
+***************************/
+
+// SetFailedKey 修改记录失败key 的存储，为nil 的时候不记录，失败的key 在下一次执行中直接重新计算
+func (e *event) SetFailedKey(failedKey define.FailedKeyImpl) {
+	e.failedKey = failedKey
+}
+
+// FailedKeys 查询任务中执行失败的key
+func (e *event) FailedKeys(ctx context.Context, taskName string) ([]define.FailedKey, error) {
+	if e.failedKey == nil {
+		return nil, errors.New("failed key not implement")
+	}
+	return e.failedKey.List(ctx, taskName)
+}
+
+// ClearFailedKeys 删除周期中失败的key，周期已经完成或者不需要重试的时候使用
+func (e *event) ClearFailedKeys(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error {
+	if e.failedKey == nil {
+		return errors.New("failed key not implement")
+	}
+	return e.failedKey.Clear(ctx, taskName, cycleStart, cycleEnd)
+}
+
+func failedKeyID(metricMetadata define.MetricMetadata, key string) string {
+	return fmt.Sprintf("%d:%d:%s", metricMetadata.Start, metricMetadata.End, key)
+}
+
+// loadFailedKeys 获取周期中之前执行失败的key，出现错误的时候所有key 都重新计算
+func (t *task) loadFailedKeys(ctx context.Context, metricMetadata define.MetricMetadata) map[string]define.FailedKey {
+	if t.event.failedKey == nil || t.recompute() {
+		return nil
+	}
+	keys, err := t.event.failedKey.List(ctx, t.name)
+	if err != nil {
+		ctx.Log().Errorf("get failed key error, retry all failed keys. name: %s, err: %s", t.name, err.Error())
+		return nil
+	}
+	failed := make(map[string]define.FailedKey)
+	for _, key := range keys {
+		if key.CycleStart != metricMetadata.Start || key.CycleEnd != metricMetadata.End {
+			continue
+		}
+		failed[key.Key] = key
+		t.retryKeys.Store(failedKeyID(metricMetadata, key.Key), key)
+	}
+	return failed
+}
+
+// canRetryKey 失败的key 是否已经到了重试的时间
+func canRetryKey(key define.FailedKey) bool {
+	return key.NextRetryTime != 0 && uint64(time.Now().Unix()) >= key.NextRetryTime
+}
+
+// keyRetryExhausted 超过最大重试次数的key 不再计算，按照失败的key 交给完成策略判断。
+// FinalizePolicyTypeAll 的任务周期不会完成，需要通过ClearFailedKeys 删除记录后重新计算
+func keyRetryExhausted(key define.FailedKey) bool {
+	return key.NextRetryTime == 0
+}
+
+// setKeyError 记录key 失败的原因，只保留第一个错误
+func (t *task) setKeyError(key string, err error) {
+	t.keyErrors.LoadOrStore(key, err.Error())
+}
+
+// keyFailure 生成key 的取消方法，key 失败的时候只取消key 的计算，不影响其他key。
+// 任务本身被取消的时候，不是key 的失败，不需要记录
+func (t *task) keyFailure(ctx context.Context, metricMetadata define.MetricMetadata, key string, cancelFunc context.CancelFunc) context.CancelFunc {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			if ctx.IsDone() {
+				return
+			}
+			t.stats.incr(taskStageKeyFailed)
+			t.saveFailedKey(ctx, metricMetadata, key)
+			t.afterKey(ctx, metricMetadata, key, errors.New(t.keyError(key)))
+		})
+		cancelFunc()
+	}
+}
+
+// keyError key 失败的原因
+func (t *task) keyError(key string) string {
+	if msg, ok := t.keyErrors.Load(key); ok {
+		return msg.(string)
+	}
+	return "key execute timeout or canceled"
+}
+
+// saveFailedKey 保存失败的key，超过最大重试次数后不再重试
+func (t *task) saveFailedKey(ctx context.Context, metricMetadata define.MetricMetadata, key string) {
+	errMsg := t.keyError(key)
+	ctx.Log().Errorf("key execute failure. name: %s, key: %s, err: %s", t.name, key, errMsg)
+	if t.event.failedKey == nil || t.recompute() {
+		return
+	}
+
+	now := time.Now()
+	failedKey := define.FailedKey{
+		TaskName:     t.name,
+		CycleStart:   metricMetadata.Start,
+		CycleEnd:     metricMetadata.End,
+		Key:          key,
+		Attempts:     1,
+		ErrMsg:       errMsg,
+		LastFailTime: uint64(now.Unix()),
+	}
+	if prev, ok := t.retryKeys.Load(failedKeyID(metricMetadata, key)); ok {
+		failedKey.Attempts = prev.(define.FailedKey).Attempts + 1
+	}
+	if failedKey.Attempts <= t.policy.KeyRetryNum {
+		failedKey.NextRetryTime = uint64(now.Add(t.policy.KeyRetryInterval(failedKey.Attempts)).Unix())
+	}
+	if err := t.event.failedKey.Fail(context.Detach(ctx), failedKey); err != nil {
+		ctx.Log().Errorf("save failed key error. name: %s, key: %s, err: %s", t.name, key, err.Error())
+	}
+}
+
+// clearFailedKeys 周期完成，清理所有周期中失败的key，不管完成策略是否允许失败的key
+func (t *task) clearFailedKeys(ctx context.Context) {
+	if t.event.failedKey == nil || t.recompute() {
+		return
+	}
+	for _, metricMetadata := range t.metricMetadataArr {
+		if err := t.event.failedKey.Clear(ctx, t.name, metricMetadata.Start, metricMetadata.End); err != nil {
+			ctx.Log().Errorf("clear failed key error. name: %s, err: %s", t.name, err.Error())
+		}
+	}
+}
+
+// keyDone key 计算完成，记录checkpoint，之前失败的key 从失败记录中删除
+func (t *task) keyDone(ctx context.Context, metricMetadata define.MetricMetadata, key string) {
+	t.checkpointDone(ctx, metricMetadata, key)
+	t.saveIncremental(ctx, metricMetadata, key)
+	t.afterKey(ctx, metricMetadata, key, nil)
+	if t.event.failedKey == nil {
+		return
+	}
+	if _, ok := t.retryKeys.Load(failedKeyID(metricMetadata, key)); !ok {
+		return
+	}
+	if err := t.event.failedKey.Remove(ctx, t.name, metricMetadata.Start, metricMetadata.End, key); err != nil {
+		ctx.Log().Errorf("remove failed key error. name: %s, key: %s, err: %s", t.name, key, err.Error())
+	}
+}
+
+// canFinalize 根据失败key 的数量和任务的策略，判断周期是否可以完成
+func (t *task) canFinalize(ctx context.Context) bool {
+	return t.canFinalizeCount(ctx, t.stats.get(taskStageKeyTotal),
+		t.stats.get(taskStageKeyFailed)+t.stats.get(taskStageKeyDeferred))
+}
+
+// canFinalizeCount 分片执行的时候，使用全部分片中key 的数量判断
+func (t *task) canFinalizeCount(ctx context.Context, total, failed int64) bool {
+	if failed == 0 {
+		return true
+	}
+	// 回填和预览需要全部key 成功
+	if t.recompute() {
+		return false
+	}
+
+	canFinalize := false
+	switch t.policy.Finalize {
+	case define.FinalizePolicyTypeBestEffort:
+		canFinalize = true
+	case define.FinalizePolicyTypeThreshold:
+		canFinalize = total > 0 && float64(total-failed)*100/float64(total) >= t.policy.FinalizeThreshold
+	}
+	if canFinalize {
+		ctx.Log().Infof("finalize cycle with failed keys. name: %s, failed key count: %d, total key count: %d",
+			t.name, failed, total)
+	} else {
+		ctx.Log().Errorf("cycle not finalize. name: %s, failed key count: %d, total key count: %d",
+			t.name, failed, total)
+	}
+	return canFinalize
+}
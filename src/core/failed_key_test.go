@@ -0,0 +1,77 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	failedKeyMemory "github.com/rentiansheng/incenses/src/handle/failed_key/memory"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// exhaustedKey 超过重试次数的失败记录
+func exhaustedKey(t *testing.T, e *event, taskInfo define.MetricTask, key string) define.FailedKey {
+	ctx := context.Background()
+	instance, err := e.taskParams(ctx, taskInfo)
+	require.NoError(t, err, "task params")
+	metricMetadata := instance.metricMetadataArr[0]
+	return define.FailedKey{
+		TaskName:   taskInfo.TaskName,
+		CycleStart: metricMetadata.Start,
+		CycleEnd:   metricMetadata.End,
+		Key:        key,
+		Attempts:   4,
+		ErrMsg:     "collect error",
+	}
+}
+
+func TestExhaustedKeyFinalizeAll(t *testing.T) {
+	ctx := context.Background()
+	sink := newTestSink()
+	taskInfo := newTestTask("exhausted_all", &testCollect{keys: []string{"k1", "k2"}, records: 1}, sink)
+	taskInfo.Policy.Finalize = define.FinalizePolicyTypeAll
+	e, tasks := newTestEvent(t, taskInfo)
+	e.SetFailedKey(failedKeyMemory.New())
+	failed := exhaustedKey(t, e, taskInfo, "k2")
+	require.NoError(t, e.failedKey.Fail(ctx, failed))
+
+	require.ErrorIs(t, e.runTask(ctx, taskInfo, false), ErrTaskFailed, "cycle not finalized")
+	require.Equal(t, []string{"k1"}, sink.keys(), "exhausted key not recomputed")
+	require.Equal(t, 0, tasks.doneCount(), "exhausted key counted as failed")
+	keys, err := e.FailedKeys(ctx, taskInfo.TaskName)
+	require.NoError(t, err)
+	require.Equal(t, []define.FailedKey{failed}, keys, "failed key kept until cycle finalize")
+
+	// 删除失败记录后重新计算
+	require.NoError(t, e.ClearFailedKeys(ctx, taskInfo.TaskName, failed.CycleStart, failed.CycleEnd))
+	require.NoError(t, e.runTask(ctx, taskInfo, false))
+	require.Equal(t, []string{"k1", "k2"}, sink.keys())
+	require.Equal(t, 1, tasks.doneCount(), "cycle advance")
+}
+
+func TestFailedKeyClearOnCycleAdvance(t *testing.T) {
+	ctx := context.Background()
+	sink := newTestSink()
+	collect := &testCollect{keys: []string{"k1", "k2", "k3", "k4"}, records: 1, failKeys: map[string]bool{"k4": true}}
+	taskInfo := newTestTask("failed_key_clear", collect, sink)
+	taskInfo.Policy.Finalize = define.FinalizePolicyTypeThreshold
+	taskInfo.Policy.FinalizeThreshold = 50
+	e, tasks := newTestEvent(t, taskInfo)
+	e.SetFailedKey(failedKeyMemory.New())
+	require.NoError(t, e.failedKey.Fail(ctx, exhaustedKey(t, e, taskInfo, "k3")))
+
+	require.NoError(t, e.runTask(ctx, taskInfo, false))
+	require.Equal(t, []string{"k1", "k2"}, sink.keys())
+	require.Equal(t, 1, tasks.doneCount(), "threshold reached")
+	keys, err := e.FailedKeys(ctx, taskInfo.TaskName)
+	require.NoError(t, err)
+	require.Empty(t, keys, "failed keys cleared when cycle advance")
+}
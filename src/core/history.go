@@ -0,0 +1,142 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/22
+    @desc:
+
+***************************/
+
+// SetRunHistory 设置任务执行记录的存储，为nil 的时候不记录
+func (e *event) SetRunHistory(history define.RunHistoryImpl) {
+	e.history = history
+}
+
+// RunHistories 查询任务执行记录
+func (e *event) RunHistories(ctx context.Context, filter define.RunHistoryFilter) ([]define.RunHistory, error) {
+	if e.history == nil {
+		return nil, errors.New("run history not implement")
+	}
+	return e.history.List(ctx, filter)
+}
+
+// nodeName 当前节点的名字，hostname:pid
+func nodeName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// execRunType 根据任务实例的执行方式确定执行记录的类型
+func (t *task) execRunType() define.RunType {
+	switch {
+	case t.backfill:
+		return define.RunTypeBackfill
+	case t.manual:
+		return define.RunTypeManual
+	}
+	return define.RunTypeSchedule
+}
+
+// startRun 任务开始执行，开始任务的span，保存执行记录
+func (t *task) startRun(ctx context.Context, runType define.RunType) *define.RunHistory {
+	t.runType, t.runStart = runType, time.Now()
+	t.startTaskSpan(ctx, runType)
+	return t.startHistory(ctx, runType)
+}
+
+// finishRun 任务执行结束，上报执行时间和结果的监控指标，结束任务的span，更新执行记录。
+// externalDone 在超时或者调用方取消的时候关闭，用来区分取消和执行失败
+func (t *task) finishRun(ctx context.Context, history *define.RunHistory, err error, externalDone <-chan struct{}) {
+	status, errMsg := t.runStatus(ctx, err, externalDone)
+	t.metrics.taskRun(t.name, t.runType, status, time.Since(t.runStart))
+	var spanErr error
+	if status != define.RunStatusTypeSuccess {
+		spanErr = errors.New(errMsg)
+	}
+	t.span.End(spanErr)
+	t.finishHistory(ctx, history, status, errMsg)
+}
+
+// startHistory 保存执行记录，存储出错不影响任务执行
+func (t *task) startHistory(ctx context.Context, runType define.RunType) *define.RunHistory {
+	if t.event.history == nil {
+		return nil
+	}
+	history := &define.RunHistory{
+		RunID:     t.runID,
+		TaskName:  t.name,
+		RunType:   runType,
+		Node:      t.event.node,
+		StartTime: uint64(time.Now().Unix()),
+		Status:    define.RunStatusTypeRunning,
+	}
+	for idx, metricMetadata := range t.metricMetadataArr {
+		if idx == 0 || metricMetadata.Start < history.CycleStart {
+			history.CycleStart = metricMetadata.Start
+		}
+		if metricMetadata.End > history.CycleEnd {
+			history.CycleEnd = metricMetadata.End
+		}
+	}
+	if err := t.event.history.Start(ctx, *history); err != nil {
+		ctx.Log().Errorf("save run history error. name: %s, err: %s", t.name, err.Error())
+	}
+	return history
+}
+
+// finishHistory 更新执行结果
+func (t *task) finishHistory(ctx context.Context, history *define.RunHistory, status define.RunStatusType, errMsg string) {
+	if history == nil {
+		return
+	}
+	history.Status, history.ErrMsg = status, errMsg
+	history.EndTime = uint64(time.Now().Unix())
+	history.KeyTotal = t.stats.get(taskStageKeyTotal)
+	history.KeyProcessed = t.stats.get(taskStageKeyProcessed)
+	history.KeySkipped = t.stats.get(taskStageKeySkipped)
+	history.KeyFailed = t.stats.get(taskStageKeyFailed)
+	history.RecordCount = t.stats.get(taskStageCollected)
+	history.OutputCount = t.stats.get(taskStageOutput)
+	history.DedupMemory = t.stats.get(taskStageDedupMemory)
+
+	// 任务结束的时候ctx 可能已经被取消
+	if err := t.event.history.Finish(context.Detach(ctx), *history); err != nil {
+		ctx.Log().Errorf("update run history error. name: %s, run id: %s, err: %s", t.name, history.RunID, err.Error())
+	}
+}
+
+// runStatus 任务执行的结果和失败的原因
+func (t *task) runStatus(ctx context.Context, err error, externalDone <-chan struct{}) (define.RunStatusType, string) {
+	select {
+	case <-externalDone:
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return define.RunStatusTypeCanceled, "task timeout"
+		}
+		return define.RunStatusTypeCanceled, "task canceled"
+	default:
+	}
+	switch {
+	case err != nil:
+		return define.RunStatusTypeFailure, err.Error()
+	case !t.taskSuccess:
+		if failed := t.stats.get(taskStageKeyFailed) + t.stats.get(taskStageKeyDeferred); failed > 0 {
+			return define.RunStatusTypeFailure, fmt.Sprintf("failed key count: %d, detail in failed keys", failed)
+		}
+		return define.RunStatusTypeFailure, "task execute failure, detail in log"
+	default:
+		return define.RunStatusTypeSuccess, ""
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	gContext "context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/trace"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// memoryHistory 只记录Start 保存的执行记录
+type memoryHistory struct {
+	mutex     sync.Mutex
+	histories []define.RunHistory
+}
+
+func (m *memoryHistory) Start(ctx context.Context, history define.RunHistory) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.histories = append(m.histories, history)
+	return nil
+}
+
+func (m *memoryHistory) Finish(ctx context.Context, history define.RunHistory) error {
+	return nil
+}
+
+func (m *memoryHistory) Get(ctx context.Context, runID string) (define.RunHistory, error) {
+	return define.RunHistory{}, define.ErrRunHistoryNotFound
+}
+
+func (m *memoryHistory) List(ctx context.Context, filter define.RunHistoryFilter) ([]define.RunHistory, error) {
+	return nil, nil
+}
+
+// memoryExporter 保存导出的span
+type memoryExporter struct {
+	mutex sync.Mutex
+	spans []trace.SpanData
+}
+
+func (m *memoryExporter) Export(_ gContext.Context, spans []trace.SpanData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+// taskRunIDs task span 的run_id
+func (m *memoryExporter) taskRunIDs() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var runIDs []string
+	for _, span := range m.spans {
+		if span.Name != "task" {
+			continue
+		}
+		for _, attr := range span.Attributes {
+			if attr.Key == "run_id" {
+				runIDs = append(runIDs, attr.Value.(string))
+			}
+		}
+	}
+	return runIDs
+}
+
+func TestRunID(t *testing.T) {
+	taskInfo := newTestTask("run_id", &testCollect{keys: []string{"k1"}, records: 1}, newTestSink())
+	e, _ := newTestEvent(t, taskInfo)
+	history, exporter := &memoryHistory{}, &memoryExporter{}
+	e.SetRunHistory(history)
+	tracer := trace.NewTracer(exporter, nil)
+	e.SetTracer(tracer)
+	ctx := context.Background()
+
+	// 同一个进程中多次执行，每次执行的id 不同
+	require.NoError(t, e.runTask(ctx, taskInfo, true), "first run")
+	require.NoError(t, e.runTask(ctx, taskInfo, true), "second run")
+	require.NoError(t, tracer.Shutdown(gContext.Background()), "shutdown tracer")
+
+	require.Len(t, history.histories, 2, "histories")
+	first, second := history.histories[0].RunID, history.histories[1].RunID
+	require.NotEmpty(t, first, "run id")
+	require.NotEqual(t, first, second, "run id per run")
+	require.Equal(t, []string{first, second}, exporter.taskRunIDs(), "span run id")
+}
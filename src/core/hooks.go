@@ -0,0 +1,225 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/trace"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/24
+    @desc:
+
+***************************/
+
+// AddHooks 注册所有任务都会执行的回调，需要在Start 之前调用
+func (e *event) AddHooks(hooks ...define.Hooks) {
+	for _, h := range hooks {
+		if h != nil {
+			e.hooks = append(e.hooks, h)
+		}
+	}
+}
+
+// taskHooks 任务需要执行的回调，按照注册的顺序执行
+type taskHooks []define.Hooks
+
+// initTaskHooks 先使用AddHooks 注册的回调，再使用插件提供的回调
+func (e *event) initTaskHooks(t *task) taskHooks {
+	hooks := make(taskHooks, 0, len(e.hooks))
+	hooks = append(hooks, e.hooks...)
+
+	plugins := []interface{}{t.collectPlugin}
+	for _, plugin := range t.filterPlugin {
+		plugins = append(plugins, plugin)
+	}
+	for _, plugin := range t.metricFilterPlugin {
+		plugins = append(plugins, plugin)
+	}
+	for _, output := range t.outputPlugins {
+		plugins = append(plugins, output.plugin)
+	}
+	for _, plugin := range plugins {
+		provider, ok := plugin.(define.HooksProvider)
+		if !ok {
+			continue
+		}
+		if h := provider.GetHooks(); h != nil {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// callHook 执行单个回调，回调中的panic 当作错误返回
+func callHook(name string, h define.Hooks, fn func(h define.Hooks) error) (err error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("hook %s panic: %#v", name, panicErr)
+		}
+	}()
+	return fn(h)
+}
+
+// before 依次执行回调，第一个错误的时候停止
+func (hs taskHooks) before(name string, fn func(h define.Hooks) error) error {
+	for _, h := range hs {
+		if err := callHook(name, h, fn); err != nil {
+			return fmt.Errorf("hook %s error. %w", name, err)
+		}
+	}
+	return nil
+}
+
+// notify 执行全部回调，错误只记录日志
+func (hs taskHooks) notify(ctx context.Context, name string, fn func(h define.Hooks)) {
+	for _, h := range hs {
+		err := callHook(name, h, func(h define.Hooks) error {
+			fn(h)
+			return nil
+		})
+		if err != nil {
+			ctx.Log().Errorf("execute hook error. err: %s", err.Error())
+		}
+	}
+}
+
+func (t *task) hookInfo(metricMetadata define.MetricMetadata, key string) define.HookInfo {
+	return define.HookInfo{
+		TaskName:       t.name,
+		RunType:        t.execRunType(),
+		MetricMetadata: metricMetadata,
+		Key:            key,
+	}
+}
+
+func (t *task) beforeTask(ctx context.Context) error {
+	info := t.hookInfo(define.MetricMetadata{}, "")
+	return t.hooks.before("before task", func(h define.Hooks) error {
+		return h.BeforeTask(ctx, info)
+	})
+}
+
+// afterTask err 为nil 的时候，根据任务的状态生成错误
+func (t *task) afterTask(ctx context.Context, err error) {
+	if len(t.hooks) == 0 {
+		return
+	}
+	if err == nil && !t.taskSuccess {
+		err = fmt.Errorf("task execute failure. failed key count: %d",
+			t.stats.get(taskStageKeyFailed)+t.stats.get(taskStageKeyDeferred))
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	info := t.hookInfo(define.MetricMetadata{}, "")
+	// 任务结束的时候ctx 可能已经被取消
+	hookCtx := context.Detach(ctx)
+	t.hooks.notify(ctx, "after task", func(h define.Hooks) {
+		h.AfterTask(hookCtx, info, err)
+	})
+}
+
+func (t *task) beforeCycle(ctx context.Context, metricMetadata define.MetricMetadata) error {
+	info := t.hookInfo(metricMetadata, "")
+	return t.hooks.before("before cycle", func(h define.Hooks) error {
+		return h.BeforeCycle(ctx, info)
+	})
+}
+
+func (t *task) afterCycle(ctx context.Context, metricMetadata define.MetricMetadata, err error) {
+	if len(t.hooks) == 0 {
+		return
+	}
+	info := t.hookInfo(metricMetadata, "")
+	hookCtx := context.Detach(ctx)
+	t.hooks.notify(ctx, "after cycle", func(h define.Hooks) {
+		h.AfterCycle(hookCtx, info, err)
+	})
+}
+
+// trackKeys 有回调或者需要记录span 的时候，记录已经开始还没有结束的key
+func (t *task) trackKeys() bool {
+	return len(t.hooks) > 0 || t.tracer != nil
+}
+
+// beforeKey 记录key 已经开始，开始key 的span，key 结束的时候通过afterKey 调用AfterKey
+func (t *task) beforeKey(ctx context.Context, metricMetadata define.MetricMetadata, key string) error {
+	if !t.trackKeys() {
+		return nil
+	}
+	span := t.startSpan(t.cycleSpan, "key", trace.String("key", key))
+	t.keyHooks.Store(failedKeyID(metricMetadata, key), span)
+	info := t.hookInfo(metricMetadata, key)
+	return t.hooks.before("before key", func(h define.Hooks) error {
+		return h.BeforeKey(ctx, info)
+	})
+}
+
+// afterKey 只有执行过beforeKey 的key 才会调用，每个key 只调用一次
+func (t *task) afterKey(ctx context.Context, metricMetadata define.MetricMetadata, key string, err error) {
+	value, ok := t.keyHooks.LoadAndDelete(failedKeyID(metricMetadata, key))
+	if !ok {
+		return
+	}
+	span, _ := value.(*trace.Span)
+	span.End(err)
+	info := t.hookInfo(metricMetadata, key)
+	hookCtx := context.Detach(ctx)
+	t.hooks.notify(ctx, "after key", func(h define.Hooks) {
+		h.AfterKey(hookCtx, info, err)
+	})
+}
+
+// afterUnfinishedKeys 周期结束的时候，任务被取消导致没有结束的key 调用AfterKey
+func (t *task) afterUnfinishedKeys(ctx context.Context, metricMetadata define.MetricMetadata) {
+	if !t.trackKeys() {
+		return
+	}
+	err := ctx.Err()
+	if err == nil {
+		err = errors.New("key execute not finished")
+	}
+	prefix := failedKeyID(metricMetadata, "")
+	t.keyHooks.Range(func(id, _ interface{}) bool {
+		if key := id.(string); strings.HasPrefix(key, prefix) {
+			t.afterKey(ctx, metricMetadata, strings.TrimPrefix(key, prefix), err)
+		}
+		return true
+	})
+}
+
+func (t *task) onRecordError(ctx context.Context, metricMetadata define.MetricMetadata, key string, record define.Record, err error) {
+	if len(t.hooks) == 0 {
+		return
+	}
+	info := t.hookInfo(metricMetadata, key)
+	t.hooks.notify(ctx, "record error", func(h define.Hooks) {
+		h.OnRecordError(ctx, info, record, err)
+	})
+}
+
+func (t *task) onOutputWrite(ctx context.Context, metricMetadata define.MetricMetadata, data define.OutputData, err error) {
+	if len(t.hooks) == 0 {
+		return
+	}
+	info := t.hookInfo(metricMetadata, data.MetricData.MetricKey)
+	t.hooks.notify(ctx, "output write", func(h define.Hooks) {
+		h.OnOutputWrite(ctx, info, data, err)
+	})
+}
+
+func (t *task) onCycleAdvance(ctx context.Context, metricMetadata define.MetricMetadata, nextStart uint64) {
+	if len(t.hooks) == 0 {
+		return
+	}
+	info := t.hookInfo(metricMetadata, "")
+	t.hooks.notify(ctx, "cycle advance", func(h define.Hooks) {
+		h.OnCycleAdvance(ctx, info, nextStart)
+	})
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// recordHooks 记录回调的调用，skipKey 的BeforeKey 返回错误
+type recordHooks struct {
+	define.NopHooks
+	skipKey string
+
+	mutex    sync.Mutex
+	events   []string
+	runTypes map[define.RunType]bool
+}
+
+func (h *recordHooks) add(info define.HookInfo, event string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.runTypes == nil {
+		h.runTypes = make(map[define.RunType]bool)
+	}
+	h.runTypes[info.RunType] = true
+	h.events = append(h.events, event)
+}
+
+func (h *recordHooks) BeforeTask(ctx context.Context, info define.HookInfo) error {
+	h.add(info, "before_task")
+	return nil
+}
+
+func (h *recordHooks) AfterTask(ctx context.Context, info define.HookInfo, err error) {
+	h.add(info, fmt.Sprintf("after_task:%v", err == nil))
+}
+
+func (h *recordHooks) BeforeKey(ctx context.Context, info define.HookInfo) error {
+	h.add(info, "before_key:"+info.Key)
+	if info.Key == h.skipKey {
+		return errors.New("skip key")
+	}
+	return nil
+}
+
+func (h *recordHooks) AfterKey(ctx context.Context, info define.HookInfo, err error) {
+	h.add(info, fmt.Sprintf("after_key:%s:%v", info.Key, err == nil))
+}
+
+func (h *recordHooks) OnOutputWrite(ctx context.Context, info define.HookInfo, data define.OutputData, err error) {
+	h.add(info, fmt.Sprintf("write:%s:%v", data.MetricKey, err == nil))
+}
+
+func (h *recordHooks) OnCycleAdvance(ctx context.Context, info define.HookInfo, nextStart uint64) {
+	h.add(info, "cycle_advance")
+}
+
+func (h *recordHooks) sortedEvents() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	events := append([]string{}, h.events...)
+	sort.Strings(events)
+	return events
+}
+
+func TestHooks(t *testing.T) {
+	ctx := context.Background()
+	sink := newTestSink()
+	taskInfo := newTestTask("hooks", &testCollect{keys: []string{"k1", "k2"}, records: 1}, sink)
+	taskInfo.Policy.Finalize = define.FinalizePolicyTypeBestEffort
+	e, _ := newTestEvent(t, taskInfo)
+	hooks := &recordHooks{skipKey: "k2"}
+	e.AddHooks(hooks)
+
+	require.NoError(t, e.runTask(ctx, taskInfo, false))
+	require.Equal(t, []string{"k1"}, sink.keys(), "before key error skip key")
+	require.Equal(t, []string{
+		"after_key:k1:true",
+		"after_key:k2:false",
+		"after_task:true",
+		"before_key:k1",
+		"before_key:k2",
+		"before_task",
+		"cycle_advance",
+		"write:k1:true",
+	}, hooks.sortedEvents())
+	require.Equal(t, map[define.RunType]bool{define.RunTypeSchedule: true}, hooks.runTypes)
+}
+
+func TestHooksManualRunType(t *testing.T) {
+	sink := newTestSink()
+	taskInfo := newTestTask("hooks_manual", &testCollect{keys: []string{"k1"}, records: 1}, sink)
+	e, _ := newTestEvent(t, taskInfo)
+	hooks := &recordHooks{}
+	e.AddHooks(hooks)
+
+	require.NoError(t, e.RunOnce(context.Background(), taskInfo.TaskName))
+	require.Equal(t, map[define.RunType]bool{define.RunTypeManual: true}, hooks.runTypes)
+}
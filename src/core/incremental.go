@@ -0,0 +1,180 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/25
+    @desc:
+
+***************************/
+
+// SetIncrementalState 修改增量计算进度的存储，为nil 的时候全部任务都全量计算
+func (e *event) SetIncrementalState(state define.IncrementalStateImpl) {
+	e.incrementalState = state
+}
+
+// ResetIncremental 删除任务周期中增量计算的进度，下一次执行全量计算
+func (e *event) ResetIncremental(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error {
+	if e.incrementalState == nil {
+		return errors.New("incremental state not implement")
+	}
+	return e.incrementalState.Clear(ctx, taskName, cycleStart, cycleEnd)
+}
+
+// incrementalKey key 本次增量计算的结果，结果写入output 之后保存
+type incrementalKey struct {
+	mutex       sync.Mutex
+	watermark   string
+	aggregators []define.AggregatorState
+}
+
+func (k *incrementalKey) getWatermark() string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.watermark
+}
+
+func (k *incrementalKey) setWatermark(watermark string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.watermark = watermark
+}
+
+func (k *incrementalKey) setAggregators(aggregators []define.AggregatorState) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.aggregators = aggregators
+}
+
+// incrementalCollect 任务可以增量计算的时候返回collect 插件，否则返回nil。
+// 预览不保存进度，回填全量计算后保存进度，之后的执行从回填的结果继续
+func (t *task) incrementalCollect() define.IncrementalCollect {
+	if !t.policy.Incremental || t.event.incrementalState == nil || t.preview != nil {
+		return nil
+	}
+	collect, ok := t.collectPlugin.(define.IncrementalCollect)
+	if !ok {
+		return nil
+	}
+	return collect
+}
+
+// statefulChains 全部aggregator 都可以保存状态的时候返回true
+func statefulChains(chains []*define.AggregatorChain) bool {
+	for _, chain := range chains {
+		for node := chain; node != nil; node = node.Next {
+			if _, ok := node.Plugin.(define.StatefulAggregator); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// restoreIncremental 恢复key 之前的进度，返回nil 表示key 需要全量计算。
+// 进度不存在，任务的aggregator 配置变化，或者状态无法恢复的时候，从周期开始重新收集数据
+func (t *task) restoreIncremental(ctx context.Context, metricMetadata define.MetricMetadata, key string,
+	chains []*define.AggregatorChain) ([]*define.AggregatorChain, *incrementalKey, error) {
+	if !statefulChains(chains) {
+		ctx.Log().Debugf("full collect. reason: aggregator not support state. name: %s, key: %s", t.name, key)
+		return chains, nil, nil
+	}
+	incKey := &incrementalKey{}
+	t.incrementalKeys.Store(failedKeyID(metricMetadata, key), incKey)
+	// 回填需要全量计算
+	if t.backfill {
+		return chains, incKey, nil
+	}
+	state, exists, err := t.event.incrementalState.Get(ctx, t.name, metricMetadata.Start, metricMetadata.End, key)
+	if err != nil {
+		ctx.Log().Errorf("get incremental state error, full collect. name: %s, key: %s, err: %s", t.name, key, err.Error())
+		return chains, incKey, nil
+	}
+	if !exists {
+		return chains, incKey, nil
+	}
+	names := make([]string, 0, len(state.Aggregators))
+	for _, chain := range chains {
+		for node := chain; node != nil; node = node.Next {
+			names = append(names, node.Plugin.Name())
+		}
+	}
+	if len(names) != len(state.Aggregators) {
+		ctx.Log().Infof("full collect. reason: aggregator changed. name: %s, key: %s", t.name, key)
+		return chains, incKey, nil
+	}
+	for idx, name := range names {
+		if state.Aggregators[idx].Name != name {
+			ctx.Log().Infof("full collect. reason: aggregator changed. name: %s, key: %s", t.name, key)
+			return chains, incKey, nil
+		}
+	}
+
+	idx := 0
+	for _, chain := range chains {
+		for node := chain; node != nil; node = node.Next {
+			if err := node.Plugin.(define.StatefulAggregator).Restore(ctx, state.Aggregators[idx].State); err != nil {
+				ctx.Log().Errorf("restore aggregator state error, full collect. name: %s, key: %s, plugin: %s, err: %s",
+					t.name, key, node.Plugin.Name(), err.Error())
+				// 部分aggregator 已经恢复了状态，需要重新生成
+				chains, err := t.newKeyAggregators(ctx, metricMetadata)
+				return chains, incKey, err
+			}
+			idx++
+		}
+	}
+	incKey.setWatermark(state.Watermark)
+	return chains, incKey, nil
+}
+
+// captureIncremental key 的数据全部处理完成后，记录aggregator 的状态
+func (t *task) captureIncremental(ctx context.Context, metricMetadata define.MetricMetadata, key string,
+	chains []*define.AggregatorChain) error {
+	value, ok := t.incrementalKeys.Load(failedKeyID(metricMetadata, key))
+	if !ok {
+		return nil
+	}
+	aggregators := make([]define.AggregatorState, 0, len(chains))
+	for _, chain := range chains {
+		for node := chain; node != nil; node = node.Next {
+			state, err := node.Plugin.(define.StatefulAggregator).State(ctx)
+			if err != nil {
+				return err
+			}
+			aggregators = append(aggregators, define.AggregatorState{Name: node.Plugin.Name(), State: state})
+		}
+	}
+	value.(*incrementalKey).setAggregators(aggregators)
+	return nil
+}
+
+// saveIncremental 结果写入output 之后保存进度，保存失败下一次从上一个进度继续，结果不受影响
+func (t *task) saveIncremental(ctx context.Context, metricMetadata define.MetricMetadata, key string) {
+	value, ok := t.incrementalKeys.LoadAndDelete(failedKeyID(metricMetadata, key))
+	if !ok {
+		return
+	}
+	incKey := value.(*incrementalKey)
+	incKey.mutex.Lock()
+	state := define.IncrementalState{
+		TaskName:    t.name,
+		CycleStart:  metricMetadata.Start,
+		CycleEnd:    metricMetadata.End,
+		Key:         key,
+		Watermark:   incKey.watermark,
+		Aggregators: incKey.aggregators,
+		UpdateTime:  uint64(time.Now().Unix()),
+	}
+	incKey.mutex.Unlock()
+	if err := t.event.incrementalState.Save(ctx, state); err != nil {
+		ctx.Log().Errorf("save incremental state error. name: %s, key: %s, err: %s", t.name, key, err.Error())
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	incrementalMemory "github.com/rentiansheng/incenses/src/handle/incremental/memory"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// incCollect 每个key 有total 条数据，watermark 是已经收集的数量
+type incCollect struct {
+	*testCollect
+
+	mutex      sync.Mutex
+	total      int
+	watermarks []string
+	// collected 全部key 收集的记录数量
+	collected int
+}
+
+func (c *incCollect) RunIncremental(ctx context.Context, key string, start, end uint64, watermark string,
+	input chan define.Record) (string, error) {
+	c.mutex.Lock()
+	total := c.total
+	c.watermarks = append(c.watermarks, watermark)
+	c.mutex.Unlock()
+
+	begin := 0
+	if watermark != "" {
+		var err error
+		if begin, err = strconv.Atoi(watermark); err != nil {
+			return "", err
+		}
+	}
+	for idx := begin; idx < total; idx++ {
+		input <- define.NewRecord(fmt.Sprintf("%s-%d", key, idx),
+			map[string]string{"key": key}, map[string]float64{"value": float64(idx)})
+	}
+	c.mutex.Lock()
+	c.collected += total - begin
+	c.mutex.Unlock()
+	return strconv.Itoa(total), nil
+}
+
+// reset 修改数据的数量，返回之前记录的watermark 和收集的数量
+func (c *incCollect) reset(total int) ([]string, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	watermarks, collected := c.watermarks, c.collected
+	c.total, c.watermarks, c.collected = total, nil, 0
+	return watermarks, collected
+}
+
+func TestIncremental(t *testing.T) {
+	sink := newTestSink()
+	collect := &incCollect{testCollect: &testCollect{keys: []string{"k1"}, records: 5}, total: 3}
+	taskInfo := newTestTask("incremental", collect.testCollect, sink)
+	taskInfo.Policy.Incremental = true
+	collects.Add(taskInfo.Collect.Name, func() define.Collect { return collect })
+	e, _ := newTestEvent(t, taskInfo)
+	e.SetIncrementalState(incrementalMemory.New())
+	ctx := context.Background()
+
+	// 第一次全量收集
+	require.NoError(t, e.runTask(ctx, taskInfo, false), "full run")
+	require.Equal(t, float64(3), sink.get("k1").Value["cnt"], "full count")
+	watermarks, collected := collect.reset(5)
+	require.Equal(t, []string{""}, watermarks, "full watermark")
+	require.Equal(t, 3, collected, "full collected")
+
+	// 同一个周期再次执行，只收集新增的数据，count 从保存的状态继续
+	require.NoError(t, e.runTask(ctx, taskInfo, false), "incremental run")
+	require.Equal(t, float64(5), sink.get("k1").Value["cnt"], "incremental count")
+	watermarks, collected = collect.reset(5)
+	require.Equal(t, []string{"3"}, watermarks, "incremental watermark")
+	require.Equal(t, 2, collected, "incremental collected")
+
+	// 删除进度后全量计算
+	metadata := sink.metadata[len(sink.metadata)-1]
+	require.NoError(t, e.ResetIncremental(ctx, taskInfo.TaskName, metadata.Start, metadata.End), "reset")
+	require.NoError(t, e.runTask(ctx, taskInfo, false), "run after reset")
+	require.Equal(t, float64(5), sink.get("k1").Value["cnt"], "count after reset")
+	watermarks, collected = collect.reset(5)
+	require.Equal(t, []string{""}, watermarks, "watermark after reset")
+	require.Equal(t, 5, collected, "collected after reset")
+
+	// 关闭增量计算后不使用保存的进度
+	taskInfo.Policy.Incremental = false
+	require.NoError(t, e.runTask(ctx, taskInfo, false), "full run")
+	require.Equal(t, float64(5), sink.get("k1").Value["cnt"], "count without incremental")
+	require.Equal(t, 1, collect.runCount("k1"), "Run called without incremental")
+}
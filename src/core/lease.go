@@ -0,0 +1,57 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/19
+    @desc:
+
+***************************/
+
+// keepLease 任务执行过程中定期续约任务锁。
+// 锁已经被其他人持有，或者超过租约时间没有续约成功的时候，取消任务，避免任务在多个节点同时执行
+func (e *event) keepLease(ctx context.Context, name, key string, lease time.Duration, cancelFn func()) (stop func()) {
+	stopChn := make(chan struct{})
+	stopOnce := sync.Once{}
+
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		lastRenewTime := time.Now()
+		for {
+			select {
+			case <-stopChn:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ok, err := e.lock.Refresh(ctx, key, lease)
+				if err != nil {
+					ctx.Log().Errorf("renew task lock error. name: %s, err: %s", name, err.Error())
+					// 网络等临时错误，在租约过期前继续重试
+					if time.Since(lastRenewTime) < lease {
+						continue
+					}
+				} else if ok {
+					lastRenewTime = time.Now()
+					continue
+				}
+				ctx.Log().Errorf("task lock lost, cancel task. name: %s", name)
+				cancelFn()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		stopOnce.Do(func() {
+			close(stopChn)
+		})
+	}
+}
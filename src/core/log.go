@@ -0,0 +1,101 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/context/log"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// taskLogLevels 执行策略中解析后的日志级别，task 为nil 的时候使用默认的日志级别
+type taskLogLevels struct {
+	task    *log.Level
+	plugins map[string]log.Level
+}
+
+func parseTaskLogLevels(policy define.MetricTaskPolicy) (taskLogLevels, error) {
+	levels := taskLogLevels{}
+	if policy.LogLevel != "" {
+		level, err := log.ParseLevel(policy.LogLevel)
+		if err != nil {
+			return levels, fmt.Errorf("task policy log_level error. %w", err)
+		}
+		levels.task = &level
+	}
+	for name, text := range policy.PluginLogLevel {
+		level, err := log.ParseLevel(text)
+		if err != nil {
+			return levels, fmt.Errorf("task policy plugin_log_level error. plugin name: %s, %w", name, err)
+		}
+		if levels.plugins == nil {
+			levels.plugins = make(map[string]log.Level, len(policy.PluginLogLevel))
+		}
+		levels.plugins[name] = level
+	}
+	return levels, nil
+}
+
+// logContext 任务执行的context，日志带上本次执行的run_id 和任务的名字，使用任务的日志级别。
+// run_id 和执行记录的RunID、trace 中task span 的run_id 相同
+func (t *task) logContext(ctx context.Context) context.Context {
+	ctx = ctx.WithLogFields(log.Field("run_id", t.runID), log.Field("task_name", t.name))
+	if t.logLevels.task != nil {
+		ctx = ctx.WithLogLevel(*t.logLevels.task)
+	}
+	return ctx
+}
+
+// cycleLogContext 日志带上周期的开始时间，分片执行的时候带上分片
+func (t *task) cycleLogContext(ctx context.Context, metricMetadata define.MetricMetadata) context.Context {
+	fields := []log.FieldImpl{log.Field("cycle", metricMetadata.Start)}
+	if t.sharding() {
+		fields = append(fields, log.Field("shard", t.shard))
+	}
+	return ctx.WithLogFields(fields...)
+}
+
+func (t *task) keyLogContext(ctx context.Context, key string) context.Context {
+	return ctx.WithLogFields(log.Field("key", key))
+}
+
+// pluginLogContext 调用插件使用的context，日志带上插件的名字，配置了插件的日志级别时使用插件的级别
+func (t *task) pluginLogContext(ctx context.Context, name string) context.Context {
+	ctx = ctx.WithLogFields(log.Field("plugin", name))
+	if level, ok := t.logLevels.plugins[name]; ok {
+		ctx = ctx.WithLogLevel(level)
+	}
+	return ctx
+}
+
+// pluginLogContexts 缓存每个插件的context，filter 和aggregator 每条数据调用一次，避免每次调用生成context。
+// 只在一个协程中使用
+type pluginLogContexts struct {
+	task *task
+	ctx  context.Context
+	ctxs map[string]context.Context
+}
+
+func (t *task) newPluginLogContexts(ctx context.Context) *pluginLogContexts {
+	return &pluginLogContexts{
+		task: t,
+		ctx:  ctx,
+		ctxs: make(map[string]context.Context),
+	}
+}
+
+func (p *pluginLogContexts) get(name string) context.Context {
+	ctx, ok := p.ctxs[name]
+	if !ok {
+		ctx = p.task.pluginLogContext(p.ctx, name)
+		p.ctxs[name] = ctx
+	}
+	return ctx
+}
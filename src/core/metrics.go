@@ -0,0 +1,260 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/metrics"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+const (
+	// channelCollect collect 发送给filter 的chan
+	channelCollect = "collect"
+	// channelFilter filter 发送给aggregator 的chan
+	channelFilter = "filter"
+	// channelOutput aggregator 发送给output 的chan
+	channelOutput = "output"
+)
+
+// engineMetrics 引擎内部的监控指标，为nil 的时候不上报，方法都可以在nil 上调用
+type engineMetrics struct {
+	registry *metrics.Registry
+
+	taskRuns        *metrics.Counter
+	taskDuration    *metrics.Histogram
+	keys            *metrics.Counter
+	keyExistsSkips  *metrics.Counter
+	records         *metrics.Counter
+	dedupHits       *metrics.Counter
+	retries         *metrics.Counter
+	lockFailures    *metrics.Counter
+	outputLatency   *metrics.Histogram
+	outputErrors    *metrics.Counter
+	channelSends    *metrics.Counter
+	channelBlocked  *metrics.Counter
+	schedulerTasks  *metrics.Gauge
+	schedulerWeight *metrics.Gauge
+}
+
+func newEngineMetrics() *engineMetrics {
+	r := metrics.NewRegistry()
+	return &engineMetrics{
+		registry: r,
+		taskRuns: r.NewCounter("incenses_task_runs_total",
+			"Task runs by run type and result.", "task", "run_type", "status"),
+		taskDuration: r.NewHistogram("incenses_task_run_duration_seconds",
+			"Task run duration in seconds.", nil, "task", "run_type"),
+		keys: r.NewCounter("incenses_keys_total",
+			"Keys by stage: total, processed, skipped, failed, deferred.", "task", "stage"),
+		keyExistsSkips: r.NewCounter("incenses_keys_exists_skipped_total",
+			"Keys skipped because the result already exists in all outputs.", "task"),
+		records: r.NewCounter("incenses_records_total",
+			"Records and metric results by pipeline stage.", "task", "stage"),
+		dedupHits: r.NewCounter("incenses_dedup_hits_total",
+			"Records dropped as duplicates.", "task"),
+		retries: r.NewCounter("incenses_retry_attempts_total",
+			"Retry attempts of collect plugins and failed keys.", "task", "kind"),
+		lockFailures: r.NewCounter("incenses_lock_failures_total",
+			"Lock acquisitions that failed or found the lock held by another node.", "task", "lock", "reason"),
+		outputLatency: r.NewHistogram("incenses_output_write_duration_seconds",
+			"Output plugin write latency in seconds.", nil, "task", "output"),
+		outputErrors: r.NewCounter("incenses_output_write_errors_total",
+			"Output plugin write errors.", "task", "output"),
+		channelSends: r.NewCounter("incenses_channel_sends_total",
+			"Sends on pipeline channels.", "task", "channel"),
+		channelBlocked: r.NewCounter("incenses_channel_full_total",
+			"Sends on pipeline channels that found the buffer full.", "task", "channel"),
+		schedulerTasks: r.NewGauge("incenses_scheduler_tasks",
+			"Scheduled tasks by state.", "state"),
+		schedulerWeight: r.NewGauge("incenses_scheduler_weight",
+			"Scheduler weight in use and capacity.", "kind"),
+	}
+}
+
+// EnableMetrics 开启监控指标，返回prometheus text 格式的http.Handler，可以挂载到调用方的http 服务中
+func (e *event) EnableMetrics() http.Handler {
+	if e.metrics == nil {
+		e.metrics = newEngineMetrics()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.observeScheduler()
+		e.metrics.registry.ServeHTTP(w, r)
+	})
+}
+
+// SetMetricsAddr 开启监控指标，Start 的时候在addr 上启动http 服务，路径为/metrics，Stop 的时候关闭
+func (e *event) SetMetricsAddr(addr string) {
+	e.EnableMetrics()
+	e.metricsAddr = addr
+}
+
+// startMetricsServer 调用方需要持有e.mutex
+func (e *event) startMetricsServer(ctx context.Context) error {
+	if e.metricsAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", e.metricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e.EnableMetrics())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ctx.Log().Errorf("metrics server error. addr: %s, err: %s", e.metricsAddr, err.Error())
+		}
+	}()
+	e.metricsServer = server
+	return nil
+}
+
+// stopMetricsServer 调用方需要持有e.mutex
+func (e *event) stopMetricsServer() {
+	if e.metricsServer == nil {
+		return
+	}
+	_ = e.metricsServer.Close()
+	e.metricsServer = nil
+}
+
+// observeScheduler 读取指标的时候更新任务调度的情况
+func (e *event) observeScheduler() {
+	stats := e.scheduler.Stats()
+	e.metrics.schedulerTasks.Set(float64(stats.Running), "running")
+	e.metrics.schedulerTasks.Set(float64(stats.Waiting), "waiting")
+	e.metrics.schedulerWeight.Set(float64(stats.Used), "used")
+	e.metrics.schedulerWeight.Set(float64(stats.Capacity), "capacity")
+}
+
+func runTypeName(runType define.RunType) string {
+	switch runType {
+	case define.RunTypeSchedule:
+		return "schedule"
+	case define.RunTypeBackfill:
+		return "backfill"
+	case define.RunTypeManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+func runStatusName(status define.RunStatusType) string {
+	switch status {
+	case define.RunStatusTypeSuccess:
+		return "success"
+	case define.RunStatusTypeFailure:
+		return "failure"
+	case define.RunStatusTypeCanceled:
+		return "canceled"
+	default:
+		return "running"
+	}
+}
+
+func (m *engineMetrics) taskRun(taskName string, runType define.RunType, status define.RunStatusType, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.taskRuns.Inc(taskName, runTypeName(runType), runStatusName(status))
+	m.taskDuration.Observe(duration.Seconds(), taskName, runTypeName(runType))
+}
+
+// stageNames taskStats 中需要上报的阶段，keys 中的阶段上报到incenses_keys_total，其他的上报到incenses_records_total
+var stageNames = map[taskStage]struct {
+	key  bool
+	name string
+}{
+	taskStageKeyTotal:     {true, "total"},
+	taskStageKeyProcessed: {true, "processed"},
+	taskStageKeySkipped:   {true, "skipped"},
+	taskStageKeyFailed:    {true, "failed"},
+	taskStageKeyDeferred:  {true, "deferred"},
+	taskStageCollected:    {false, "collected"},
+	taskStageDuplicated:   {false, "duplicated"},
+	taskStageFiltered:     {false, "filtered"},
+	taskStageAggregated:   {false, "aggregated"},
+	taskStageDropped:      {false, "dropped"},
+	taskStageOutput:       {false, "output"},
+}
+
+// statsReporter taskStats 计数变化的时候上报
+func (m *engineMetrics) statsReporter(taskName string) func(stage taskStage, delta int64) {
+	if m == nil {
+		return nil
+	}
+	return func(stage taskStage, delta int64) {
+		stageName, ok := stageNames[stage]
+		if !ok {
+			return
+		}
+		if stageName.key {
+			m.keys.Add(float64(delta), taskName, stageName.name)
+			return
+		}
+		m.records.Add(float64(delta), taskName, stageName.name)
+		if stage == taskStageDuplicated {
+			m.dedupHits.Add(float64(delta), taskName)
+		}
+	}
+}
+
+func (m *engineMetrics) keyExistsSkip(taskName string) {
+	if m == nil {
+		return
+	}
+	m.keyExistsSkips.Inc(taskName)
+}
+
+// retry kind 为collect 或者key
+func (m *engineMetrics) retry(taskName, kind string) {
+	if m == nil {
+		return
+	}
+	m.retries.Inc(taskName, kind)
+}
+
+// lockFailure lock 为锁的用途，err 为nil 的时候表示锁被其他节点持有
+func (m *engineMetrics) lockFailure(taskName, lock string, err error) {
+	if m == nil {
+		return
+	}
+	reason := "held"
+	if err != nil {
+		reason = "error"
+	}
+	m.lockFailures.Inc(taskName, lock, reason)
+}
+
+func (m *engineMetrics) outputWrite(taskName, output string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.outputLatency.Observe(duration.Seconds(), taskName, output)
+	if err != nil {
+		m.outputErrors.Inc(taskName, output)
+	}
+}
+
+// channelSend 发送前调用，full 表示chan 的缓冲已满，发送会阻塞
+func (m *engineMetrics) channelSend(taskName, channel string, full bool) {
+	if m == nil {
+		return
+	}
+	m.channelSends.Inc(taskName, channel)
+	if full {
+		m.channelBlocked.Inc(taskName, channel)
+	}
+}
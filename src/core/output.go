@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/context/log"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/trace"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/16
+    @desc:
+
+***************************/
+
+// taskOutput 任务使用的output 插件，及写入失败的处理方式
+type taskOutput struct {
+	plugin        define.Output
+	failurePolicy define.OutputFailurePolicyType
+}
+
+// primaryOutput 主output, 数据存放的位置(IndexName)以主output 为准
+func (t *task) primaryOutput() define.Output {
+	if len(t.outputPlugins) == 0 {
+		return nil
+	}
+	return t.outputPlugins[0].plugin
+}
+
+func (t *task) setOutputsMetricMetadata(ctx context.Context, metricMetadata define.MetricMetadata) error {
+	for _, output := range t.outputPlugins {
+		if err := output.plugin.SetMetricMetadata(ctx, metricMetadata); err != nil {
+			return fmt.Errorf("output plugin %s set metric metadata error. %w", output.plugin.Name(), err)
+		}
+	}
+	return nil
+}
+
+// outputsExists 失败策略为fail 的output 都已经存在key 的数据，key 才可以跳过计算。
+// ignore 的output 写入失败不影响任务结果，缺少数据也不需要重新计算；没有fail 的output 的时候不跳过计算。
+// 查询出现错误当作不存在，最多是重复执行一次计算
+func (t *task) outputsExists(ctx context.Context, key string) bool {
+	checked := false
+	for _, output := range t.outputPlugins {
+		if output.failurePolicy == define.OutputFailurePolicyTypeIgnore {
+			continue
+		}
+		exists, err := output.plugin.Exists(t.pluginLogContext(ctx, output.plugin.Name()), key)
+		if err != nil {
+			ctx.Log().Errorf("output plugin exists error. name: %s, key: %s, err: %s", output.plugin.Name(), key, err.Error())
+			return false
+		}
+		if !exists {
+			return false
+		}
+		checked = true
+	}
+	return checked
+}
+
+// writeOutputs 按照顺序写入全部output，写入失败根据output 的failurePolicy 决定是否返回错误
+func (t *task) writeOutputs(ctx context.Context, input define.OutputInput, data define.OutputData) error {
+	keySpan := t.keySpan(input.MetricDataDesc, data.MetricKey)
+	for _, output := range t.outputPlugins {
+		span := t.startSpan(keySpan, "output.Write", trace.String("plugin", output.plugin.Name()))
+		start := time.Now()
+		err := output.plugin.Write(t.pluginLogContext(ctx, output.plugin.Name()), data)
+		t.metrics.outputWrite(t.name, output.plugin.Name(), time.Since(start), err)
+		span.End(err)
+		if err == nil {
+			continue
+		}
+		ctx.Log().Fields(log.Field("data", data.MetricData), log.Field("metric data desc", input.MetricDataDesc)).
+			Errorf("write metric error. name: %s, err: %s", output.plugin.Name(), err.Error())
+		if output.failurePolicy == define.OutputFailurePolicyTypeIgnore {
+			continue
+		}
+		return fmt.Errorf("output plugin %s write error. %w", output.plugin.Name(), err)
+	}
+	return nil
+}
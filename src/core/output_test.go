@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/outputs"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func TestOutputWriteError(t *testing.T) {
+	name := "output_write_error"
+	sink, ignoreSink := newTestSink(), newTestSink()
+	taskInfo := newTestTask(name, &testCollect{keys: []string{"k1", "k2", "k3"}, records: 2}, sink)
+	outputs.Add(name+"_ignore", func() define.Output { return &testOutput{name: name + "_ignore", sink: ignoreSink} })
+	taskInfo.Outputs = define.MetricTaskPluginOutputConfigArr{
+		{Name: name},
+		{Name: name + "_ignore", FailurePolicy: define.OutputFailurePolicyTypeIgnore},
+	}
+	e, tasks := newTestEvent(t, taskInfo)
+	ctx := context.Background()
+
+	// 忽略失败的output 写入失败不影响任务
+	ignoreSink.writeErr = func(data define.OutputData) error { return errors.New("ignore output error") }
+	sink.writeErr = func(data define.OutputData) error {
+		if data.MetricKey == "k2" {
+			return errors.New("primary output error")
+		}
+		return nil
+	}
+	// 写入失败取消本次执行，返回写入的错误
+	err := e.runTask(ctx, taskInfo, false)
+	require.Error(t, err, "primary output error")
+	require.Contains(t, err.Error(), "primary output error", "write error")
+	require.NotContains(t, sink.keys(), "k2", "failed key")
+	require.Empty(t, ignoreSink.keys(), "ignore output")
+	require.Equal(t, 0, tasks.doneCount(), "cycle not finalized")
+
+	sink.writeErr = nil
+	taskInfo, err = tasks.GetByName(ctx, name)
+	require.NoError(t, err, "get task")
+	require.NoError(t, e.runTask(ctx, taskInfo, false), "retry failed key")
+	require.Equal(t, []string{"k1", "k2", "k3"}, sink.keys(), "primary output")
+	require.Equal(t, float64(2), sink.get("k2").Value["cnt"], "k2 count")
+	require.Equal(t, 1, tasks.doneCount(), "cycle finalized")
+}
+
+func TestOutputsExists(t *testing.T) {
+	name := "outputs_exists"
+	sink, ignoreSink := newTestSink(), newTestSink()
+	collect := &testCollect{keys: []string{"k1", "k2"}, records: 2}
+	taskInfo := newTestTask(name, collect, sink)
+	outputs.Add(name+"_ignore", func() define.Output { return &testOutput{name: name + "_ignore", sink: ignoreSink} })
+	taskInfo.Outputs = define.MetricTaskPluginOutputConfigArr{
+		{Name: name},
+		{Name: name + "_ignore", FailurePolicy: define.OutputFailurePolicyTypeIgnore},
+	}
+	e, _ := newTestEvent(t, taskInfo)
+
+	// k1 已经写入了主output，ignore 的output 没有k1 的数据
+	sink.exists, ignoreSink.exists = true, true
+	sink.writes["k1"] = define.OutputData{MetricData: define.MetricData{MetricKey: "k1"}}
+	require.NoError(t, e.runTask(context.Background(), taskInfo, false), "run")
+	require.Equal(t, 0, collect.runCount("k1"), "k1 skipped")
+	require.Equal(t, 1, collect.runCount("k2"), "k2 computed")
+	require.Equal(t, []string{"k2"}, ignoreSink.keys(), "ignore output")
+}
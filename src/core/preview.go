@@ -0,0 +1,154 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	timeCycle "github.com/rentiansheng/incenses/src/libs/time_cycle"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/21
+    @desc:
+
+***************************/
+
+// PreviewOption 预览的参数
+type PreviewOption struct {
+	// Time 需要预览的周期内的任意时间，单位秒，0 使用当前时间所在的周期
+	Time uint64
+	// Keys 只计算这些key，为空的时候计算collect 插件返回的全部key
+	Keys []string
+}
+
+// PreviewStats 每个阶段处理的数据数量
+type PreviewStats struct {
+	// Keys 计算的key 数量
+	Keys int64 `json:"keys"`
+	// Collected collect 插件输出的记录数量
+	Collected int64 `json:"collected"`
+	// Duplicated uuid 重复被丢弃的记录数量
+	Duplicated int64 `json:"duplicated"`
+	// Filtered filter 插件输出给aggregator 的记录数量
+	Filtered int64 `json:"filtered"`
+	// Aggregated aggregator 输出的统计结果数量
+	Aggregated int64 `json:"aggregated"`
+	// Dropped 被metric filter 丢弃的统计结果数量
+	Dropped int64 `json:"dropped"`
+	// Output 最终需要写入output 的统计结果数量
+	Output int64 `json:"output"`
+	// DedupMemory 单个去重实例占用内存的最大值，单位字节
+	DedupMemory int64 `json:"dedup_memory"`
+}
+
+// PreviewResult 预览的结果
+type PreviewResult struct {
+	MetricMetadata define.MetricMetadata `json:"metric_metadata"`
+	// Data 需要写入output 的统计结果，按照MetricKey 排序
+	Data  []define.MetricData `json:"data"`
+	Stats PreviewStats        `json:"stats"`
+}
+
+// Preview 预览任务在一个周期的计算结果，taskInfo 不需要保存到任务存储中。
+// 执行collect, filter, aggregator, metric filter 后把结果保存在内存中返回，
+// 不获取锁，不写入output，不使用checkpoint，不修改任务的周期状态
+func (e *event) Preview(ctx context.Context, taskInfo define.MetricTask, opt PreviewOption) (PreviewResult, error) {
+	result := PreviewResult{}
+	// 执行过程中会取消ctx，不能影响调用方
+	ctx = ctx.SubCtx(taskInfo.TaskName)
+	ts := opt.Time
+	if ts == 0 {
+		ts = uint64(time.Now().Unix())
+	}
+	cycle, err := timeCycle.GetTimeInterval(ts, timeCycle.CycleType(taskInfo.TaskCycle))
+	if err != nil {
+		ctx.Log().Errorf("get task cycle range error. name: %s, err: %s", taskInfo.TaskName, err.Error())
+		return result, err
+	}
+
+	t, err := e.taskPipelineParams(ctx, taskInfo)
+	if err != nil {
+		return result, err
+	}
+	t.preview = newPreviewCapture(opt.Keys)
+	t.metricMetadataArr = []define.MetricMetadata{{
+		MetricName:     taskInfo.TaskName,
+		Start:          cycle.Begin,
+		End:            cycle.End,
+		Cycle:          taskInfo.TaskCycle,
+		CycleMode:      taskInfo.CycleMode,
+		LastFinishTime: taskInfo.LastFinishTime,
+	}}
+
+	err = t.runCycles(ctx)
+	result.MetricMetadata = t.metricMetadataArr[0]
+	result.Data = t.preview.result()
+	result.Stats = t.previewStats()
+	return result, err
+}
+
+// previewCapture 保存预览的结果，filterKeys 可以在nil 上调用
+type previewCapture struct {
+	// keys 只计算这些key，为nil 的时候计算全部key
+	keys  map[string]struct{}
+	mutex sync.Mutex
+	data  []define.MetricData
+}
+
+func newPreviewCapture(keys []string) *previewCapture {
+	p := &previewCapture{}
+	if len(keys) != 0 {
+		p.keys = make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			p.keys[key] = struct{}{}
+		}
+	}
+	return p
+}
+
+// filterKeys 只保留需要预览的key
+func (p *previewCapture) filterKeys(keys []string) []string {
+	if p == nil || p.keys == nil {
+		return keys
+	}
+	results := make([]string, 0, len(p.keys))
+	for _, key := range keys {
+		if _, ok := p.keys[key]; ok {
+			results = append(results, key)
+		}
+	}
+	return results
+}
+
+func (p *previewCapture) write(data define.MetricData) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.data = append(p.data, data)
+}
+
+func (p *previewCapture) result() []define.MetricData {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sort.Slice(p.data, func(i, j int) bool {
+		return p.data[i].MetricKey < p.data[j].MetricKey
+	})
+	return p.data
+}
+
+func (t *task) previewStats() PreviewStats {
+	return PreviewStats{
+		Keys:       t.stats.get(taskStageKeyProcessed),
+		Collected:  t.stats.get(taskStageCollected),
+		Duplicated: t.stats.get(taskStageDuplicated),
+		Filtered:   t.stats.get(taskStageFiltered),
+		Aggregated: t.stats.get(taskStageAggregated),
+		Dropped:    t.stats.get(taskStageDropped),
+		Output:     t.stats.get(taskStageOutput),
+
+		DedupMemory: t.stats.get(taskStageDedupMemory),
+	}
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/26
+    @desc:
+
+***************************/
+
+// SetShard 修改记录已经完成分片的存储，为nil 的时候任务不分片，整个任务在一个节点上执行
+func (e *event) SetShard(shard define.ShardImpl) {
+	e.shard = shard
+}
+
+// shardOf key 所在的分片
+func shardOf(key string, shardNum int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shardNum))
+}
+
+// sharding 任务是否需要分片执行
+func (t *task) sharding() bool {
+	return t.policy.ShardNum > 1 && t.event.shard != nil
+}
+
+// shardKeys 分片执行的时候，只保留当前分片的key
+func (t *task) shardKeys(keys []string) []string {
+	if t.shardNum <= 1 {
+		return keys
+	}
+	result := make([]string, 0, len(keys)/t.shardNum+1)
+	for _, key := range keys {
+		if shardOf(key, t.shardNum) == t.shard {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func (t *task) shardLockKey(runKey string, shard int) string {
+	return fmt.Sprintf("%s%s:%d", define.ShardLockKeyPrefix, runKey, shard)
+}
+
+// runShards 分片执行任务。节点依次获取没有完成的分片，计算分片中的key，
+// 全部分片完成后，获取任务锁的节点判断周期是否可以完成。节点异常退出后，分片的锁在租约时间后释放，由其他节点继续计算。
+// 判断周期和修改任务信息需要持有任务锁，和其他节点完成周期互斥，分片计算的时候不持有任务锁，多个节点并行计算
+func (t *task) runShards(ctx context.Context) (err error) {
+	t.taskSuccess = true
+	externalDone := ctx.Done()
+	cancelFn := ctx.Cancel()
+	defer cancelFn()
+	t.ctxCancelFn = t.TaskStatusFailure(cancelFn)
+
+	unlockTask, locked, err := t.lockTask(ctx, "task")
+	if err != nil {
+		return err
+	}
+	if !locked {
+		// 手动执行的时候需要告诉调用方没有执行
+		if t.manual {
+			return ErrTaskRunning
+		}
+		return nil
+	}
+	defer unlockTask()
+	if advanced, err := t.cycleAdvanced(ctx); err != nil || advanced {
+		return err
+	}
+	if !t.canExecCycle(ctx) {
+		return nil
+	}
+	history := t.startRun(ctx, t.execRunType())
+	defer func() {
+		// 需要在记录结果前处理panic
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("panic: %#v", panicErr)
+			debug.PrintStack()
+		}
+		t.afterTask(ctx, err)
+		t.finishRun(ctx, history, err, externalDone)
+	}()
+	if err := t.beforeTask(ctx); err != nil {
+		return err
+	}
+	if err := t.ModifyOutputIndexName(ctx); err != nil {
+		return err
+	}
+	unlockTask()
+
+	runKey := define.CheckpointRunKey(t.metricMetadataArr[0])
+	shardNum := t.policy.ShardNum
+	// 不同节点从不同的分片开始，减少锁的竞争
+	offset := int(time.Now().UnixNano() % int64(shardNum))
+	for idx := 0; idx < shardNum; idx++ {
+		if ctx.IsDone() {
+			break
+		}
+		if err := t.execShard(ctx, runKey, (offset+idx)%shardNum); err != nil {
+			return err
+		}
+	}
+	// 超时或者任务被取消了。不需要更新db周期数据
+	if ctx.Err() != nil {
+		ctx.Log().Errorf("task context error. err: %s", ctx.Err())
+		return ctx.Err()
+	}
+
+	return t.finalizeShards(ctx, runKey)
+}
+
+// lockTask 获取任务锁，没有获取到锁的时候跳过。返回的释放方法可以多次调用
+func (t *task) lockTask(ctx context.Context, stage string) (func(), bool, error) {
+	_, locked, err := t.event.lock.Lock(ctx, t.lockKey(), define.FencingKey(t.name), t.policy.LockLeaseDuration())
+	if err != nil {
+		ctx.Log().Errorf("get task locked error. name: %s, err: %s", t.name, err.Error())
+		t.metrics.lockFailure(t.name, stage, err)
+		return nil, false, err
+	}
+	if !locked {
+		ctx.Log().Debugf("skip %s. reason: locked by other node. name: %s", stage, t.name)
+		t.metrics.lockFailure(t.name, stage, nil)
+		return nil, false, nil
+	}
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			if err := t.event.lock.Unlock(context.Detach(ctx), t.lockKey()); err != nil {
+				ctx.Log().Errorf("release task locked error. name: %s, err: %s", t.name, err.Error())
+			}
+		})
+	}, true, nil
+}
+
+// cycleAdvanced 获取任务锁之前，其他节点可能已经完成了周期，需要使用任务最新的状态判断
+func (t *task) cycleAdvanced(ctx context.Context) (bool, error) {
+	current, err := t.event.taskHandle.GetByName(ctx, t.name)
+	if err != nil {
+		ctx.Log().Errorf("get task error. name: %s, err: %s", t.name, err.Error())
+		return false, err
+	}
+	if current.TaskStart > t.metricMetadataArr[0].End {
+		ctx.Log().Infof("skip, cycle finalized by other node. name: %s", t.name)
+		return true, nil
+	}
+	t.taskLastFinishTime = int64(current.LastFinishTime)
+	return false, nil
+}
+
+// execShard 获取分片的锁，计算分片中的key。分片已经完成或者被其他节点持有的时候跳过
+func (t *task) execShard(ctx context.Context, runKey string, shard int) error {
+	finished, err := t.event.shard.Finished(ctx, runKey)
+	if err != nil {
+		ctx.Log().Errorf("get finished shard error. name: %s, err: %s", t.name, err.Error())
+		return err
+	}
+	if _, ok := finished[shard]; ok {
+		return nil
+	}
+
+	lockKey := t.shardLockKey(runKey, shard)
+	fencingToken, locked, err := t.event.lock.Lock(ctx, lockKey, define.FencingKey(t.name), t.policy.LockLeaseDuration())
+	if err != nil {
+		ctx.Log().Errorf("get shard locked error. name: %s, shard: %d, err: %s", t.name, shard, err.Error())
+		t.metrics.lockFailure(t.name, "shard", err)
+		return err
+	}
+	if !locked {
+		ctx.Log().Debugf("skip shard. reason: locked by other node. name: %s, shard: %d", t.name, shard)
+		t.metrics.lockFailure(t.name, "shard", nil)
+		return nil
+	}
+	defer func() {
+		if err := t.event.lock.Unlock(context.Detach(ctx), lockKey); err != nil {
+			ctx.Log().Errorf("release shard locked error. name: %s, shard: %d, err: %s", t.name, shard, err.Error())
+		}
+	}()
+	stopLease := t.event.keepLease(ctx, t.name, lockKey, t.policy.LockLeaseDuration(), t.ctxCancelFn)
+	defer stopLease()
+
+	// 获取锁之前，分片可能已经被其他节点完成
+	finished, err = t.event.shard.Finished(ctx, runKey)
+	if err != nil {
+		ctx.Log().Errorf("get finished shard error. name: %s, err: %s", t.name, err.Error())
+		return err
+	}
+	if _, ok := finished[shard]; ok {
+		return nil
+	}
+
+	for idx := range t.metricMetadataArr {
+		t.metricMetadataArr[idx].FencingToken = fencingToken
+	}
+	t.shardNum, t.shard = t.policy.ShardNum, shard
+	keyTotal := t.stats.get(taskStageKeyTotal)
+	keyFailed := t.stats.get(taskStageKeyFailed)
+	keyDeferred := t.stats.get(taskStageKeyDeferred)
+	ctx.Log().Infof("start shard. name: %s, shard: %d", t.name, shard)
+	if err := t.iterativeCycle(ctx); err != nil {
+		return err
+	}
+	// 分片没有完成，锁释放后由其他节点或者下一次调度继续计算
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	result := define.ShardResult{
+		Shard:       shard,
+		Node:        t.event.node,
+		KeyTotal:    t.stats.get(taskStageKeyTotal) - keyTotal,
+		KeyFailed:   t.stats.get(taskStageKeyFailed) - keyFailed,
+		KeyDeferred: t.stats.get(taskStageKeyDeferred) - keyDeferred,
+		FinishTime:  uint64(time.Now().Unix()),
+	}
+	if err := t.event.shard.Done(ctx, runKey, result); err != nil {
+		ctx.Log().Errorf("save shard result error. name: %s, shard: %d, err: %s", t.name, shard, err.Error())
+		return err
+	}
+	return nil
+}
+
+// finalizeShards 全部分片完成后，判断周期是否可以完成。使用任务锁保证只有一个节点完成周期。
+// 周期完成之后才清理分片记录，周期不能完成的时候，只删除有失败key 的分片记录，失败的key 在分片中重试
+func (t *task) finalizeShards(ctx context.Context, runKey string) error {
+	unlockTask, locked, err := t.lockTask(ctx, "finalize")
+	if err != nil || !locked {
+		return err
+	}
+	defer unlockTask()
+	if advanced, err := t.cycleAdvanced(ctx); err != nil || advanced {
+		return err
+	}
+
+	finished, err := t.event.shard.Finished(ctx, runKey)
+	if err != nil {
+		ctx.Log().Errorf("get finished shard error. name: %s, err: %s", t.name, err.Error())
+		return err
+	}
+	if len(finished) < t.policy.ShardNum {
+		ctx.Log().Infof("wait other shards. name: %s, finished shard count: %d, shard count: %d",
+			t.name, len(finished), t.policy.ShardNum)
+		return nil
+	}
+	var total, failed int64
+	failedShards := make([]int, 0)
+	for _, result := range finished {
+		total += result.KeyTotal
+		failed += result.KeyFailed + result.KeyDeferred
+		if result.KeyFailed+result.KeyDeferred > 0 {
+			failedShards = append(failedShards, result.Shard)
+		}
+	}
+	if !t.canFinalizeCount(ctx, total, failed) {
+		t.TaskStatusFailure(func() {})()
+		if err := t.event.shard.Reset(ctx, runKey, failedShards); err != nil {
+			ctx.Log().Errorf("reset failed shard error. name: %s, err: %s", t.name, err.Error())
+		}
+		return fmt.Errorf("%w. failed key count: %d", ErrTaskFailed, failed)
+	}
+	if err := t.taskDone(ctx); err != nil {
+		return err
+	}
+	// 任务失败或者被取消的时候，周期没有完成
+	if ctx.IsDone() {
+		return ctx.Err()
+	}
+	if !t.taskSuccess {
+		return t.failedError()
+	}
+	if err := t.event.shard.Clear(ctx, runKey); err != nil {
+		ctx.Log().Errorf("clear shard result error. name: %s, err: %s", t.name, err.Error())
+	}
+	return nil
+}
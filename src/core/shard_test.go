@@ -0,0 +1,85 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	shardMemory "github.com/rentiansheng/incenses/src/handle/shard/memory"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func TestShardFinalize(t *testing.T) {
+	ctx := context.Background()
+	sink := newTestSink()
+	collect := &testCollect{keys: []string{"k1", "k2", "k3", "k4", "k5", "k6"}, records: 1}
+	// k1 在分片1 中，k2 在分片0 中
+	collect.setFail("k1", true)
+	taskInfo := newTestTask("shard_finalize", collect, sink)
+	taskInfo.Policy.ShardNum = 2
+	e, tasks := newTestEvent(t, taskInfo)
+	shards := shardMemory.New()
+	e.SetShard(shards)
+	instance, err := e.taskParams(ctx, taskInfo)
+	require.NoError(t, err, "task params")
+	runKey := define.CheckpointRunKey(instance.metricMetadataArr[0])
+
+	require.ErrorIs(t, e.runTask(ctx, taskInfo, false), ErrTaskFailed, "cycle not finalized")
+	require.Equal(t, 0, tasks.doneCount(), "failed key in shard 1")
+	finished, err := shards.Finished(ctx, runKey)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(finished), "only failed shard reset")
+	require.Equal(t, int64(3), finished[0].KeyTotal, "shard 0 kept")
+
+	failedRuns := collect.runCount("k1")
+	collect.setFail("k1", false)
+	require.NoError(t, e.runTask(ctx, taskInfo, false))
+	require.Equal(t, 1, tasks.doneCount(), "cycle finalized")
+	require.Equal(t, failedRuns+1, collect.runCount("k1"), "failed shard recomputed")
+	require.Equal(t, 1, collect.runCount("k2"), "finished shard not recomputed")
+	require.Equal(t, []string{"k1", "k2", "k3", "k4", "k5", "k6"}, sink.keys())
+	finished, err = shards.Finished(ctx, runKey)
+	require.NoError(t, err)
+	require.Empty(t, finished, "shard records cleared after finalize")
+
+	// 分片使用任务的fencing token 计数器，后获取锁的分片得到更大的token
+	info, err := e.lock.(define.LockInspector).Inspect(ctx, instance.lockKey())
+	require.NoError(t, err)
+	var last uint64
+	for _, metadata := range sink.metadata {
+		// 修改output 表名的时候还没有分片的token
+		if metadata.FencingToken == 0 {
+			continue
+		}
+		require.True(t, metadata.FencingToken >= last, "token increase")
+		last = metadata.FencingToken
+	}
+	require.True(t, last > 0 && last < info.FencingToken, "token from task counter")
+}
+
+func TestShardTaskLocked(t *testing.T) {
+	ctx := context.Background()
+	collect := &testCollect{keys: []string{"k1", "k2"}, records: 1}
+	taskInfo := newTestTask("shard_task_locked", collect, newTestSink())
+	taskInfo.Policy.ShardNum = 2
+	e, _ := newTestEvent(t, taskInfo)
+	e.SetShard(shardMemory.New())
+	instance, err := e.taskParams(ctx, taskInfo)
+	require.NoError(t, err, "task params")
+
+	// 其他节点持有任务锁
+	_, locked, err := e.lock.Lock(ctx, instance.lockKey(), define.FencingKey(taskInfo.TaskName), instance.policy.LockLeaseDuration())
+	require.NoError(t, err, "lock task")
+	require.True(t, locked, "lock task")
+	require.NoError(t, e.runTask(ctx, taskInfo, false), "scheduled run skipped")
+	require.Equal(t, ErrTaskRunning, e.runTask(ctx, taskInfo, true), "manual run")
+	require.Equal(t, 0, collect.runCount("k1"), "not executed")
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"sync/atomic"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/22
+    @desc:
+
+***************************/
+
+type taskStage int
+
+const (
+	// taskStageKeyTotal collect 插件返回的key 数量
+	taskStageKeyTotal taskStage = iota
+	// taskStageKeyProcessed 开始计算的key 数量
+	taskStageKeyProcessed
+	// taskStageKeySkipped checkpoint 中已经完成或者output 中已经存在结果的key 数量
+	taskStageKeySkipped
+	// taskStageKeyFailed 执行失败和超过重试次数的key 数量
+	taskStageKeyFailed
+	// taskStageKeyDeferred 之前失败，还没有到重试时间的key 数量
+	taskStageKeyDeferred
+	// taskStageCollected collect 插件输出的记录数量
+	taskStageCollected
+	// taskStageDuplicated uuid 重复被丢弃的记录数量
+	taskStageDuplicated
+	// taskStageFiltered filter 插件输出给aggregator 的记录数量
+	taskStageFiltered
+	// taskStageAggregated aggregator 输出的统计结果数量
+	taskStageAggregated
+	// taskStageDropped 被metric filter 丢弃的统计结果数量
+	taskStageDropped
+	// taskStageOutput 写入output 的统计结果数量
+	taskStageOutput
+	// taskStageDedupMemory 单个去重实例占用内存的最大值，单位字节
+	taskStageDedupMemory
+	taskStageCnt
+)
+
+// taskStats 任务执行过程中每个阶段处理的数据数量，用于执行记录和预览
+type taskStats struct {
+	counters [taskStageCnt]int64
+	// report 计数增加的时候调用，用来上报监控指标，为nil 的时候不上报
+	report func(stage taskStage, delta int64)
+}
+
+func (s *taskStats) incr(stage taskStage) {
+	s.add(stage, 1)
+}
+
+func (s *taskStats) add(stage taskStage, delta int64) {
+	atomic.AddInt64(&s.counters[stage], delta)
+	if s.report != nil {
+		s.report(stage, delta)
+	}
+}
+
+// max 保存较大的值
+func (s *taskStats) max(stage taskStage, value int64) {
+	for {
+		old := atomic.LoadInt64(&s.counters[stage])
+		if value <= old || atomic.CompareAndSwapInt64(&s.counters[stage], old, value) {
+			return
+		}
+	}
+}
+
+func (s *taskStats) get(stage taskStage) int64 {
+	return atomic.LoadInt64(&s.counters[stage])
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	osContent "context"
+	"errors"
 	"fmt"
 	"runtime/debug"
 	"sync"
@@ -10,10 +11,11 @@ import (
 	"github.com/rentiansheng/incenses/src/context"
 	"github.com/rentiansheng/incenses/src/context/log"
 	"github.com/rentiansheng/incenses/src/define"
-	"github.com/rentiansheng/incenses/src/libs/redislock"
+	"github.com/rentiansheng/incenses/src/libs/dedup"
 	"github.com/rentiansheng/incenses/src/libs/retry"
 	"github.com/rentiansheng/incenses/src/libs/time_cycle"
 	"github.com/rentiansheng/incenses/src/libs/times"
+	"github.com/rentiansheng/incenses/src/libs/trace"
 	"github.com/rentiansheng/incenses/src/libs/worker"
 )
 
@@ -24,28 +26,65 @@ import (
 
 ***************************/
 
-var (
-	// TODO: 全局整体限流
-	// runLimit
-	expireTaskDuration     = time.Minute * 10
-	expireTaskLockDuration = time.Minute * 12
-)
+// ErrTaskFailed 本次执行失败，周期没有完成，失败的key 在后续执行中重试
+var ErrTaskFailed = errors.New("task cycle execute failure")
 
-type AggregatorFn func(fCtx context.Context) (define.Aggregator, error)
+// AggregatorFn 生成一条聚合链, 每个key 需要单独的实例
+type AggregatorFn func(fCtx context.Context) (*define.AggregatorChain, error)
 
 type task struct {
+	// stats 放在第一个字段，保证32 位系统上原子操作的int64 对齐
+	stats              taskStats
 	event              *event
 	taskLastFinishTime int64
 	// 任务的名字
 	name string
+	// runID 本次执行的id，每次执行生成新的task，日志、执行记录和trace 使用相同的id
+	runID string
+	// 执行策略，已经合并了默认值
+	policy define.MetricTaskPolicy
+	// manual 手动触发执行，周期没有结束的时候也执行
+	manual bool
+	// backfill 回填历史周期，不修改任务周期状态，不使用checkpoint，已经存在的结果重新计算
+	backfill bool
+	// preview 预览，不为nil 的时候结果保存在内存中，不写入output
+	preview *previewCapture
+	// retryKeys 之前执行失败，本次重试的key
+	retryKeys sync.Map
+	// keyErrors key 失败的原因
+	keyErrors sync.Map
+	// hooks 流程不同阶段执行的回调
+	hooks taskHooks
+	// keyHooks 已经开始还没有结束的key，值为key 的span，没有tracer 的时候为nil
+	keyHooks sync.Map
+	// incrementalKeys 增量计算的key，结果写入output 之后保存进度
+	incrementalKeys sync.Map
+	// shardNum 分片的数量，大于1 的时候只计算shard 分片中的key
+	shardNum int
+	shard    int
+	// cycleDedup DedupScopeTypeTask 的时候，周期中全部key 共用的去重实例
+	cycleDedup dedup.Deduper
+	// metrics 监控指标，为nil 的时候不上报
+	metrics *engineMetrics
+	// runType, runStart 本次执行的类型和开始时间
+	runType  define.RunType
+	runStart time.Time
+	// logLevels 任务和插件的日志级别
+	logLevels taskLogLevels
+	// tracer 记录执行过程，为nil 的时候不记录。span 是本次执行的span，cycleSpan 是正在执行周期的span
+	tracer    *trace.Tracer
+	span      *trace.Span
+	cycleSpan *trace.Span
 	// 需要使用到的字段
 	collectFields []string
 	// 需要统计的数据原来插件名字
 	collectPlugin define.Collect
-	// 保存数据需要使用到的插件
-	outputPlugin define.Output
+	// 保存数据需要使用到的插件, 第一个元素是主output
+	outputPlugins []*taskOutput
 	// 对数据进行筛选需要使用到的插件
 	filterPlugin []define.Filter
+	// 对统计结果进行处理的插件，在数据保存前执行
+	metricFilterPlugin []define.MetricFilter
 	// 数据统计使用的插件，一个插件产生一条数据
 	// 聚合差价不能公用，每个metric_key 需要使用单个差价
 	aggregatorPlugin []AggregatorFn
@@ -85,53 +124,157 @@ func (t *task) Run(ctx context.Context) (err error) {
 
 	}()
 	if t.event == nil {
-		event := defaultEvent()
-		t.event = &event
+		t.event = defaultEvent()
+	}
+	t.policy = t.policy.Merge(t.event.taskPolicy)
+	ctx = t.logContext(ctx)
+	ctx.WithTimeout(t.policy.CycleTimeoutDuration())
+	if t.sharding() {
+		return t.runShards(ctx)
 	}
-	ctx.WithTimeout(expireTaskDuration)
-	locked, err := redislock.Lock(ctx, t.redisLockKey(), expireTaskLockDuration)
+	// 节点异常退出后，锁在租约时间后释放
+	fencingToken, locked, err := t.event.lock.Lock(ctx, t.lockKey(), define.FencingKey(t.name), t.policy.LockLeaseDuration())
 	if err != nil {
 		ctx.Log().Errorf("get task locked error. name: %s, err: %s", t.name, err.Error())
+		t.metrics.lockFailure(t.name, "task", err)
 		return err
 	}
 	if !locked {
 		ctx.Log().Debugf("skip, name: %s", t.name)
+		t.metrics.lockFailure(t.name, "task", nil)
+		// 手动执行的时候需要告诉调用方没有执行
+		if t.manual {
+			return ErrTaskRunning
+		}
 		return nil
 	}
 	defer func() {
-		if err := redislock.Unlock(ctx, t.redisLockKey()); err != nil {
+		// 任务超时或者被取消后，ctx 已经不可用，需要使用不会被取消的ctx 释放锁
+		if err := t.event.lock.Unlock(context.Detach(ctx), t.lockKey()); err != nil {
 			ctx.Log().Errorf("release task locked error. name: %s, err: %s", t.name, err.Error())
 		}
 	}()
+	for idx := range t.metricMetadataArr {
+		t.metricMetadataArr[idx].FencingToken = fencingToken
+	}
+
+	t.taskSuccess = true
+	// 超时或者调用方取消的时候关闭，和任务内部出错的取消区分开
+	externalDone := ctx.Done()
+	cancelFn := ctx.Cancel()
+	defer cancelFn()
+	// 设置人去取消方法，取消的时候会将任务执行状态设置未false，不需要更新db中的数据
+	t.ctxCancelFn = t.TaskStatusFailure(cancelFn)
+	// 续约失败的时候取消任务
+	stopLease := t.event.keepLease(ctx, t.name, t.lockKey(), t.policy.LockLeaseDuration(), t.ctxCancelFn)
+	defer stopLease()
 
 	// 判断统计周期，是否可以执行
 	if !t.canExecCycle(ctx) {
 		// 结束周期时间没有到。执行下一个指标
 		return nil
 	}
+	history := t.startRun(ctx, t.execRunType())
+	defer func() {
+		// 需要在记录结果前处理panic
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("panic: %#v", panicErr)
+			debug.PrintStack()
+		}
+		t.afterTask(ctx, err)
+		t.finishRun(ctx, history, err, externalDone)
+	}()
+	if err := t.beforeTask(ctx); err != nil {
+		return err
+	}
 
 	if err := t.ModifyOutputIndexName(ctx); err != nil {
 		return err
 	}
 
-	t.taskSuccess = true
-	// 设置人去取消方法，取消的时候会将任务执行状态设置未false，不需要更新db中的数据
-	t.ctxCancelFn = t.TaskStatusFailure(ctx.Cancel())
-
 	if err := t.iterativeCycle(ctx); err != nil {
 		return err
 	}
 	// 超时或者任务被取消了。不需要更新db周期数据
 	if ctx.Err() != nil {
 		ctx.Log().Errorf("task context error. err: %s", ctx.Err())
+		return ctx.Err()
+	}
+	// 失败的key 不满足完成周期的条件，周期不变，失败的key 在后续执行中重试
+	if !t.canFinalize(ctx) {
+		t.TaskStatusFailure(func() {})()
+	}
+
+	if err := t.taskDone(ctx); err != nil {
 		return err
 	}
+	if !t.taskSuccess {
+		return t.failedError()
+	}
+	return nil
+}
 
-	return t.taskDone(ctx)
+// failedError 周期没有完成的时候返回的错误，调用方根据错误判断执行是否成功
+func (t *task) failedError() error {
+	return fmt.Errorf("%w. failed key count: %d", ErrTaskFailed, t.stats.get(taskStageKeyFailed))
+}
+
+// runCycles 计算metricMetadataArr 中的周期，不判断周期是否可以执行，不修改任务的周期状态。
+// 回填和预览使用，需要的锁由调用方持有
+func (t *task) runCycles(ctx context.Context) (err error) {
+	defer func() {
+		panicErr := recover()
+		if panicErr != nil {
+			err = fmt.Errorf("panic: %#v", panicErr)
+			debug.PrintStack()
+		}
+
+	}()
+	t.policy = t.policy.Merge(t.event.taskPolicy)
+	ctx = t.logContext(ctx)
+	ctx.WithTimeout(t.policy.CycleTimeoutDuration())
+	t.taskSuccess = true
+	externalDone := ctx.Done()
+	cancelFn := ctx.Cancel()
+	defer cancelFn()
+	t.ctxCancelFn = t.TaskStatusFailure(cancelFn)
+	var history *define.RunHistory
+	if t.backfill {
+		history = t.startRun(ctx, t.execRunType())
+	}
+	defer func() {
+		// 需要在记录结果前处理panic
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("panic: %#v", panicErr)
+			debug.PrintStack()
+		}
+		t.afterTask(ctx, err)
+		t.finishRun(ctx, history, err, externalDone)
+	}()
+	if err := t.beforeTask(ctx); err != nil {
+		return err
+	}
+
+	if err := t.iterativeCycle(ctx); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !t.taskSuccess || !t.canFinalize(ctx) {
+		return t.failedError()
+	}
+	return nil
+}
+
+// recompute 重新计算全部key，不使用checkpoint，不跳过output 中已经存在结果的key
+func (t *task) recompute() bool {
+	return t.backfill || t.preview != nil
 }
 
 func (t *task) ModifyOutputIndexName(ctx context.Context) error {
-	if t.outputPlugin == nil {
+	outputPlugin := t.primaryOutput()
+	if outputPlugin == nil {
 		ctx.Log().Errorf("output plugin is nil. name: %s", t.name)
 		return fmt.Errorf("output plugin is nil")
 	}
@@ -139,7 +282,12 @@ func (t *task) ModifyOutputIndexName(ctx context.Context) error {
 		ctx.Log().Errorf("metric metadata is nil. name: %s", t.name)
 		return fmt.Errorf("metric metadata is nil")
 	}
-	outputIndexName, err := t.outputPlugin.IndexName(ctx)
+	// output 中还没有设置周期信息，需要使用主周期
+	if err := t.setOutputsMetricMetadata(ctx, t.metricMetadataArr[0]); err != nil {
+		ctx.Log().Errorf("set output plugin metric metadata error. name: %s, err: %s", t.name, err.Error())
+		return err
+	}
+	outputIndexName, err := outputPlugin.IndexName(ctx)
 	if err != nil {
 		ctx.Log().Errorf("not found metric table name, execute continue. name: %s, err: %s", t.name, err.Error())
 		return err
@@ -157,39 +305,66 @@ func (t *task) ModifyOutputIndexName(ctx context.Context) error {
 
 func (t *task) iterativeCycle(ctx context.Context) error {
 	for _, metricMetadata := range t.metricMetadataArr {
-		OutputPluginChn := make(chan define.MetricData, 100)
-		oi := define.OutputInput{
-			Plugin:         t.outputPlugin,
-			Input:          OutputPluginChn,
-			MetricDataDesc: metricMetadata,
-		}
-
-		if err := t.outputPlugin.SetMetricMetadata(ctx, metricMetadata); err != nil {
-			ctx.Log().Field("metric metadata", metricMetadata).Errorf("set output plugin metric metadata error. err: %s",
-				err.Error())
+		if err := t.execCycle(ctx, metricMetadata); err != nil {
 			return err
 		}
+	}
 
-		ci := define.CollectInput{
-			Plugin:          t.collectPlugin,
-			Fields:          t.collectFields,
-			OutputPluginChn: OutputPluginChn,
-			MetricMetadata:  metricMetadata,
-		}
+	return nil
 
-		// 启动数据收集插件
-		t.execCollect(ctx, ci)
+}
 
-		// 用来接受需要保存的数据
-		// 处理需要保存的数据
-		if err := t.execOutput(ctx, oi); err != nil {
-			return err
-		}
+// execCycle 计算一个周期中全部的key
+func (t *task) execCycle(ctx context.Context, metricMetadata define.MetricMetadata) (err error) {
+	ctx = t.cycleLogContext(ctx, metricMetadata)
+	if err := t.beforeCycle(ctx, metricMetadata); err != nil {
+		t.afterCycle(ctx, metricMetadata, err)
+		return err
+	}
+	t.startCycleSpan(metricMetadata)
+	t.openCycleDedup()
+	defer func() {
+		t.closeCycleDedup(ctx)
+		t.afterUnfinishedKeys(ctx, metricMetadata)
+		t.afterCycle(ctx, metricMetadata, err)
+		t.cycleSpan.End(err)
+	}()
 
+	// 每个key 的聚合结果合并成批次后交给output
+	OutputPluginChn := make(chan define.MetricData, t.policy.BatchSize)
+	outputBatchChn := make(chan []define.MetricData, t.policy.BatchBuffer)
+	oi := define.OutputInput{
+		Input:          outputBatchChn,
+		MetricDataDesc: metricMetadata,
 	}
 
-	return nil
+	if err := t.setOutputsMetricMetadata(ctx, metricMetadata); err != nil {
+		ctx.Log().Field("metric metadata", metricMetadata).Errorf("set output plugin metric metadata error. err: %s",
+			err.Error())
+		return err
+	}
+
+	ci := define.CollectInput{
+		Plugin:          t.collectPlugin,
+		Fields:          t.collectFields,
+		OutputPluginChn: OutputPluginChn,
+		MetricMetadata:  metricMetadata,
+	}
 
+	go pipeMetricData(ctx, OutputPluginChn, outputBatchChn, t.policy.BatchSize, func(full bool) {
+		t.metrics.channelSend(t.name, channelOutput, full)
+	})
+	// 启动数据收集插件
+	t.execCollect(ctx, ci)
+	go func() {
+		// 任务处理完成，execCollect 返回后才可以Wait
+		t.taskDoneSignal.Wait()
+		close(OutputPluginChn)
+	}()
+
+	// 用来接受需要保存的数据
+	// 处理需要保存的数据
+	return t.execOutput(ctx, oi)
 }
 
 func (t *task) taskDone(ctx context.Context) error {
@@ -213,9 +388,16 @@ func (t *task) taskDone(ctx context.Context) error {
 
 		// 周期切换需要计算最新周期的数据
 		if err := t.event.taskHandle.TaskDone(ctx, t.name, begin, lastFinishTime); err != nil {
-			ctx.Log().Errorf("update task cycle time range error.")
-			return nil
+			ctx.Log().Errorf("update task cycle time range error. name: %s, err: %s", t.name, err.Error())
+			return err
+		}
+		if t.canNextCycle {
+			// 完成策略允许失败的key 时，失败的记录不再需要重试
+			t.clearFailedKeys(ctx)
+			t.onCycleAdvance(ctx, metricMetadata, begin)
 		}
+		// 周期已经完成，下一次计算重新开始
+		t.clearCheckpoint(ctx)
 	}
 	return nil
 }
@@ -230,7 +412,7 @@ func (t *task) canExecCycle(ctx context.Context) bool {
 		return true
 	}
 	// 每次都需要执行的任务
-	if metricMetadata.CycleMode == define.CycleModeTypeAlways {
+	if metricMetadata.CycleMode == define.CycleModeTypeAlways || t.manual {
 		return true
 	}
 	if metricMetadata.CycleMode == define.CycleModeTypeInnerDay {
@@ -260,7 +442,7 @@ func (t *task) execCollect(ctx context.Context, input define.CollectInput) {
 				t.TaskStatusFailure(t.ctxCancelFn)
 			}
 		}()
-		workers := worker.NewWaitExecWorker(t.event.collectWorkerNum)
+		workers := worker.NewWaitExecWorker(t.policy.WorkerNum)
 		t.execCollectDataList(ctx, input, workers)
 		if err := workers.Wait(); err != nil {
 			return
@@ -273,51 +455,99 @@ func (t *task) execCollect(ctx context.Context, input define.CollectInput) {
 // execCollectDataList 执行获取需要计算的数据
 func (t *task) execCollectDataList(ctx context.Context, input define.CollectInput, workers worker.Worker) {
 	// 获取需要处理指标key，分组数据
-	keys, err := input.Plugin.Keys(ctx)
+	keysSpan := t.startSpan(t.cycleSpan, "collect.Keys", trace.String("plugin", input.Plugin.Name()))
+	keys, err := input.Plugin.Keys(t.pluginLogContext(ctx, input.Plugin.Name()))
+	keysSpan.SetAttributes(trace.Int("keys", int64(len(keys))))
+	keysSpan.End(err)
 	if err != nil {
 		ctx.Log().Errorf("get input keys error. name: %s, err: %s", input.Plugin.Name(), err.Error())
 		// 取消任务，执行，无法获取数据
 		t.ctxCancelFn()
 		return
 	}
-
-	// TODO: 记录执行开始
+	keys = t.preview.filterKeys(keys)
+	keys = t.shardKeys(keys)
+	t.stats.add(taskStageKeyTotal, int64(len(keys)))
+
+	// 上次执行超时或者异常退出的时候，已经完成的key
+	finished := t.finishedKeys(ctx, input.MetricMetadata)
+	// 之前执行失败的key
+	failed := t.loadFailedKeys(ctx, input.MetricMetadata)
+	// 增量计算的时候，output 中已经存在的结果需要和新的数据一起重新计算
+	incCollect := t.incrementalCollect()
 	for idx, key := range keys {
+		// 任务超时或者被取消，暂停执行，剩下的key 在下一次调度中继续执行
+		if ctx.IsDone() {
+			ctx.Log().Infof("task paused. name: %s, executed key count: %d, total key count: %d", t.name, idx, len(keys))
+			return
+		}
 		t.keyCnt = idx
-		exists, err := t.outputPlugin.Exists(ctx, key)
-		if err != nil {
-			// 错误可以被忽略，最多是重复执行一次计算。
+		if _, ok := finished[key]; ok {
+			ctx.Log().Debugf("skip key. reason: checkpoint finished. key: %s", key)
+			t.stats.incr(taskStageKeySkipped)
+			continue
 		}
-		if exists {
+		if failedKey, ok := failed[key]; ok {
+			if keyRetryExhausted(failedKey) {
+				ctx.Log().Errorf("skip key. reason: retry exhausted. key: %s, attempts: %d, err: %s",
+					key, failedKey.Attempts, failedKey.ErrMsg)
+				t.stats.incr(taskStageKeyFailed)
+				continue
+			}
+			if !canRetryKey(failedKey) {
+				ctx.Log().Debugf("skip key. reason: wait retry. key: %s, attempts: %d, next retry time: %d",
+					key, failedKey.Attempts, failedKey.NextRetryTime)
+				t.stats.incr(taskStageKeyDeferred)
+				continue
+			}
+			t.metrics.retry(t.name, "key")
+		}
+		if !t.recompute() && incCollect == nil && t.outputsExists(ctx, key) {
 			ctx.Log().Debugf("skip key. reason: exists value. key: %s, metric metadata: %#v", key, input.MetricMetadata)
+			t.stats.incr(taskStageKeySkipped)
+			t.metrics.keyExistsSkip(t.name)
+			t.keyDone(ctx, input.MetricMetadata, key)
+			continue
+		}
+		t.stats.incr(taskStageKeyProcessed)
+		if err := t.beforeKey(ctx, input.MetricMetadata, key); err != nil {
+			ctx.Log().Errorf("skip key. reason: hook error. key: %s, err: %s", key, err.Error())
+			t.setKeyError(key, err)
+			t.keyFailure(ctx, input.MetricMetadata, key, func() {})()
 			continue
 		}
 		tmpKey := key
-		tmpCtx := ctx.SubCtx(tmpKey)
+		tmpCtx := t.keyLogContext(ctx.SubCtx(tmpKey), tmpKey)
+		if keyTimeout := t.policy.KeyTimeoutDuration(); keyTimeout > 0 {
+			tmpCtx.WithTimeout(keyTimeout)
+		}
+		aggregatorPlugin, err := t.newKeyAggregators(ctx, input.MetricMetadata)
+		if err != nil {
+			ctx.Log().Errorf("aggregator plugin init error. key: %s, err: %s", key, err.Error())
+			t.ctxCancelFn()
+			return
+		}
+		var incKey *incrementalKey
+		if incCollect != nil {
+			aggregatorPlugin, incKey, err = t.restoreIncremental(ctx, input.MetricMetadata, tmpKey, aggregatorPlugin)
+			if err != nil {
+				ctx.Log().Errorf("aggregator plugin init error. key: %s, err: %s", key, err.Error())
+				t.ctxCancelFn()
+				return
+			}
+		}
 		// 通过chan链接插件， chan 在不同的插件中做in或者out实现。
 		// eg： collect plugin中out 是filter plugin的in
 		//      filter plugin 的out  是aggregator plugin 的in
 		//      aggregator plugin 的out 是 output plugin 的in
 		// 由于output 是整个task 任务公用，所在任务初期生成，
 		// collect, filter,aggregator 使用到in,out都是分组内部key 生成，一个task 执行过程中，需要初始化多个
-		collectChn := make(chan define.Record, 100)
-		filterChn := make(chan define.Record, 100)
-		cancelKeyWorkerFn := t.TaskStatusFailure(tmpCtx.Cancel())
+		// 插件之间按照批次传递数据，减少chan 的开销
+		collectChn := make(chan []define.Record, t.policy.BatchBuffer)
+		filterChn := make(chan []define.Record, t.policy.BatchBuffer)
+		// key 失败的时候只取消这个key，其他key 继续执行
+		cancelKeyWorkerFn := t.keyFailure(ctx, input.MetricMetadata, tmpKey, tmpCtx.Cancel())
 
-		aggregatorPlugin := make([]define.Aggregator, len(t.aggregatorPlugin))
-		for idx, plugin := range t.aggregatorPlugin {
-			pluginInstance, err := plugin(ctx)
-			if err != nil {
-				ctx.Log().Errorf("aggregator plugin init error. key: %s, err: %s", key, err.Error())
-				cancelKeyWorkerFn()
-				continue
-			}
-			if err := pluginInstance.SetMetricMetadata(ctx, input.MetricMetadata); err != nil {
-				cancelKeyWorkerFn()
-				continue
-			}
-			aggregatorPlugin[idx] = pluginInstance
-		}
 		// 每个统计key单独使用一组chan 来完成
 		// 生成 filter, aggregator,output 方法
 
@@ -326,6 +556,7 @@ func (t *task) execCollectDataList(ctx context.Context, input define.CollectInpu
 		t.taskDoneSignal.Add(1)
 		go t.execFilters(tmpCtx, define.FilterInput{
 			Key:               tmpKey,
+			MetricMetadata:    input.MetricMetadata,
 			Input:             collectChn,
 			Output:            filterChn,
 			Plugins:           t.filterPlugin,
@@ -337,6 +568,7 @@ func (t *task) execCollectDataList(ctx context.Context, input define.CollectInpu
 		t.taskDoneSignal.Add(1)
 		go t.execAggregators(tmpCtx, define.AggregatorInput{
 			Key:               tmpKey,
+			MetricMetadata:    input.MetricMetadata,
 			Input:             filterChn,
 			Output:            input.OutputPluginChn,
 			Plugins:           aggregatorPlugin,
@@ -347,23 +579,59 @@ func (t *task) execCollectDataList(ctx context.Context, input define.CollectInpu
 				panicErr := recover()
 				if panicErr != nil {
 					retErr = fmt.Errorf("panic: %#v", panicErr)
+					t.setKeyError(tmpKey, retErr)
 					cancelKeyWorkerFn()
 				}
 				close(collectChn)
 			}()
 
-			fTaskCtx := context.NewContexts(fCtx)
-			retErr = retry.DefaultRetry(func(idx int) (next bool, err error) {
-				if err := input.Plugin.Run(fTaskCtx, tmpKey, input.MetricMetadata.Start, input.MetricMetadata.End, collectChn); err != nil {
-					return true, err
+			fTaskCtx := t.pluginLogContext(context.NewContexts(fCtx), input.Plugin.Name())
+			keySpan := t.keySpan(input.MetricMetadata, tmpKey)
+			emitter := newRecordEmitter(collectChn, t.policy.BatchSize, func(full bool) {
+				t.metrics.channelSend(t.name, channelCollect, full)
+			})
+			retErr = retry.Backoff(fTaskCtx, t.policy.RetryNum, func(idx int) (next bool, err error) {
+				if idx > 0 {
+					t.metrics.retry(t.name, "collect")
+				}
+				span := t.startSpan(keySpan, "collect.Run",
+					trace.String("plugin", input.Plugin.Name()), trace.Int("attempt", int64(idx+1)))
+				defer func() {
+					span.End(err)
+				}()
+				if incKey != nil {
+					var watermark string
+					err := emitter.runRecords(fTaskCtx, func(recordChn chan define.Record) (err error) {
+						watermark, err = incCollect.RunIncremental(fTaskCtx, tmpKey, input.MetricMetadata.Start,
+							input.MetricMetadata.End, incKey.getWatermark(), recordChn)
+						return err
+					})
+					if err != nil {
+						return t.canRetryCollect(emitter), err
+					}
+					incKey.setWatermark(watermark)
+					return false, nil
+				}
+				if batchCollect, ok := input.Plugin.(define.BatchCollect); ok {
+					if err := batchCollect.RunBatch(fTaskCtx, tmpKey, input.MetricMetadata.Start, input.MetricMetadata.End, emitter); err != nil {
+						return t.canRetryCollect(emitter), err
+					}
+					return false, nil
+				}
+				err = emitter.runRecords(fTaskCtx, func(recordChn chan define.Record) error {
+					return input.Plugin.Run(fTaskCtx, tmpKey, input.MetricMetadata.Start, input.MetricMetadata.End, recordChn)
+				})
+				if err != nil {
+					return t.canRetryCollect(emitter), err
 				}
 				return false, nil
-			})
+			}, t.policy.RetryDelayDuration(), t.policy.RetryBackoff, t.policy.RetryMaxDelayDuration())
 			if retErr != nil {
 				fTaskCtx.Log().
 					Fields(log.Field("task name", t.name), log.Field("MetricMetadata", input.MetricMetadata)).
-					Errorf("execute input plugin error. err: %s", err)
+					Errorf("execute input plugin error. err: %s", retErr)
 				// 拉数据收集数据，出现问题，取消tmpKey 统计任务
+				t.setKeyError(tmpKey, fmt.Errorf("collect plugin %s error. %w", input.Plugin.Name(), retErr))
 				cancelKeyWorkerFn()
 				return retErr
 			}
@@ -373,6 +641,12 @@ func (t *task) execCollectDataList(ctx context.Context, input define.CollectInpu
 
 }
 
+// canRetryCollect 收集失败后是否可以重试。不去重的时候，重试会重复统计失败前已经发送的数据，
+// 已经发送过数据的key 不重试，key 失败后在下次执行时重新计算
+func (t *task) canRetryCollect(emitter *recordEmitter) bool {
+	return t.policy.Dedup != define.DedupTypeNone || !emitter.sent
+}
+
 func (t *task) execFilters(ctx context.Context, input define.FilterInput) {
 	// 需要传递过来
 	defer func() {
@@ -380,46 +654,117 @@ func (t *task) execFilters(ctx context.Context, input define.FilterInput) {
 		// 告诉下一个阶段，数据发送结束
 		panicErr := recover()
 		if panicErr != nil {
-			ctx.Log().Panicf("execute filter error. name: %s, err: %#v", panicErr)
+			ctx.Log().Errorf("execute filter error. name: %s, err: %#v", t.name, panicErr)
+			t.setKeyError(input.Key, fmt.Errorf("filter panic: %#v", panicErr))
 			input.CancelKeyWorkerFn()
 		}
 		close(input.Output)
 	}()
-	existUUIDMap := make(map[string]struct{}, 0)
-	defer func() {
-		existUUIDMap = nil
-	}()
+	deduper, closeDedup := t.keyDedup()
+	defer closeDedup(ctx)
+	timers := t.newPluginTimers(t.keySpan(input.MetricMetadata, input.Key), "filter.Run")
+	defer timers.end()
+	pluginCtxs := t.newPluginLogContexts(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			ctx.Log().Infof("cancel plugin filter. context done. err: %v", ctx.Err())
 			return
-		case record, chnIsClose := <-input.Input:
+		case batch, chnIsClose := <-input.Input:
 			// 已经关闭chn，数据读取完了
 			if !chnIsClose {
 				return
 			}
-			// 去重
-			if _, ok := existUUIDMap[record.UUID()]; ok {
-				ctx.Log().Infof("duplicate record. key: %s, data: %#v, uuid: %s", input.Key, record.Data(), record.UUID())
-				continue
+			if !t.execFilterBatch(ctx, input, deduper, pluginCtxs, timers, batch) {
+				return
 			}
-			// 记录数据
-			existUUIDMap[record.UUID()] = struct{}{}
-			if len(input.Plugins) == 0 {
-				input.Output <- record
-			} else {
-				for _, filter := range input.Plugins {
-					if err := filter.Run(ctx, input.Key, record); err != nil {
-						// 出现错误，关闭任务
-						input.CancelKeyWorkerFn()
-						return
-					}
+		}
+	}
+
+}
+
+// execFilterBatch 处理一批数据，filter 的结果按照批次大小发送给aggregator，返回false 表示需要结束key 的计算
+func (t *task) execFilterBatch(ctx context.Context, input define.FilterInput, deduper dedup.Deduper,
+	pluginCtxs *pluginLogContexts, timers *pluginTimers, batch []define.Record) bool {
+	t.stats.add(taskStageCollected, int64(len(batch)))
+	output := make([]define.Record, 0, t.policy.BatchSize)
+	send := func() bool {
+		t.metrics.channelSend(t.name, channelFilter, len(input.Output) == cap(input.Output))
+		select {
+		case <-ctx.Done():
+			ctx.Log().Infof("cancel plugin filter. context done. err: %v", ctx.Err())
+			return false
+		case input.Output <- output:
+			t.stats.add(taskStageFiltered, int64(len(output)))
+			output = make([]define.Record, 0, t.policy.BatchSize)
+			return true
+		}
+	}
+	for _, record := range batch {
+		// 去重
+		seen, err := deduper.Seen(record.UUID())
+		if err != nil {
+			ctx.Log().Errorf("dedup record error. task name: %s, key: %s, uuid: %s, err: %s",
+				t.name, input.Key, record.UUID(), err.Error())
+			t.setKeyError(input.Key, fmt.Errorf("dedup error. %w", err))
+			input.CancelKeyWorkerFn()
+			return false
+		}
+		if seen {
+			t.stats.incr(taskStageDuplicated)
+			ctx.Log().Infof("duplicate record. key: %s, data: %#v, uuid: %s", input.Key, record.Data(), record.UUID())
+			continue
+		}
+		records, err := t.runFilters(pluginCtxs, input.Key, input.Plugins, timers, record)
+		if err != nil {
+			ctx.Log().Errorf("execute filter error. task name: %s, key: %s, data: %#v, err: %s",
+				t.name, input.Key, record.Data(), err.Error())
+			t.onRecordError(ctx, input.MetricMetadata, input.Key, record, err)
+			// 出现错误，取消key 的计算
+			t.setKeyError(input.Key, err)
+			input.CancelKeyWorkerFn()
+			return false
+		}
+		for _, item := range records {
+			output = append(output, item)
+			if len(output) >= t.policy.BatchSize && !send() {
+				return false
+			}
+		}
+	}
+	if len(output) > 0 {
+		return send()
+	}
+	return true
+}
+
+// runFilters 按照顺序串联执行filter 插件，返回需要交给aggregator 的数据
+// 某个filter 返回空数据时，表示数据被丢弃，后续filter 不再执行
+func (t *task) runFilters(pluginCtxs *pluginLogContexts, key string, plugins []define.Filter, timers *pluginTimers,
+	record define.Record) ([]define.Record, error) {
+	records := []define.Record{record}
+	for idx, filter := range plugins {
+		next := make([]define.Record, 0, len(records))
+		for _, item := range records {
+			start := timers.now()
+			results, err := filter.Run(pluginCtxs.get(filter.Name()), key, item)
+			timers.observe(idx, filter.Name(), start, err)
+			if err != nil {
+				return nil, fmt.Errorf("filter plugin %s error. %w", filter.Name(), err)
+			}
+			for _, result := range results {
+				if result != nil {
+					next = append(next, result)
 				}
 			}
 		}
+		records = next
+		if len(records) == 0 {
+			return nil, nil
+		}
 	}
 
+	return records, nil
 }
 
 func (t *task) execAggregators(ctx context.Context, input define.AggregatorInput) {
@@ -429,21 +774,32 @@ func (t *task) execAggregators(ctx context.Context, input define.AggregatorInput
 	defer func() {
 		panicErr := recover()
 		if panicErr != nil {
-			ctx.Log().Panicf("panic: %#v", panicErr)
+			ctx.Log().Errorf("panic: %#v", panicErr)
+			t.setKeyError(input.Key, fmt.Errorf("aggregator panic: %#v", panicErr))
 			input.CancelKeyWorkerFn()
 		}
 		t.taskDoneSignal.Done()
 	}()
+	timers := t.newPluginTimers(t.keySpan(input.MetricMetadata, input.Key), "aggregator.Run")
+	defer timers.end()
+	pluginCtxs := t.newPluginLogContexts(ctx)
 	for {
 
 		select {
 		case <-ctx.Done():
 			ctx.Log().Infof("cancel plugin aggregators. context done. err: %v", ctx.Err())
+			// key 超时的时候ctx 不是通过CancelKeyWorkerFn 取消的，需要记录key 失败，避免没有完成的周期被标记为完成
+			if ctx.Err() == osContent.DeadlineExceeded {
+				t.setKeyError(input.Key, fmt.Errorf("key execute timeout"))
+			}
+			input.CancelKeyWorkerFn()
 			return
-		case record, chnIsClose := <-input.Input:
-			canExit, err := t.execAggregatorRow(ctx, record, chnIsClose, input)
+		case batch, chnIsClose := <-input.Input:
+			canExit, err := t.execAggregatorBatch(ctx, batch, chnIsClose, input, pluginCtxs, timers)
 			if err != nil {
-				ctx.Log().Errorf("execute aggregator error. task name: %s, data: %#v, err: %s", t.name, record.Data(), err.Error())
+				ctx.Log().Errorf("execute aggregator error. task name: %s, key: %s, err: %s",
+					t.name, input.Key, err.Error())
+				t.setKeyError(input.Key, err)
 				input.CancelKeyWorkerFn()
 				return
 			}
@@ -454,7 +810,8 @@ func (t *task) execAggregators(ctx context.Context, input define.AggregatorInput
 	}
 }
 
-func (t *task) execAggregatorRow(ctx context.Context, record define.Record, chnIsClose bool, input define.AggregatorInput) (bool, error) {
+func (t *task) execAggregatorBatch(ctx context.Context, batch []define.Record, chnIsClose bool, input define.AggregatorInput,
+	pluginCtxs *pluginLogContexts, timers *pluginTimers) (bool, error) {
 	// 已经关闭chan，数据读取完了
 	if !chnIsClose {
 		// 任务被取消，不需要保存数据
@@ -467,69 +824,159 @@ func (t *task) execAggregatorRow(ctx context.Context, record define.Record, chnI
 			Extra:     make(map[string]interface{}, 0),
 		}
 
-		for _, aggregator := range input.Plugins {
-
-			metricItemName, metricValue := aggregator.Metric(ctx)
-			ctx.Log().Debugf("aggregator single result. key: %s, plugin name: %s, field: %s, value: %s",
-				input.Key, aggregator.Name(), metricItemName, metricValue)
-
-			outputData.Value[metricItemName] = metricValue
-			extraName, extraValue, exists := aggregator.MetricExtra(ctx)
-			if exists {
-				outputData.Extra[extraName] = extraValue
+		for _, chain := range input.Plugins {
+			if err := t.flushAggregatorChain(pluginCtxs, input.Key, chain, timers); err != nil {
+				return true, err
 			}
-
+			for node := chain; node != nil; node = node.Next {
+				aggregator := node.Plugin
+				pluginCtx := pluginCtxs.get(aggregator.Name())
+				metricItemName, metricValue := aggregator.Metric(pluginCtx)
+				ctx.Log().Debugf("aggregator single result. key: %s, plugin name: %s, field: %s, value: %v",
+					input.Key, aggregator.Name(), metricItemName, metricValue)
+				// 中间层级的aggregator 没有输出名字的时候，只做数据传递，不保存结果
+				if node.Next == nil || metricItemName != "" {
+					outputData.Value[metricItemName] = metricValue
+				}
+				extraName, extraValue, exists := aggregator.MetricExtra(pluginCtx)
+				if exists {
+					outputData.Extra[extraName] = extraValue
+				}
+			}
+		}
+		if err := t.captureIncremental(ctx, input.MetricMetadata, input.Key, input.Plugins); err != nil {
+			return true, err
 		}
 		ctx.Log().Field("output", outputData).Debugf("aggregator result")
 		select {
 		case <-ctx.Done():
 		case input.Output <- outputData:
-
+			t.stats.incr(taskStageAggregated)
 		}
 		return true, nil
 
 	}
 
-	for _, aggregator := range input.Plugins {
-		if err := aggregator.Run(ctx, input.Key, record); err != nil {
-			// 出现错误，关闭任务
-			input.CancelKeyWorkerFn()
-			return false, err
+	for _, record := range batch {
+		for _, chain := range input.Plugins {
+			if err := t.runAggregatorChain(pluginCtxs, input.Key, chain, timers, []define.Record{record}); err != nil {
+				ctx.Log().Debugf("execute aggregator error. key: %s, data: %#v, err: %s", input.Key, record.Data(), err.Error())
+				t.onRecordError(ctx, input.MetricMetadata, input.Key, record, err)
+				// 出现错误，取消key 的计算
+				t.setKeyError(input.Key, err)
+				input.CancelKeyWorkerFn()
+				return false, err
+			}
 		}
-		// TODO: next 未实现
 	}
 
 	return false, nil
 }
 
-func (t *task) execOutput(ctx context.Context, input define.OutputInput) (err error) {
-	go func() {
-		// 任务处理完成
-		t.taskDoneSignal.Wait()
-		close(input.Input)
-	}()
+// newKeyAggregators 为单个key 生成聚合插件实例，聚合插件有状态，不能在key 之间公用
+func (t *task) newKeyAggregators(ctx context.Context, metricMetadata define.MetricMetadata) ([]*define.AggregatorChain, error) {
+	chains := make([]*define.AggregatorChain, 0, len(t.aggregatorPlugin))
+	for _, plugin := range t.aggregatorPlugin {
+		chain, err := plugin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for node := chain; node != nil; node = node.Next {
+			if err := node.Plugin.SetMetricMetadata(ctx, metricMetadata); err != nil {
+				return nil, err
+			}
+		}
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
+
+// runAggregatorChain 执行多级聚合，上一级输出的数据作为下一级的输入
+func (t *task) runAggregatorChain(pluginCtxs *pluginLogContexts, key string, chain *define.AggregatorChain,
+	timers *pluginTimers, records []define.Record) error {
+	for node := chain; node != nil && len(records) > 0; node = node.Next {
+		next := make([]define.Record, 0, len(records))
+		pluginCtx := pluginCtxs.get(node.Plugin.Name())
+		for _, record := range records {
+			start := timers.now()
+			err := node.Plugin.Run(pluginCtx, key, record)
+			timers.observe(node, node.Plugin.Name(), start, err)
+			if err != nil {
+				return fmt.Errorf("aggregator plugin %s error. %w", node.Plugin.Name(), err)
+			}
+			if node.Next == nil {
+				continue
+			}
+			emitter, ok := node.Plugin.(define.AggregatorEmitter)
+			if !ok {
+				next = append(next, record)
+				continue
+			}
+			start = timers.now()
+			emitRecords, err := emitter.Emit(pluginCtx, key, record)
+			timers.observe(node, node.Plugin.Name(), start, err)
+			if err != nil {
+				return fmt.Errorf("aggregator plugin %s emit error. %w", node.Plugin.Name(), err)
+			}
+			next = append(next, emitRecords...)
+		}
+		records = next
+	}
+
+	return nil
+}
+
+// flushAggregatorChain key 的数据处理完成，按照层级顺序把每一级的中间状态交给下一级
+func (t *task) flushAggregatorChain(pluginCtxs *pluginLogContexts, key string, chain *define.AggregatorChain,
+	timers *pluginTimers) error {
+	for node := chain; node != nil && node.Next != nil; node = node.Next {
+		emitter, ok := node.Plugin.(define.AggregatorEmitter)
+		if !ok {
+			continue
+		}
+		start := timers.now()
+		records, err := emitter.Flush(pluginCtxs.get(node.Plugin.Name()), key)
+		timers.observe(node, node.Plugin.Name(), start, err)
+		if err != nil {
+			return fmt.Errorf("aggregator plugin %s flush error. %w", node.Plugin.Name(), err)
+		}
+		if err := t.runAggregatorChain(pluginCtxs, key, node.Next, timers, records); err != nil {
+			return err
+		}
+	}
 
+	return nil
+}
+
+func (t *task) execOutput(ctx context.Context, input define.OutputInput) (err error) {
 	defer func() {
 		panicErr := recover()
 		if panicErr != nil {
 			err = fmt.Errorf("panic: %#v", panicErr)
-			ctx.Log().Panicf("panic: %s", err.Error())
-
+			ctx.Log().Errorf("panic: %s", err.Error())
 		}
 
 	}()
 
+	// writeErr 写入失败的时候任务已经被取消，需要返回写入的错误，而不是ctx 的错误
+	var writeErr error
 	for {
 		select {
 		case <-ctx.Done():
+			if writeErr != nil {
+				return writeErr
+			}
 			return ctx.Err()
-		case metricData, ok := <-input.Input:
+		case batch, ok := <-input.Input:
 			if !ok {
 				// 数据处理完成
-				return nil
+				return writeErr
 			}
-			if err := t.execOutputWrite(ctx, input, metricData); err != nil {
-				return nil
+			for _, metricData := range batch {
+				if writeErr != nil {
+					break
+				}
+				writeErr = t.execOutputWrite(ctx, input, metricData)
 			}
 		}
 
@@ -537,6 +984,7 @@ func (t *task) execOutput(ctx context.Context, input define.OutputInput) (err er
 }
 
 func (t *task) execOutputWrite(ctx context.Context, input define.OutputInput, metricData define.MetricData) (err error) {
+	ctx = t.keyLogContext(ctx, metricData.MetricKey)
 	defer func() {
 		if err != nil {
 			// 执行出错，取消任务
@@ -544,18 +992,58 @@ func (t *task) execOutputWrite(ctx context.Context, input define.OutputInput, me
 		}
 	}()
 
-	if err := input.Plugin.Write(ctx, define.OutputData{
-		MetricData: metricData,
-	}); err != nil {
+	metricData, keep, err := t.runMetricFilters(ctx, t.keySpan(input.MetricDataDesc, metricData.MetricKey), metricData)
+	if err != nil {
 		ctx.Log().Fields(log.Field("data", metricData), log.Field("metric data desc", input.MetricDataDesc)).
-			Errorf("write metric error. name: %s, err: %s", input.Plugin.Name(), err.Error())
+			Errorf("execute metric filter error. task name: %s, err: %s", t.name, err.Error())
+		return err
+	}
+	if !keep {
+		ctx.Log().Debugf("skip write metric. reason: metric filter. key: %s", metricData.MetricKey)
+		t.stats.incr(taskStageDropped)
+		t.keyDone(ctx, input.MetricDataDesc, metricData.MetricKey)
+		return nil
+	}
+	if t.preview != nil {
+		t.preview.write(metricData)
+		t.stats.incr(taskStageOutput)
+		return nil
+	}
+
+	outputData := define.OutputData{
+		MetricData: metricData,
+	}
+	err = t.writeOutputs(ctx, input, outputData)
+	t.onOutputWrite(ctx, input.MetricDataDesc, outputData, err)
+	if err != nil {
 		return err
 	}
+	t.stats.incr(taskStageOutput)
+	t.keyDone(ctx, input.MetricDataDesc, metricData.MetricKey)
 
 	return nil
 
 }
 
+// runMetricFilters 按照顺序串联执行统计结果处理插件，keep 为false 表示数据不需要保存
+func (t *task) runMetricFilters(ctx context.Context, keySpan *trace.Span, metricData define.MetricData) (define.MetricData, bool, error) {
+	for _, filter := range t.metricFilterPlugin {
+		span := t.startSpan(keySpan, "metric_filter.Run", trace.String("plugin", filter.Name()))
+		result, keep, err := filter.Run(t.pluginLogContext(ctx, filter.Name()), metricData)
+		span.SetAttributes(trace.Bool("keep", keep))
+		span.End(err)
+		if err != nil {
+			return metricData, false, fmt.Errorf("metric filter plugin %s error. %w", filter.Name(), err)
+		}
+		if !keep {
+			return result, false, nil
+		}
+		metricData = result
+	}
+
+	return metricData, true, nil
+}
+
 // TaskStatusFailure 取消任务的时候，表示任务中有统计失败，需要重试
 func (t *task) TaskStatusFailure(cancelFunc osContent.CancelFunc) osContent.CancelFunc {
 	return func() {
@@ -567,6 +1055,6 @@ func (t *task) TaskStatusFailure(cancelFunc osContent.CancelFunc) osContent.Canc
 
 }
 
-func (t *task) redisLockKey() string {
+func (t *task) lockKey() string {
 	return define.LockKeyPrefix + t.name
 }
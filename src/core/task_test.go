@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/collects"
+	"github.com/rentiansheng/incenses/src/plugins/filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// panicFilter 处理panicKey 的数据时panic
+type panicFilter struct {
+	panicKey string
+}
+
+func (f *panicFilter) Name() string                                    { return "core_test_panic" }
+func (f *panicFilter) Description() string                             { return "" }
+func (f *panicFilter) SetConfig(ctx context.Context, cfg []byte) error { return nil }
+func (f *panicFilter) Run(ctx context.Context, key string, data define.Record) ([]define.Record, error) {
+	if key == f.panicKey {
+		panic("filter panic")
+	}
+	return []define.Record{data}, nil
+}
+
+var registerPanicFilter sync.Once
+
+func TestFilterPanic(t *testing.T) {
+	registerPanicFilter.Do(func() {
+		filters.Add("core_test_panic", func() define.Filter { return &panicFilter{panicKey: "k2"} })
+	})
+	sink := newTestSink()
+	taskInfo := newTestTask("filter_panic", &testCollect{keys: []string{"k1", "k2", "k3"}, records: 3}, sink)
+	taskInfo.Filters = define.MetricTaskPluginConfigArr{{Name: "core_test_panic"}}
+	e, tasks := newTestEvent(t, taskInfo)
+
+	require.ErrorIs(t, e.runTask(context.Background(), taskInfo, false), ErrTaskFailed, "failed key retried in next run")
+	require.Equal(t, []string{"k1", "k3"}, sink.keys(), "other keys finished")
+	require.Equal(t, float64(3), sink.get("k1").Value["cnt"])
+	require.Equal(t, 0, tasks.doneCount(), "cycle not finalized")
+}
+
+func TestFilters(t *testing.T) {
+	split := define.MetricTaskPluginConfig{Name: "split", Config: define.RAWConfig(`{"field":"key","output_field":"part"}`)}
+	tests := []struct {
+		name     string
+		filters  define.MetricTaskPluginConfigArr
+		filtered int64
+	}{
+		{"pass", nil, 3},
+		{"split", define.MetricTaskPluginConfigArr{split}, 6},
+		{"split drop", define.MetricTaskPluginConfigArr{split,
+			{Name: "drop", Config: define.RAWConfig(`{"rules":[{"field":"part","value":"b","operator":"equal"}]}`)}}, 3},
+		{"keep", define.MetricTaskPluginConfigArr{
+			{Name: "drop", Config: define.RAWConfig(`{"mode":"keep","rules":[{"field":"key","value":"x","operator":"equal"}]}`)}}, 0},
+		{"rename", define.MetricTaskPluginConfigArr{
+			{Name: "rename", Config: define.RAWConfig(`{"labels":{"key":"part"}}`)},
+			{Name: "drop", Config: define.RAWConfig(`{"rules":[{"field":"part","value":"a,b","operator":"equal"}]}`)}}, 0},
+	}
+	taskInfo := newTestTask("filters", &testCollect{keys: []string{"a,b"}, records: 3}, newTestSink())
+	e, _ := newTestEvent(t, taskInfo)
+	for _, tt := range tests {
+		taskInfo.Filters = tt.filters
+		result, err := e.Preview(context.Background(), taskInfo, PreviewOption{})
+		require.NoError(t, err, "preview. name: %s", tt.name)
+		require.Equal(t, int64(3), result.Stats.Collected, "collected. name: %s", tt.name)
+		require.Equal(t, tt.filtered, result.Stats.Filtered, "filtered. name: %s", tt.name)
+		cnt := float64(0)
+		if len(result.Data) != 0 {
+			cnt = result.Data[0].Value["cnt"]
+		}
+		require.Equal(t, float64(tt.filtered), cnt, "count. name: %s", tt.name)
+	}
+}
+
+// userCollect 每条数据是一次用户访问，同一个用户可以访问多次
+type userCollect struct {
+	*testCollect
+	visits [][2]string
+}
+
+func (c *userCollect) Run(ctx context.Context, key string, start, end uint64, input chan define.Record) error {
+	for idx, visit := range c.visits {
+		input <- define.NewRecord(fmt.Sprintf("%s-%d", key, idx),
+			map[string]string{"user": visit[0], "status": visit[1]}, nil)
+	}
+	return nil
+}
+
+func TestAggregatorChain(t *testing.T) {
+	collect := &testCollect{keys: []string{"k1"}}
+	taskInfo := newTestTask("aggregator_chain", collect, newTestSink())
+	collects.Add(taskInfo.Collect.Name, func() define.Collect {
+		return &userCollect{testCollect: collect, visits: [][2]string{
+			{"u1", "ok"}, {"u1", "ok"}, {"u2", "fail"}, {"u3", "ok"}, {"u4", "fail"}, {"u3", "fail"},
+		}}
+	})
+	// 用户去重，统计成功的用户数量，计算成功用户的比率
+	taskInfo.Aggregators = define.MetricTaskPluginAggregatorConfigArr{{
+		Name:   "distinct",
+		Config: define.RAWConfig(`{"field":"user","output_key":"users"}`),
+		Next: &define.MetricTaskPluginAggregatorConfig{
+			Name:   "count",
+			Config: define.RAWConfig(`{"output_key":"ok","rules":[{"field":"status","value":"ok","operator":"equal"}]}`),
+			Next: &define.MetricTaskPluginAggregatorConfig{
+				Name:   "two_field_sum_rate",
+				Config: define.RAWConfig(`{"field":{"molecular":"ok","denominator":"total"},"output_key":"ok_rate"}`),
+			},
+		},
+	}}
+	e, _ := newTestEvent(t, taskInfo)
+	result, err := e.Preview(context.Background(), taskInfo, PreviewOption{})
+	require.NoError(t, err, "preview")
+	require.Len(t, result.Data, 1, "data")
+	require.Equal(t, map[string]float64{"users": 4, "ok": 2, "ok_rate": 0.5}, result.Data[0].Value, "chain result")
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"time"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/trace"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// SetTracer 设置记录执行过程的tracer，为nil 的时候不记录。
+// 每次执行生成一个trace，包含任务，周期，key 和插件调用的span，预览不记录
+func (e *event) SetTracer(tracer *trace.Tracer) {
+	e.tracer = tracer
+}
+
+// startSpan tracer 为nil 的时候返回nil
+func (t *task) startSpan(parent *trace.Span, name string, attrs ...trace.Attribute) *trace.Span {
+	return t.tracer.Start(parent, name, attrs...)
+}
+
+// startTaskSpan 任务执行的span，run_id 和日志、执行记录中的run_id 相同，用来关联日志和执行记录
+func (t *task) startTaskSpan(ctx context.Context, runType define.RunType) {
+	t.span = t.startSpan(nil, "task",
+		trace.String("task", t.name),
+		trace.String("run_type", runTypeName(runType)),
+		trace.String("node", t.event.node),
+		trace.String("run_id", t.runID))
+}
+
+func (t *task) startCycleSpan(metricMetadata define.MetricMetadata) {
+	attrs := []trace.Attribute{
+		trace.Int("cycle_start", int64(metricMetadata.Start)),
+		trace.Int("cycle_end", int64(metricMetadata.End)),
+	}
+	if t.sharding() {
+		attrs = append(attrs, trace.Int("shard", int64(t.shard)))
+	}
+	t.cycleSpan = t.startSpan(t.span, "cycle", attrs...)
+}
+
+// keySpan key 的span，key 开始之后，结束之前可以获取
+func (t *task) keySpan(metricMetadata define.MetricMetadata, key string) *trace.Span {
+	value, _ := t.keyHooks.Load(failedKeyID(metricMetadata, key))
+	span, _ := value.(*trace.Span)
+	return span
+}
+
+// pluginTimers 汇总一个key 中每个插件的调用次数和时间，key 的数据处理完成后每个插件生成一个span。
+// filter 和aggregator 每条数据调用一次，每次调用生成span 数量太多。只在一个协程中使用
+type pluginTimers struct {
+	tracer *trace.Tracer
+	parent *trace.Span
+	name   string
+	timers map[interface{}]*pluginTimer
+	order  []interface{}
+}
+
+type pluginTimer struct {
+	plugin   string
+	start    time.Time
+	calls    int64
+	errors   int64
+	duration time.Duration
+	err      error
+}
+
+// newPluginTimers tracer 为nil 的时候返回nil，不统计时间
+func (t *task) newPluginTimers(parent *trace.Span, name string) *pluginTimers {
+	if t.tracer == nil {
+		return nil
+	}
+	return &pluginTimers{
+		tracer: t.tracer,
+		parent: parent,
+		name:   name,
+		timers: make(map[interface{}]*pluginTimer),
+	}
+}
+
+// now 插件调用开始的时间，不统计的时候不获取时间
+func (p *pluginTimers) now() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// observe id 区分同名的插件
+func (p *pluginTimers) observe(id interface{}, plugin string, start time.Time, err error) {
+	if p == nil {
+		return
+	}
+	timer, ok := p.timers[id]
+	if !ok {
+		timer = &pluginTimer{plugin: plugin, start: start}
+		p.timers[id] = timer
+		p.order = append(p.order, id)
+	}
+	timer.calls++
+	timer.duration += time.Since(start)
+	if err != nil {
+		timer.errors++
+		timer.err = err
+	}
+}
+
+// end 每个插件生成一个span，开始时间是第一次调用的时间
+func (p *pluginTimers) end() {
+	if p == nil {
+		return
+	}
+	for _, id := range p.order {
+		timer := p.timers[id]
+		p.tracer.StartAt(p.parent, p.name, timer.start,
+			trace.String("plugin", timer.plugin),
+			trace.Int("calls", timer.calls),
+			trace.Int("errors", timer.errors),
+			trace.Float("duration_ms", float64(timer.duration)/float64(time.Millisecond)),
+		).End(timer.err)
+	}
+}
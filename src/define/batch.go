@@ -0,0 +1,24 @@
+package define
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// RecordEmitter 批量发送collect 收集的数据
+type RecordEmitter interface {
+	// Emit 发送一批数据，超过任务配置的批次大小时拆分成多个批次。返回后插件可以复用records
+	Emit(ctx context.Context, records []Record) error
+}
+
+// BatchCollect collect 插件可以实现这个接口，通过RecordEmitter 批量发送数据，减少chan 的开销。
+// 实现这个接口后Run 不再被调用，增量计算的时候使用IncrementalCollect
+type BatchCollect interface {
+	RunBatch(ctx context.Context, key string, start, end uint64, emitter RecordEmitter) error
+}
@@ -0,0 +1,35 @@
+package define
+
+import (
+	"fmt"
+
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/18
+    @desc:
+
+***************************/
+
+const (
+	CheckpointKeyPrefix = "metric:task:checkpoint:"
+)
+
+// Checkpoint 记录任务执行过程中已经完成的key，与output 插件无关。
+// 任务超时或者异常退出后，下一次调度跳过已经完成的key，从中断的地方继续执行
+type Checkpoint interface {
+	// Done 记录key 已经完成
+	Done(ctx context.Context, runKey, key string) error
+	// Finished 获取已经完成的key
+	Finished(ctx context.Context, runKey string) (map[string]struct{}, error)
+	// Clear 周期完成后清理记录
+	Clear(ctx context.Context, runKey string) error
+}
+
+// CheckpointRunKey 一次执行的标识。同一个任务，同一个周期，并且上次完成时间相同的执行，可以共用已经完成的key。
+// 任务完成后last_finish_time 会变化，下一次计算重新开始
+func CheckpointRunKey(meta MetricMetadata) string {
+	return fmt.Sprintf("%s:%d:%d:%d", meta.MetricName, meta.Start, meta.End, meta.LastFinishTime)
+}
@@ -39,7 +39,7 @@ func (r *record) Data() map[string]string {
 }
 
 func (r *record) Field() map[string]float64 {
-	return r.Field()
+	return r.field
 }
 
 func (r *record) Update(key, value string) error {
@@ -78,6 +78,9 @@ type MetricMetadata struct {
 	CycleMode  CycleModeType `json:"interval_mode"`
 	// output 插件需要根据这个值，来确定数据是否需要更新
 	LastFinishTime uint64 `json:"last_finish_time"`
+	// FencingToken 获取任务锁时得到的单调递增的值，同一个任务的周期执行，分片和回填使用同一个计数器，
+	// output 插件可以用来拒绝锁过期的任务写入数据
+	FencingToken uint64 `json:"fencing_token"`
 }
 
 // CycleModeType 周期执行方式，1 周期结束后执行，2周期中每天计算一次
@@ -0,0 +1,32 @@
+package define
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+// DedupType collect 输出的记录按照uuid 去重的方式
+type DedupType int8
+
+const (
+	// DedupTypeMemory 内存中保存全部uuid，精确去重
+	DedupTypeMemory DedupType = 1
+	// DedupTypeNone 不去重，collect 发送过数据后失败的时候不重试，避免重复统计
+	DedupTypeNone DedupType = 2
+	// DedupTypeBloom 布隆过滤器，内存占用小，记录可能被误判为重复数据而丢弃
+	DedupTypeBloom DedupType = 3
+	// DedupTypeDisk 内存中的uuid 超过限制后写入磁盘，精确去重
+	DedupTypeDisk DedupType = 4
+)
+
+// DedupScopeType 去重的范围
+type DedupScopeType int8
+
+const (
+	// DedupScopeTypeKey 每个key 单独去重
+	DedupScopeTypeKey DedupScopeType = 1
+	// DedupScopeTypeTask 同一个周期中全部key 一起去重，出现在多个key 中的记录只保留第一次出现的
+	DedupScopeTypeTask DedupScopeType = 2
+)
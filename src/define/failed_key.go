@@ -0,0 +1,55 @@
+package define
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/23
+    @desc:
+
+***************************/
+
+const (
+	FailedKeyPrefix = "metric:task:failed:"
+)
+
+// FailedKeyImpl 记录周期中执行失败的key，后续执行的时候按照退避时间重试，运维人员也可以查看
+type FailedKeyImpl interface {
+	// Fail 保存失败的key，已经存在的时候覆盖
+	Fail(ctx context.Context, key FailedKey) error
+	// Remove key 重试成功后删除
+	Remove(ctx context.Context, taskName string, cycleStart, cycleEnd uint64, key string) error
+	// List 任务所有周期中失败的key
+	List(ctx context.Context, taskName string) ([]FailedKey, error)
+	// Clear 周期完成后删除周期中失败的key
+	Clear(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error
+}
+
+// FailedKey 执行失败的key，时间单位秒
+type FailedKey struct {
+	TaskName   string `json:"task_name"`
+	CycleStart uint64 `json:"cycle_start"`
+	CycleEnd   uint64 `json:"cycle_end"`
+	Key        string `json:"key"`
+	// Attempts 累计失败的次数
+	Attempts int    `json:"attempts"`
+	ErrMsg   string `json:"err_msg"`
+	// LastFailTime 最后一次失败的时间
+	LastFailTime uint64 `json:"last_fail_time"`
+	// NextRetryTime 下一次可以重试的时间，超过最大重试次数的时候为0，不再重试
+	NextRetryTime uint64 `json:"next_retry_time"`
+}
+
+// FinalizePolicyType 周期中有key 失败的时候，是否可以完成周期
+type FinalizePolicyType int8
+
+const (
+	// FinalizePolicyTypeAll 所有key 都成功才完成周期，超过重试次数的key 需要删除失败记录后才会重新计算
+	FinalizePolicyTypeAll FinalizePolicyType = 1
+	// FinalizePolicyTypeThreshold 成功key 的百分比达到阈值完成周期
+	FinalizePolicyTypeThreshold FinalizePolicyType = 2
+	// FinalizePolicyTypeBestEffort 不管key 是否失败都完成周期，周期完成后失败的记录被清理
+	FinalizePolicyTypeBestEffort FinalizePolicyType = 3
+)
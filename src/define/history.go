@@ -0,0 +1,97 @@
+package define
+
+import (
+	"errors"
+
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/22
+    @desc:
+
+***************************/
+
+// RunHistoryImpl 任务执行记录的存储
+type RunHistoryImpl interface {
+	// Start 任务开始执行的时候保存，状态为RunStatusTypeRunning
+	Start(ctx context.Context, history RunHistory) error
+	// Finish 任务结束的时候根据RunID 更新结果
+	Finish(ctx context.Context, history RunHistory) error
+	// Get 根据RunID 获取执行记录，不存在的时候返回ErrRunHistoryNotFound
+	Get(ctx context.Context, runID string) (RunHistory, error)
+	// List 按照开始时间倒序查询执行记录
+	List(ctx context.Context, filter RunHistoryFilter) ([]RunHistory, error)
+}
+
+var (
+	// ErrRunHistoryNotFound 执行记录不存在
+	ErrRunHistoryNotFound = errors.New("run history not found")
+)
+
+type RunStatusType int8
+
+const (
+	// RunStatusTypeRunning 正在执行，节点异常退出的时候会一直保持这个状态
+	RunStatusTypeRunning RunStatusType = 1
+	// RunStatusTypeSuccess 执行成功
+	RunStatusTypeSuccess RunStatusType = 2
+	// RunStatusTypeFailure 执行失败，周期没有完成，下次调度重新执行
+	RunStatusTypeFailure RunStatusType = 3
+	// RunStatusTypeCanceled 超时或者退出的时候被取消，没有完成的key 下次调度继续执行
+	RunStatusTypeCanceled RunStatusType = 4
+)
+
+type RunType int8
+
+const (
+	// RunTypeSchedule 周期调度执行
+	RunTypeSchedule RunType = 1
+	// RunTypeBackfill 回填历史周期
+	RunTypeBackfill RunType = 2
+	// RunTypeManual 手动触发执行，周期没有结束的时候也会执行
+	RunTypeManual RunType = 3
+)
+
+// RunHistory 任务一次执行的记录，时间单位秒
+type RunHistory struct {
+	RunID    string  `json:"run_id" gorm:"column:run_id"`
+	TaskName string  `json:"task_name" gorm:"column:task_name"`
+	RunType  RunType `json:"run_type" gorm:"column:run_type"`
+	// Node 执行任务的节点，hostname:pid
+	Node string `json:"node" gorm:"column:node"`
+	// CycleStart, CycleEnd 本次执行计算的周期范围，包含多个周期的时候是所有周期的范围
+	CycleStart uint64 `json:"cycle_start" gorm:"column:cycle_start"`
+	CycleEnd   uint64 `json:"cycle_end" gorm:"column:cycle_end"`
+	StartTime  uint64 `json:"start_time" gorm:"column:start_time"`
+	EndTime    uint64 `json:"end_time" gorm:"column:end_time"`
+	// KeyTotal collect 插件返回的key 数量
+	KeyTotal int64 `json:"key_total" gorm:"column:key_total"`
+	// KeyProcessed 本次执行计算的key 数量
+	KeyProcessed int64 `json:"key_processed" gorm:"column:key_processed"`
+	// KeySkipped checkpoint 中已经完成或者output 中已经存在结果，跳过的key 数量
+	KeySkipped int64 `json:"key_skipped" gorm:"column:key_skipped"`
+	// KeyFailed 执行失败的key 数量
+	KeyFailed int64 `json:"key_failed" gorm:"column:key_failed"`
+	// RecordCount collect 插件输出的记录数量
+	RecordCount int64 `json:"record_count" gorm:"column:record_count"`
+	// OutputCount 写入output 的统计结果数量
+	OutputCount int64 `json:"output_count" gorm:"column:output_count"`
+	// DedupMemory 单个去重实例占用内存的最大值，单位字节
+	DedupMemory int64         `json:"dedup_memory" gorm:"column:dedup_memory"`
+	Status      RunStatusType `json:"status" gorm:"column:status"`
+	ErrMsg      string        `json:"err_msg" gorm:"column:err_msg"`
+}
+
+// RunHistoryFilter 查询执行记录的条件，零值表示不限制
+type RunHistoryFilter struct {
+	TaskName string        `json:"task_name"`
+	Status   RunStatusType `json:"status"`
+	// StartTime, EndTime 执行开始时间的范围
+	StartTime uint64 `json:"start_time"`
+	EndTime   uint64 `json:"end_time"`
+	Offset    int    `json:"offset"`
+	// Limit 默认100
+	Limit int `json:"limit"`
+}
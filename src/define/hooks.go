@@ -0,0 +1,75 @@
+package define
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/24
+    @desc:
+
+***************************/
+
+// HookInfo 回调执行时任务的信息
+type HookInfo struct {
+	TaskName string
+	RunType  RunType
+	// MetricMetadata 当前周期，任务级别的回调中为空
+	MetricMetadata MetricMetadata
+	// Key 当前计算的key，任务和周期级别的回调中为空
+	Key string
+}
+
+// Hooks 任务流程不同阶段执行的回调，用来预热连接，刷新缓存，记录审计事件，通知下游等。
+// Before 开头的回调返回错误的时候，对应的任务，周期或者key 不再执行，按照失败处理。
+// key 级别和数据相关的回调会被多个key 的协程同时调用，需要保证并发安全。
+// 回调中的panic 会被捕获，当作回调返回错误
+type Hooks interface {
+	// BeforeTask 任务开始执行，周期判断通过之后调用
+	BeforeTask(ctx context.Context, info HookInfo) error
+	// AfterTask 任务执行结束，err 为nil 表示任务执行成功。调用过BeforeTask 的任务都会调用
+	AfterTask(ctx context.Context, info HookInfo, err error)
+	// BeforeCycle 开始计算一个周期
+	BeforeCycle(ctx context.Context, info HookInfo) error
+	// AfterCycle 周期计算结束, 周期中有失败的key 不会体现在err 中。调用过BeforeCycle 的周期都会调用
+	AfterCycle(ctx context.Context, info HookInfo, err error)
+	// BeforeKey 开始计算一个key，跳过计算的key 不会调用
+	BeforeKey(ctx context.Context, info HookInfo) error
+	// AfterKey key 计算结束，err 为nil 表示结果已经写入或者被丢弃。调用过BeforeKey 的key 都会调用
+	AfterKey(ctx context.Context, info HookInfo, err error)
+	// OnRecordError 处理一条数据出现错误，调用后key 按照失败处理
+	OnRecordError(ctx context.Context, info HookInfo, record Record, err error)
+	// OnOutputWrite 统计结果写入output 之后调用，err 为写入的错误
+	OnOutputWrite(ctx context.Context, info HookInfo, data OutputData, err error)
+	// OnCycleAdvance 任务的周期切换到下一个周期，nextStart 是下一个周期的开始时间
+	OnCycleAdvance(ctx context.Context, info HookInfo, nextStart uint64)
+}
+
+// HooksProvider 插件实现这个接口后，使用插件的任务会注册插件返回的回调。
+// 支持collect, filter, metric filter, output 插件
+type HooksProvider interface {
+	// GetHooks 获取注入插件，用来放到流程不同流程执行, 返回nil 表示不需要注册
+	GetHooks() Hooks
+}
+
+// NopHooks Hooks 的空实现，嵌入后只需要实现关心的回调
+type NopHooks struct{}
+
+func (NopHooks) BeforeTask(ctx context.Context, info HookInfo) error { return nil }
+
+func (NopHooks) AfterTask(ctx context.Context, info HookInfo, err error) {}
+
+func (NopHooks) BeforeCycle(ctx context.Context, info HookInfo) error { return nil }
+
+func (NopHooks) AfterCycle(ctx context.Context, info HookInfo, err error) {}
+
+func (NopHooks) BeforeKey(ctx context.Context, info HookInfo) error { return nil }
+
+func (NopHooks) AfterKey(ctx context.Context, info HookInfo, err error) {}
+
+func (NopHooks) OnRecordError(ctx context.Context, info HookInfo, record Record, err error) {}
+
+func (NopHooks) OnOutputWrite(ctx context.Context, info HookInfo, data OutputData, err error) {}
+
+func (NopHooks) OnCycleAdvance(ctx context.Context, info HookInfo, nextStart uint64) {}
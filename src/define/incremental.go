@@ -0,0 +1,60 @@
+package define
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/25
+    @desc:
+
+***************************/
+
+const (
+	IncrementalStateKeyPrefix = "metric:task:incremental:"
+)
+
+// IncrementalCollect collect 插件实现这个接口后，任务开启增量计算时只收集水位之后变化的数据
+type IncrementalCollect interface {
+	// RunIncremental 收集watermark 之后的数据，watermark 为空表示收集周期中全部的数据。
+	// 返回本次收集到的位置，作为下一次执行的watermark，格式由插件决定，比如更新时间，自增id
+	RunIncremental(ctx context.Context, key string, start, end uint64, watermark string, input chan Record) (string, error)
+}
+
+// StatefulAggregator aggregator 实现这个接口后，增量计算时从保存的状态继续聚合。
+// 同时实现AggregatorEmitter 的时候，恢复状态之后Emit 和Flush 只能传递新数据产生的结果，避免下一级重复计算
+type StatefulAggregator interface {
+	// State 当前聚合的状态，在key 的数据全部处理完成后调用
+	State(ctx context.Context) ([]byte, error)
+	// Restore 恢复之前保存的状态，在处理数据之前调用
+	Restore(ctx context.Context, state []byte) error
+}
+
+// AggregatorState 单个aggregator 的状态，Name 用来判断任务的配置是否变化
+type AggregatorState struct {
+	Name  string `json:"name"`
+	State []byte `json:"state"`
+}
+
+// IncrementalState key 在周期中增量计算的进度，结果写入output 之后保存
+type IncrementalState struct {
+	TaskName   string `json:"task_name"`
+	CycleStart uint64 `json:"cycle_start"`
+	CycleEnd   uint64 `json:"cycle_end"`
+	Key        string `json:"key"`
+	Watermark  string `json:"watermark"`
+	// Aggregators 按照聚合链的顺序保存每一级aggregator 的状态
+	Aggregators []AggregatorState `json:"aggregators"`
+	UpdateTime  uint64            `json:"update_time"`
+}
+
+// IncrementalStateImpl 保存增量计算的进度，删除后下一次执行全量计算
+type IncrementalStateImpl interface {
+	// Get 获取key 的进度，exists 为false 表示没有记录
+	Get(ctx context.Context, taskName string, cycleStart, cycleEnd uint64, key string) (state IncrementalState, exists bool, err error)
+	// Save 保存key 的进度
+	Save(ctx context.Context, state IncrementalState) error
+	// Clear 删除周期中全部key 的进度
+	Clear(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error
+}
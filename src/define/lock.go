@@ -2,6 +2,7 @@ package define
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -12,11 +13,55 @@ import (
 
 ***************************/
 
+// Lock 任务执行锁，保证同一个任务在多个节点上互斥执行。
+// 锁的持有者通过ctx 中的log id 区分，同一次执行使用同一个ctx 获取，续约和释放锁。
+// fencing token 的计数器和锁分开，由fencingKey 决定，使用同一个fencingKey 的锁共享一个计数器。
+// 获取锁和递增计数器是原子的，所以对于同一个fencingKey，后获取到锁的持有者一定拿到更大的token，
+// 不同锁的持有者写入同一份数据的时候，output 可以用token 拒绝先获取锁的持有者的写入
 type Lock interface {
-	Lock(ctx context.Context, key string, lockedExpireMinute time.Duration) (bool, error)
+	// Lock 获取锁，获取成功的时候递增fencingKey 的计数器，返回递增后的值作为fencing token
+	Lock(ctx context.Context, key, fencingKey string, lockedExpire time.Duration) (token uint64, locked bool, err error)
+	// Refresh 续约，锁已经过期或者被其他人持有的时候返回false
+	Refresh(ctx context.Context, key string, lockedExpire time.Duration) (bool, error)
+	// Unlock 释放锁，只有锁的持有者可以释放
 	Unlock(ctx context.Context, key string) error
 }
 
+// LockInfo 锁当前的状态
+type LockInfo struct {
+	Key string `json:"key"`
+	// Owner 锁的持有者，和持有者执行日志中的log_id 相同，为空表示没有被持有
+	Owner string `json:"owner"`
+	// FencingToken 最后一次获取锁时得到的fencing token
+	FencingToken uint64 `json:"fencing_token"`
+	// ExpireTime 锁过期的时间，毫秒时间戳，没有被持有的时候为0
+	ExpireTime int64 `json:"expire_time"`
+}
+
+// LockInspector 可选实现，查询锁当前的持有者
+type LockInspector interface {
+	Inspect(ctx context.Context, key string) (LockInfo, error)
+}
+
 const (
 	LockKeyPrefix = "metric:task:lock:"
+	// BackfillLockKeyPrefix 回填任务使用的锁，和周期任务的锁分开，回填不会阻塞周期任务
+	BackfillLockKeyPrefix = "metric:task:backfill:lock:"
+	// ShardLockKeyPrefix 任务分片的锁，节点获取到锁之后计算分片中的key
+	ShardLockKeyPrefix = "metric:task:shard:lock:"
+	// FencingKeyPrefix 任务的fencing token 计数器，周期任务，分片和回填的锁共享，
+	// 同一个任务写入output 的数据使用同一个计数器的token
+	FencingKeyPrefix = "metric:task:fencing:"
+)
+
+// FencingKey 任务的fencing token 计数器的key
+func FencingKey(taskName string) string {
+	return FencingKeyPrefix + taskName
+}
+
+var (
+	// ErrStaleFencingToken 已经有持有更大fencing token 的任务写入了数据，当前任务的锁已经过期
+	ErrStaleFencingToken = errors.New("stale fencing token")
+	// ErrLockUnauthorized 释放不是自己持有的锁
+	ErrLockUnauthorized = errors.New("unauthorized operation")
 )
@@ -17,7 +17,7 @@ type Collect interface {
 	Name() string
 	// Keys 用做计算的维度
 	Keys(ctx context.Context) ([]string, error)
-	// Run 执行
+	// Run 执行，逐条发送数据。需要批量发送数据的插件实现BatchCollect
 	Run(ctx context.Context, key string, start, end uint64, input chan Record) error
 
 	// SetConfig 修改配置
@@ -25,16 +25,35 @@ type Collect interface {
 	// Description 用来描述配置
 	Description() string
 
-	// GetHooks 获取注入插件，用来放到流程不同流程执行
-	//GetHooks() Hooks
+	// 需要在流程不同阶段执行回调的插件，实现HooksProvider
 }
 
 // Filter data transform
+// 多个filter 按照配置的顺序串联执行，上一个filter 返回的数据作为下一个filter 的输入，
+// 最后一个filter 返回的数据交给aggregator
 type Filter interface {
 	// Name 插件的名字，必须全局唯一
 	Name() string
-	// Run 执行
-	Run(ctx context.Context, key string, data Record) error
+	// Run 执行, 通过返回值决定数据如何继续流转
+	//    返回 []Record{data}: 数据透传，或者修改后继续传递
+	//    返回 nil 或空数组: 丢弃数据
+	//    返回多条数据: 数据拆分，每条数据都会交给下一个filter
+	// 同一个插件实例会被任务中多个key 的协程同时调用，需要保证并发安全
+	Run(ctx context.Context, key string, data Record) ([]Record, error)
+	// SetConfig 初始化的时候，用来放入配置
+	SetConfig(ctx context.Context, config []byte) error
+	// Description 用来描述配置
+	Description() string
+}
+
+// MetricFilter aggregator 计算结果的处理，在数据交给output 之前执行
+// 多个插件按照配置的顺序串联执行，上一个插件返回的数据作为下一个插件的输入
+type MetricFilter interface {
+	// Name 插件的名字，必须全局唯一
+	Name() string
+	// Run 执行, 返回处理后的数据，keep 为false 的时候，数据不会交给output保存
+	// 同一个插件实例会被任务中多个key 同时使用，需要保证并发安全
+	Run(ctx context.Context, data MetricData) (result MetricData, keep bool, err error)
 	// SetConfig 初始化的时候，用来放入配置
 	SetConfig(ctx context.Context, config []byte) error
 	// Description 用来描述配置
@@ -57,17 +76,37 @@ type Aggregator interface {
 	SetMetricMetadata(ctx context.Context, data MetricMetadata) error
 }
 
+// AggregatorEmitter 多级聚合的时候，上一级aggregator 可以实现这个接口，决定传递给下一级aggregator 的数据。
+// 没有实现这个接口的aggregator，会把收到的数据原样传递给下一级
+type AggregatorEmitter interface {
+	// Emit 每条数据Run 执行完成后调用，返回需要立即传递给下一级的数据，返回空表示不传递
+	Emit(ctx context.Context, key string, data Record) ([]Record, error)
+	// Flush 当前key 的数据全部处理完成后调用，返回聚合的中间状态，传递给下一级
+	Flush(ctx context.Context, key string) ([]Record, error)
+}
+
+// AggregatorChain 多级聚合，Plugin 输出的数据交给Next 继续聚合
+type AggregatorChain struct {
+	Plugin Aggregator
+	Next   *AggregatorChain
+}
+
 // Output record to storage
 type Output interface {
 	// Name 插件的名字，必须全局唯一
 	Name() string
 	// Write 输出数据到目标
 	Write(ctx context.Context, data OutputData) error
+	// SetConfig 初始化的时候，用来放入配置, 比如: 使用的连接，存放数据的位置，写入方式
+	SetConfig(ctx context.Context, config []byte) error
 	// Exists 插件是否有相同的问题件， 这个时刻，数据还没有计算
+	// 任务配置多个output 的时候，只看失败策略为fail 的output，全部存在key 才会跳过计算，
+	// ignore 的output 不参与判断
 	Exists(ctx context.Context, metricKey string) (bool, error)
 	// Description 用来描述配置
 	Description() string
-	// IndexName string
+	// IndexName 数据存放的位置, 任务配置多个output 的时候，使用主output(第一个)的结果。
+	// 跳过计算由fail 的output 的Exists 决定，和IndexName 的主output 无关
 	IndexName(ctx context.Context) (string, error)
 	SetMetricMetadata(ctx context.Context, data MetricMetadata) error
 }
@@ -80,10 +119,12 @@ type CollectInput struct {
 	MetricMetadata  MetricMetadata
 }
 
+// FilterInput Input, Output 中每个元素是一批数据
 type FilterInput struct {
-	Input             chan Record
-	Output            chan Record
+	Input             chan []Record
+	Output            chan []Record
 	Key               string
+	MetricMetadata    MetricMetadata
 	Plugins           []Filter
 	CancelKeyWorkerFn context.CancelFunc
 }
@@ -94,13 +135,14 @@ type FilterPlugins struct {
 }
 
 // AggregatorInput
-// Plan: 如何支持多级， 上一级Aggregator带入到next aggregator 中
+// Plugins 中每个元素是一条聚合链，上一级Aggregator 输出的数据带入到next aggregator 中
 type AggregatorInput struct {
-	Key string
+	Key            string
+	MetricMetadata MetricMetadata
 
-	Input             chan Record
+	Input             chan []Record
 	Output            chan MetricData
-	Plugins           []Aggregator
+	Plugins           []*AggregatorChain
 	CancelKeyWorkerFn context.CancelFunc
 }
 
@@ -110,8 +152,8 @@ type AggregatorPlugins struct {
 	Config []byte `json:"config"`
 }
 
+// OutputInput Input 中每个元素是一批聚合结果
 type OutputInput struct {
-	Plugin         Output
-	Input          chan MetricData
+	Input          chan []MetricData
 	MetricDataDesc MetricMetadata
 }
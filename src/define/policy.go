@@ -0,0 +1,200 @@
+package define
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/21
+    @desc:
+
+***************************/
+
+// MetricTaskPolicy 任务的执行策略，字段为0 的时候使用引擎的默认值
+type MetricTaskPolicy struct {
+	// WorkerNum 同时收集数据的key 数量
+	WorkerNum int `json:"worker_num"`
+	// KeyTimeout 单个key 收集和计算的超时时间，单位秒，0 不限制。超时后本次任务失败，没有完成的key 下次继续执行
+	KeyTimeout uint32 `json:"key_timeout"`
+	// CycleTimeout 单次执行任务的超时时间，单位秒
+	CycleTimeout uint32 `json:"cycle_timeout"`
+	// RetryNum collect 插件执行失败时，最多执行的次数
+	RetryNum int `json:"retry_num"`
+	// RetryDelay 第一次重试的间隔时间，单位毫秒
+	RetryDelay uint32 `json:"retry_delay"`
+	// RetryBackoff 每次重试后间隔时间增加的倍数，小于等于1 的时候间隔时间不变
+	RetryBackoff float64 `json:"retry_backoff"`
+	// RetryMaxDelay 重试间隔时间的上限，单位毫秒，0 不限制
+	RetryMaxDelay uint32 `json:"retry_max_delay"`
+	// LockLease 任务锁的租约时间，单位秒，任务执行过程中会定期续约
+	LockLease uint32 `json:"lock_lease"`
+	// Finalize 周期中有key 失败的时候，是否可以完成周期
+	Finalize FinalizePolicyType `json:"finalize"`
+	// FinalizeThreshold Finalize 为FinalizePolicyTypeThreshold 的时候，成功key 的最小百分比，0-100
+	FinalizeThreshold float64 `json:"finalize_threshold"`
+	// KeyRetryNum 失败的key 在后续执行中最多重试的次数，超过后不再重试，按照失败的key 由Finalize 判断周期是否完成
+	KeyRetryNum int `json:"key_retry_num"`
+	// KeyRetryDelay 失败的key 第一次重试的间隔时间，单位秒，之后每次失败翻倍
+	KeyRetryDelay uint32 `json:"key_retry_delay"`
+	// KeyRetryMaxDelay 失败的key 重试间隔时间的上限，单位秒
+	KeyRetryMaxDelay uint32 `json:"key_retry_max_delay"`
+	// Incremental 增量计算，只收集上次执行之后变化的数据，aggregator 从保存的状态继续聚合。
+	// 需要collect 插件实现IncrementalCollect，全部aggregator 实现StatefulAggregator，否则全量计算
+	Incremental bool `json:"incremental"`
+	// ShardNum key 按照hash 分成的分片数量，多个节点同时计算不同的分片，小于等于1 的时候不分片
+	ShardNum int `json:"shard_num"`
+	// Dedup 记录去重的方式
+	Dedup DedupType `json:"dedup"`
+	// DedupScope 去重的范围
+	DedupScope DedupScopeType `json:"dedup_scope"`
+	// DedupErrorRate Dedup 为DedupTypeBloom 的时候，允许的误判率
+	DedupErrorRate float64 `json:"dedup_error_rate"`
+	// DedupCapacity Dedup 为DedupTypeBloom 的时候，预计的记录数量，超过后过滤器扩容
+	DedupCapacity uint64 `json:"dedup_capacity"`
+	// DedupMemoryLimit Dedup 为DedupTypeDisk 的时候，内存中最多保存的记录数量，超过后写入磁盘
+	DedupMemoryLimit int `json:"dedup_memory_limit"`
+	// BatchSize collect, filter, aggregator, output 之间每次传递的最大记录数量
+	BatchSize int `json:"batch_size"`
+	// BatchBuffer 插件之间chan 可以缓存的批次数量
+	BatchBuffer int `json:"batch_buffer"`
+	// LogLevel 任务执行过程中的日志级别，debug, info, error，为空的时候使用默认的日志级别
+	LogLevel string `json:"log_level"`
+	// PluginLogLevel 插件的日志级别，key 是插件的名字，没有配置的插件使用LogLevel
+	PluginLogLevel map[string]string `json:"plugin_log_level"`
+}
+
+// Merge 没有配置的字段使用def 中的值
+func (p MetricTaskPolicy) Merge(def MetricTaskPolicy) MetricTaskPolicy {
+	if p.WorkerNum <= 0 {
+		p.WorkerNum = def.WorkerNum
+	}
+	if p.KeyTimeout == 0 {
+		p.KeyTimeout = def.KeyTimeout
+	}
+	if p.CycleTimeout == 0 {
+		p.CycleTimeout = def.CycleTimeout
+	}
+	if p.RetryNum <= 0 {
+		p.RetryNum = def.RetryNum
+	}
+	if p.RetryDelay == 0 {
+		p.RetryDelay = def.RetryDelay
+	}
+	if p.RetryBackoff == 0 {
+		p.RetryBackoff = def.RetryBackoff
+	}
+	if p.RetryMaxDelay == 0 {
+		p.RetryMaxDelay = def.RetryMaxDelay
+	}
+	if p.LockLease == 0 {
+		p.LockLease = def.LockLease
+	}
+	if p.Finalize == 0 {
+		p.Finalize = def.Finalize
+	}
+	if p.FinalizeThreshold == 0 {
+		p.FinalizeThreshold = def.FinalizeThreshold
+	}
+	if p.KeyRetryNum <= 0 {
+		p.KeyRetryNum = def.KeyRetryNum
+	}
+	if p.KeyRetryDelay == 0 {
+		p.KeyRetryDelay = def.KeyRetryDelay
+	}
+	if p.KeyRetryMaxDelay == 0 {
+		p.KeyRetryMaxDelay = def.KeyRetryMaxDelay
+	}
+	if !p.Incremental {
+		p.Incremental = def.Incremental
+	}
+	if p.ShardNum <= 0 {
+		p.ShardNum = def.ShardNum
+	}
+	if p.Dedup == 0 {
+		p.Dedup = def.Dedup
+	}
+	if p.DedupScope == 0 {
+		p.DedupScope = def.DedupScope
+	}
+	if p.DedupErrorRate == 0 {
+		p.DedupErrorRate = def.DedupErrorRate
+	}
+	if p.DedupCapacity == 0 {
+		p.DedupCapacity = def.DedupCapacity
+	}
+	if p.DedupMemoryLimit <= 0 {
+		p.DedupMemoryLimit = def.DedupMemoryLimit
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = def.BatchSize
+	}
+	if p.BatchBuffer <= 0 {
+		p.BatchBuffer = def.BatchBuffer
+	}
+	if p.LogLevel == "" {
+		p.LogLevel = def.LogLevel
+	}
+	if len(p.PluginLogLevel) == 0 {
+		p.PluginLogLevel = def.PluginLogLevel
+	}
+	return p
+}
+
+func (p MetricTaskPolicy) KeyTimeoutDuration() time.Duration {
+	return time.Duration(p.KeyTimeout) * time.Second
+}
+
+func (p MetricTaskPolicy) CycleTimeoutDuration() time.Duration {
+	return time.Duration(p.CycleTimeout) * time.Second
+}
+
+func (p MetricTaskPolicy) RetryDelayDuration() time.Duration {
+	return time.Duration(p.RetryDelay) * time.Millisecond
+}
+
+func (p MetricTaskPolicy) RetryMaxDelayDuration() time.Duration {
+	return time.Duration(p.RetryMaxDelay) * time.Millisecond
+}
+
+func (p MetricTaskPolicy) LockLeaseDuration() time.Duration {
+	return time.Duration(p.LockLease) * time.Second
+}
+
+// KeyRetryInterval 第attempts 次失败后，距离下一次重试的时间
+func (p MetricTaskPolicy) KeyRetryInterval(attempts int) time.Duration {
+	delay := time.Duration(p.KeyRetryDelay) * time.Second
+	maxDelay := time.Duration(p.KeyRetryMaxDelay) * time.Second
+	for idx := 1; idx < attempts && (maxDelay <= 0 || delay < maxDelay); idx++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// Scan scan value into Jsonb, implements sql.Scanner interface
+func (p *MetricTaskPolicy) Scan(value interface{}) error {
+	// 新增加的字段，历史数据可能为null
+	if value == nil {
+		*p = MetricTaskPolicy{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+
+	err := json.Unmarshal(bytes, p)
+	return err
+}
+
+// Value return json value, implement driver.Valuer interface
+func (p MetricTaskPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
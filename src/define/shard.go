@@ -0,0 +1,38 @@
+package define
+
+import (
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/26
+    @desc:
+
+***************************/
+
+const (
+	ShardKeyPrefix = "metric:task:shard:"
+)
+
+// ShardResult 分片计算完成后的结果，全部分片完成后用来判断周期是否可以完成
+type ShardResult struct {
+	Shard       int    `json:"shard"`
+	Node        string `json:"node"`
+	KeyTotal    int64  `json:"key_total"`
+	KeyFailed   int64  `json:"key_failed"`
+	KeyDeferred int64  `json:"key_deferred"`
+	FinishTime  uint64 `json:"finish_time"`
+}
+
+// ShardImpl 记录一次执行中已经完成的分片，runKey 使用CheckpointRunKey 生成
+type ShardImpl interface {
+	// Done 记录分片已经完成
+	Done(ctx context.Context, runKey string, result ShardResult) error
+	// Finished 获取已经完成的分片
+	Finished(ctx context.Context, runKey string) (map[int]ShardResult, error)
+	// Clear 周期完成后清理记录
+	Clear(ctx context.Context, runKey string) error
+	// Reset 周期不能完成的时候删除分片的记录，分片重新计算
+	Reset(ctx context.Context, runKey string, shards []int) error
+}
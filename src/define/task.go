@@ -18,13 +18,31 @@ import (
 
 type MetricTaskImpl interface {
 	Get(ctx context.Context) ([]MetricTask, error)
+	// GetByName 根据名字获取任务，不判断任务状态，任务不存在的时候返回ErrTaskNotFound
+	GetByName(ctx context.Context, name string) (MetricTask, error)
 	TaskDone(ctx context.Context, name string, nextCycleTime, lastFinishTime uint64) error
 	Add(ctx context.Context, info MetricTask, extra map[string]interface{}) error
 	ModifyOutputIndexName(ctx context.Context, taskName, indexName string) error
-	// List(ctx context.Context, page Page)([]MetricTask, error)
-	// ChangeStatus(ctx context.Context, name string, from, to StatusEnumType, user string) error
+	// List 全部的任务，包括暂停的任务，不包括待删除的任务
+	List(ctx context.Context) ([]MetricTask, error)
+	// Update 修改任务的配置，不修改task_status, task_start, last_finish_time 和output_index_name。
+	// 任务不存在的时候返回ErrTaskNotFound
+	Update(ctx context.Context, info MetricTask, user string) error
+	// ChangeStatus 任务状态是from 的时候修改为to，已经是to 的时候不做处理。
+	// 任务不存在的时候返回ErrTaskNotFound，状态既不是from 也不是to 的时候返回ErrTaskStatusChanged
+	ChangeStatus(ctx context.Context, name string, from, to StatusEnumType, user string) error
 }
 
+var (
+	// ErrTaskNotFound 任务不存在
+	ErrTaskNotFound = errors.New("metric task not found")
+	// ErrTaskStatusChanged 修改状态的时候，任务的状态已经被修改
+	ErrTaskStatusChanged = errors.New("metric task status changed")
+)
+
+// maxTaskNameLen 任务名字的最大长度，和任务表中task_name 的长度相同
+const maxTaskNameLen = 128
+
 type MetricTask struct {
 	// 任务的名字，同时也是指标名字
 	TaskName string `json:"task_name"  gorm:"column:task_name"`
@@ -36,13 +54,22 @@ type MetricTask struct {
 	CalculateCycle uint8 `json:"calculate_cycle" gorm:"column:calculate_cycle"`
 	// 任务状态， 1 正常，可以允许， 2. 暂停，不被执行 3. 待删除
 	TaskStatus StatusEnumType `json:"task_status" gorm:"column:task_status"`
+	// 任务权重，执行时占用全局并发的数量，0 按照1 处理
+	Weight uint8 `json:"weight" gorm:"column:weight"`
+	// 任务的执行策略，没有配置的字段使用引擎的默认值
+	Policy MetricTaskPolicy `json:"policy" gorm:"column:policy"`
 
 	// 如何处理start 开始处理任务的时间， 有start+cycle 可以选出结束时间
 	TaskStart   uint64                              `json:"task_start" gorm:"column:task_start"`
 	Collect     MetricTaskPluginCollectConfig       `json:"collect" gorm:"column:collect"`
 	Filters     MetricTaskPluginConfigArr           `json:"filters" gorm:"column:filters"`
 	Aggregators MetricTaskPluginAggregatorConfigArr `json:"aggregators" gorm:"column:aggregators"`
-	Output      MetricTaskPluginOutputConfig        `json:"output" gorm:"column:output"`
+	// aggregator 计算结果交给output 之前，对结果进行处理的插件
+	MetricFilters MetricTaskPluginConfigArr `json:"metric_filters" gorm:"column:metric_filters"`
+	// Output 单个output 的配置，Outputs 为空的时候使用
+	Output MetricTaskPluginOutputConfig `json:"output" gorm:"column:output"`
+	// Outputs 同一份计算结果写入多个output，第一个元素是主output, 不为空的时候忽略Output
+	Outputs MetricTaskPluginOutputConfigArr `json:"outputs" gorm:"column:outputs"`
 	//Power          MetricPower                         `json:"power" gorm:"column:power"`
 	LastFinishTime uint64 `json:"last_finish_time" gorm:"column:last_finish_time"`
 
@@ -67,16 +94,63 @@ func (m MetricTask) Map() map[string]interface{} {
 		"cycle_mode":        m.CycleMode,
 		"calculate_cycle":   m.CalculateCycle,
 		"task_status":       m.TaskStatus,
+		"weight":            m.Weight,
+		"policy":            m.Policy,
 		"task_start":        m.TaskStart,
 		"collect":           m.Collect,
 		"filters":           m.Filters,
 		"aggregators":       m.Aggregators,
+		"metric_filters":    m.MetricFilters,
 		"output":            m.Output,
+		"outputs":           m.Outputs,
 		"last_finish_time":  m.LastFinishTime,
 		"output_index_name": m.OutputIndexName,
 	}
 }
 
+// Validate 检查任务配置的字段，不检查插件是否存在和插件的配置
+func (m MetricTask) Validate() error {
+	if m.TaskName == "" || len(m.TaskName) > maxTaskNameLen {
+		return fmt.Errorf("task_name length must be between 1 and %d", maxTaskNameLen)
+	}
+	if m.TaskCycle < TaskCycleTypeYear || m.TaskCycle > TaskCycleTypeHour {
+		return fmt.Errorf("task_cycle %d is invalid", m.TaskCycle)
+	}
+	switch m.CycleMode {
+	case CycleModeTypeEnd, CycleModeTypeInnerDay, CycleModeTypeAlways:
+	default:
+		return fmt.Errorf("cycle_mode %d is invalid", m.CycleMode)
+	}
+	if m.CalculateCycle == 0 {
+		return errors.New("calculate_cycle must be greater than 0")
+	}
+	switch m.TaskStatus {
+	case StatusEnumTypeNormal, StatusEnumTypePaused, StatusEnumTypeDelete:
+	default:
+		return fmt.Errorf("task_status %d is invalid", m.TaskStatus)
+	}
+	if m.Collect.Name == "" {
+		return errors.New("collect plugin name is empty")
+	}
+	if len(m.Aggregators) == 0 {
+		return errors.New("aggregators is empty")
+	}
+	for _, output := range m.OutputConfigs() {
+		if output.Name == "" {
+			return errors.New("output plugin name is empty")
+		}
+	}
+	return nil
+}
+
+// OutputConfigs 任务使用的全部output 配置，第一个元素是主output
+func (m MetricTask) OutputConfigs() []MetricTaskPluginOutputConfig {
+	if len(m.Outputs) != 0 {
+		return m.Outputs
+	}
+	return []MetricTaskPluginOutputConfig{m.Output}
+}
+
 type StatusEnumType int8
 
 const (
@@ -98,6 +172,11 @@ type MetricTaskPluginConfigArr []MetricTaskPluginConfig
 
 // Scan scan value into Jsonb, implements sql.Scanner interface
 func (m *MetricTaskPluginConfigArr) Scan(value interface{}) error {
+	// 新增加的字段，历史数据可能为null
+	if value == nil {
+		*m = nil
+		return nil
+	}
 	bytes, ok := value.([]byte)
 	if !ok {
 		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
@@ -115,8 +194,8 @@ func (m MetricTaskPluginConfigArr) Value() (driver.Value, error) {
 type MetricTaskPluginAggregatorConfig struct {
 	Name   string    `json:"name" gorm:"column:name"`
 	Config RAWConfig `json:"config" gorm:"column:config"`
-	// 暂未启用
-	Next *MetricTaskPluginConfig `json:"next" gorm:"column:next"`
+	// Next 下一级aggregator, 当前aggregator 输出的数据作为下一级aggregator 的输入，支持多级嵌套
+	Next *MetricTaskPluginAggregatorConfig `json:"next" gorm:"column:next"`
 }
 
 // MetricTaskPluginAggregatorConfigArr gorm 对json类型反序列有问题， 需要在字段的维度实现Scan 和 Value
@@ -162,8 +241,9 @@ func (c MetricTaskPluginCollectConfig) Value() (driver.Value, error) {
 type RAWConfig []byte
 
 func (d RAWConfig) MarshalJSON() ([]byte, error) {
+	// 没有配置的插件，需要输出合法的json
 	if string(d) == "" {
-		return []byte(""), nil
+		return []byte("null"), nil
 
 	}
 	ret := ([]byte)(d)
@@ -176,9 +256,22 @@ func (d *RAWConfig) UnmarshalJSON(data []byte) error {
 }
 
 type MetricTaskPluginOutputConfig struct {
-	Name string `json:"name" gorm:"column:name"`
+	Name   string    `json:"name" gorm:"column:name"`
+	Config RAWConfig `json:"config" gorm:"column:config"`
+	// FailurePolicy 写入失败的时候如何处理，默认任务失败
+	FailurePolicy OutputFailurePolicyType `json:"failure_policy" gorm:"column:failure_policy"`
 }
 
+// OutputFailurePolicyType output 写入失败的处理方式
+type OutputFailurePolicyType int8
+
+const (
+	// OutputFailurePolicyTypeFail 写入失败，当前周期的任务失败，等待下次重新执行
+	OutputFailurePolicyTypeFail OutputFailurePolicyType = 0
+	// OutputFailurePolicyTypeIgnore 写入失败只记录日志，不影响任务的执行结果
+	OutputFailurePolicyTypeIgnore OutputFailurePolicyType = 1
+)
+
 // Scan scan value into Jsonb, implements sql.Scanner interface
 func (m *MetricTaskPluginOutputConfig) Scan(value interface{}) error {
 	bytes, ok := value.([]byte)
@@ -195,6 +288,30 @@ func (m MetricTaskPluginOutputConfig) Value() (driver.Value, error) {
 	return json.Marshal(m)
 }
 
+// MetricTaskPluginOutputConfigArr gorm 对json类型反序列有问题， 需要在字段的维度实现Scan 和 Value
+type MetricTaskPluginOutputConfigArr []MetricTaskPluginOutputConfig
+
+// Scan scan value into Jsonb, implements sql.Scanner interface
+func (m *MetricTaskPluginOutputConfigArr) Scan(value interface{}) error {
+	// 新增加的字段，历史数据可能为null
+	if value == nil {
+		*m = nil
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+
+	err := json.Unmarshal(bytes, m)
+	return err
+}
+
+// Value return json value, implement driver.Valuer interface
+func (m MetricTaskPluginOutputConfigArr) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
 type MetricPower map[string]int
 
 // Scan scan value into Jsonb, implements sql.Scanner interface
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/18
+    @desc:
+
+***************************/
+
+// checkpoint 进程内记录已经完成的key，进程重启后丢失，用于单节点部署和测试
+type checkpoint struct {
+	mutex sync.Mutex
+	runs  map[string]map[string]struct{}
+}
+
+func New() define.Checkpoint {
+	return &checkpoint{
+		runs: make(map[string]map[string]struct{}),
+	}
+}
+
+func (c *checkpoint) Done(ctx context.Context, runKey, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	keys, ok := c.runs[runKey]
+	if !ok {
+		keys = make(map[string]struct{})
+		c.runs[runKey] = keys
+	}
+	keys[key] = struct{}{}
+	return nil
+}
+
+func (c *checkpoint) Finished(ctx context.Context, runKey string) (map[string]struct{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	finished := make(map[string]struct{}, len(c.runs[runKey]))
+	for key := range c.runs[runKey] {
+		finished[key] = struct{}{}
+	}
+	return finished, nil
+}
+
+func (c *checkpoint) Clear(ctx context.Context, runKey string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.runs, runKey)
+	return nil
+}
+
+var _ define.Checkpoint = (*checkpoint)(nil)
@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v9"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/18
+    @desc:
+
+***************************/
+
+const (
+	// DefaultExpire 记录的过期时间，避免没有完成的周期一直占用空间
+	DefaultExpire = time.Hour * 24 * 7
+)
+
+type checkpoint struct {
+	client *redis.Client
+	expire time.Duration
+}
+
+// New 使用redis set 记录已经完成的key，expire 为0 的时候使用DefaultExpire
+func New(client *redis.Client, expire time.Duration) define.Checkpoint {
+	if expire <= 0 {
+		expire = DefaultExpire
+	}
+	return &checkpoint{
+		client: client,
+		expire: expire,
+	}
+}
+
+func (c *checkpoint) Done(ctx context.Context, runKey, key string) error {
+	redisKey := define.CheckpointKeyPrefix + runKey
+	pipe := c.client.TxPipeline()
+	pipe.SAdd(ctx, redisKey, key)
+	pipe.Expire(ctx, redisKey, c.expire)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *checkpoint) Finished(ctx context.Context, runKey string) (map[string]struct{}, error) {
+	keys, err := c.client.SMembers(ctx, define.CheckpointKeyPrefix+runKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	finished := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		finished[key] = struct{}{}
+	}
+	return finished, nil
+}
+
+func (c *checkpoint) Clear(ctx context.Context, runKey string) error {
+	return c.client.Del(ctx, define.CheckpointKeyPrefix+runKey).Err()
+}
+
+var _ define.Checkpoint = (*checkpoint)(nil)
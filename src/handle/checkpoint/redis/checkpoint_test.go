@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/18
+    @desc:
+
+***************************/
+
+func initClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	m, err := miniredis.Run()
+	require.NoError(t, err, "mini redis init error")
+
+	client := redis.NewClient(&redis.Options{
+		Network: "tcp",
+		Addr:    m.Addr(),
+		DB:      0,
+	})
+	require.NoError(t, client.Ping(context.TODO()).Err(), "redis ping error")
+	return m, client
+}
+
+func TestCheckpoint(t *testing.T) {
+	m, client := initClient(t)
+	defer m.Close()
+
+	ctx := context.TODO()
+	c := New(client, time.Minute)
+	runKey := "task:1:2:0"
+
+	finished, err := c.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, 0, len(finished), "empty run")
+
+	require.NoError(t, c.Done(ctx, runKey, "key1"), "done error")
+	require.NoError(t, c.Done(ctx, runKey, "key2"), "done error")
+	require.NoError(t, c.Done(ctx, runKey, "key1"), "done repeat error")
+	require.NoError(t, c.Done(ctx, "other", "key3"), "done other run error")
+
+	finished, err = c.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, map[string]struct{}{"key1": {}, "key2": {}}, finished, "finished keys")
+
+	require.NoError(t, c.Clear(ctx, runKey), "clear error")
+	finished, err = c.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, 0, len(finished), "cleared run")
+
+	m.FastForward(time.Minute * 2)
+	finished, err = c.Finished(ctx, "other")
+	require.NoError(t, err, "finished error")
+	require.Equal(t, 0, len(finished), "expired run")
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/23
+    @desc:
+
+***************************/
+
+type cycleKey struct {
+	taskName   string
+	cycleStart uint64
+	cycleEnd   uint64
+}
+
+// failedKey 进程内记录失败的key，进程重启后丢失，用于单节点部署和测试
+type failedKey struct {
+	mutex sync.Mutex
+	keys  map[cycleKey]map[string]define.FailedKey
+}
+
+func New() define.FailedKeyImpl {
+	return &failedKey{
+		keys: make(map[cycleKey]map[string]define.FailedKey),
+	}
+}
+
+func (f *failedKey) Fail(ctx context.Context, key define.FailedKey) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ck := cycleKey{taskName: key.TaskName, cycleStart: key.CycleStart, cycleEnd: key.CycleEnd}
+	keys, ok := f.keys[ck]
+	if !ok {
+		keys = make(map[string]define.FailedKey)
+		f.keys[ck] = keys
+	}
+	keys[key.Key] = key
+	return nil
+}
+
+func (f *failedKey) Remove(ctx context.Context, taskName string, cycleStart, cycleEnd uint64, key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.keys[cycleKey{taskName: taskName, cycleStart: cycleStart, cycleEnd: cycleEnd}], key)
+	return nil
+}
+
+func (f *failedKey) List(ctx context.Context, taskName string) ([]define.FailedKey, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	results := make([]define.FailedKey, 0)
+	for ck, keys := range f.keys {
+		if ck.taskName != taskName {
+			continue
+		}
+		for _, key := range keys {
+			results = append(results, key)
+		}
+	}
+	return results, nil
+}
+
+func (f *failedKey) Clear(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.keys, cycleKey{taskName: taskName, cycleStart: cycleStart, cycleEnd: cycleEnd})
+	return nil
+}
+
+var _ define.FailedKeyImpl = (*failedKey)(nil)
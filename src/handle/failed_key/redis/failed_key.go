@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/23
+    @desc:
+
+***************************/
+
+const (
+	// DefaultExpire 记录的过期时间，已经完成的周期中没有清理的记录在过期后删除
+	DefaultExpire = time.Hour * 24 * 7
+)
+
+type failedKey struct {
+	client *redis.Client
+	expire time.Duration
+}
+
+// New 每个任务使用一个redis hash 保存失败的key，field 为周期和key，expire 为0 的时候使用DefaultExpire
+func New(client *redis.Client, expire time.Duration) define.FailedKeyImpl {
+	if expire <= 0 {
+		expire = DefaultExpire
+	}
+	return &failedKey{
+		client: client,
+		expire: expire,
+	}
+}
+
+func (f *failedKey) Fail(ctx context.Context, key define.FailedKey) error {
+	value, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	redisKey := define.FailedKeyPrefix + key.TaskName
+	pipe := f.client.TxPipeline()
+	pipe.HSet(ctx, redisKey, cycleField(key.CycleStart, key.CycleEnd)+key.Key, value)
+	pipe.Expire(ctx, redisKey, f.expire)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (f *failedKey) Remove(ctx context.Context, taskName string, cycleStart, cycleEnd uint64, key string) error {
+	return f.client.HDel(ctx, define.FailedKeyPrefix+taskName, cycleField(cycleStart, cycleEnd)+key).Err()
+}
+
+func (f *failedKey) List(ctx context.Context, taskName string) ([]define.FailedKey, error) {
+	values, err := f.client.HGetAll(ctx, define.FailedKeyPrefix+taskName).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]define.FailedKey, 0, len(values))
+	for field, value := range values {
+		key := define.FailedKey{}
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			return nil, fmt.Errorf("unmarshal failed key error. field: %s, err: %w", field, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (f *failedKey) Clear(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error {
+	redisKey := define.FailedKeyPrefix + taskName
+	fields, err := f.client.HKeys(ctx, redisKey).Result()
+	if err != nil {
+		return err
+	}
+	prefix := cycleField(cycleStart, cycleEnd)
+	removeFields := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if strings.HasPrefix(field, prefix) {
+			removeFields = append(removeFields, field)
+		}
+	}
+	if len(removeFields) == 0 {
+		return nil
+	}
+	return f.client.HDel(ctx, redisKey, removeFields...).Err()
+}
+
+func cycleField(cycleStart, cycleEnd uint64) string {
+	return fmt.Sprintf("%d:%d:", cycleStart, cycleEnd)
+}
+
+var _ define.FailedKeyImpl = (*failedKey)(nil)
@@ -0,0 +1,70 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/23
+    @desc:
+
+***************************/
+
+func initClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	m, err := miniredis.Run()
+	require.NoError(t, err, "mini redis init error")
+
+	client := redis.NewClient(&redis.Options{
+		Network: "tcp",
+		Addr:    m.Addr(),
+		DB:      0,
+	})
+	require.NoError(t, client.Ping(context.TODO()).Err(), "redis ping error")
+	return m, client
+}
+
+func TestFailedKey(t *testing.T) {
+	m, client := initClient(t)
+	defer m.Close()
+
+	ctx := context.TODO()
+	f := New(client, time.Minute)
+	keys := []define.FailedKey{
+		{TaskName: "task", CycleStart: 1, CycleEnd: 2, Key: "k1", Attempts: 1, ErrMsg: "err1"},
+		{TaskName: "task", CycleStart: 1, CycleEnd: 2, Key: "k2", Attempts: 1, ErrMsg: "err2"},
+		{TaskName: "task", CycleStart: 3, CycleEnd: 4, Key: "k1", Attempts: 2, ErrMsg: "err3"},
+		{TaskName: "other", CycleStart: 1, CycleEnd: 2, Key: "k1", Attempts: 1, ErrMsg: "err4"},
+	}
+	for _, key := range keys {
+		require.NoError(t, f.Fail(ctx, key), "save failed key")
+	}
+	result, err := f.List(ctx, "task")
+	require.NoError(t, err, "list failed key")
+	require.Equal(t, 3, len(result), "failed key count")
+	require.Equal(t, time.Minute, m.TTL(define.FailedKeyPrefix+"task"), "failed key expire")
+
+	keys[0].Attempts = 2
+	require.NoError(t, f.Fail(ctx, keys[0]), "update failed key")
+	require.NoError(t, f.Remove(ctx, "task", 1, 2, "k2"), "remove failed key")
+	result, err = f.List(ctx, "task")
+	require.NoError(t, err, "list failed key")
+	require.ElementsMatch(t, []define.FailedKey{keys[0], keys[2]}, result, "failed key after remove")
+
+	require.NoError(t, f.Clear(ctx, "task", 3, 4), "clear cycle")
+	result, err = f.List(ctx, "task")
+	require.NoError(t, err, "list failed key")
+	require.Equal(t, []define.FailedKey{keys[0]}, result, "failed key after clear")
+
+	result, err = f.List(ctx, "other")
+	require.NoError(t, err, "list other task failed key")
+	require.Equal(t, 1, len(result), "other task failed key count")
+}
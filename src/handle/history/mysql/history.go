@@ -0,0 +1,92 @@
+package mysql
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/22
+    @desc:
+
+***************************/
+
+const defaultLimit = 100
+
+type mysql struct {
+	tableName string
+	db        *gorm.DB
+}
+
+func New(db *gorm.DB, tableName string) *mysql {
+	return &mysql{
+		tableName: tableName,
+		db:        db,
+	}
+}
+
+func (m mysql) Start(ctx context.Context, history define.RunHistory) error {
+	return m.db.WithContext(ctx).Table(m.tableName).Create(&history).Error
+}
+
+func (m mysql) Finish(ctx context.Context, history define.RunHistory) error {
+	doc := map[string]interface{}{
+		"end_time":      history.EndTime,
+		"key_total":     history.KeyTotal,
+		"key_processed": history.KeyProcessed,
+		"key_skipped":   history.KeySkipped,
+		"key_failed":    history.KeyFailed,
+		"record_count":  history.RecordCount,
+		"output_count":  history.OutputCount,
+		"dedup_memory":  history.DedupMemory,
+		"status":        history.Status,
+		"err_msg":       history.ErrMsg,
+	}
+	return m.db.WithContext(ctx).Table(m.tableName).Where("run_id = ?", history.RunID).Updates(doc).Error
+}
+
+func (m mysql) Get(ctx context.Context, runID string) (define.RunHistory, error) {
+	histories := make([]define.RunHistory, 0, 1)
+	if err := m.db.WithContext(ctx).Table(m.tableName).Where("run_id = ?", runID).Limit(1).Find(&histories).Error; err != nil {
+		return define.RunHistory{}, err
+	}
+	if len(histories) == 0 {
+		return define.RunHistory{}, define.ErrRunHistoryNotFound
+	}
+	return histories[0], nil
+}
+
+func (m mysql) List(ctx context.Context, filter define.RunHistoryFilter) ([]define.RunHistory, error) {
+	query := m.db.WithContext(ctx).Table(m.tableName)
+	if filter.TaskName != "" {
+		query = query.Where("task_name = ?", filter.TaskName)
+	}
+	if filter.Status != 0 {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.StartTime != 0 {
+		query = query.Where("start_time >= ?", filter.StartTime)
+	}
+	if filter.EndTime != 0 {
+		query = query.Where("start_time <= ?", filter.EndTime)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	histories := make([]define.RunHistory, 0)
+	if err := query.Order("start_time DESC").Order("id DESC").Offset(filter.Offset).Limit(limit).Find(&histories).Error; err != nil {
+		return nil, err
+	}
+	return histories, nil
+}
+
+func (m mysql) InitTable(ctx context.Context) error {
+	return m.db.Exec(CreateTableSQL(m.tableName)).Error
+}
+
+var _ define.RunHistoryImpl = (*mysql)(nil)
@@ -0,0 +1,38 @@
+package mysql
+
+import "fmt"
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/22
+    @desc:
+
+***************************/
+
+const sqlSchema = "CREATE TABLE if not exists `%s` (" +
+	"`id` bigint(20) unsigned NOT NULL AUTO_INCREMENT," +
+	"`run_id` varchar(64) COLLATE utf8mb4_unicode_ci NOT NULL COMMENT '执行id'," +
+	"`task_name` varchar(128) COLLATE utf8mb4_unicode_ci NOT NULL COMMENT '任务的名字'," +
+	"`run_type` tinyint(8) NOT NULL COMMENT '1 周期调度，2 回填，3 手动触发'," +
+	"`node` varchar(255) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '' COMMENT '执行任务的节点'," +
+	"`cycle_start` int(10) unsigned NOT NULL COMMENT '计算周期开始时间'," +
+	"`cycle_end` int(10) unsigned NOT NULL COMMENT '计算周期结束时间'," +
+	"`start_time` int(10) unsigned NOT NULL COMMENT '执行开始时间'," +
+	"`end_time` int(10) unsigned NOT NULL DEFAULT 0 COMMENT '执行结束时间'," +
+	"`key_total` bigint(20) NOT NULL DEFAULT 0," +
+	"`key_processed` bigint(20) NOT NULL DEFAULT 0," +
+	"`key_skipped` bigint(20) NOT NULL DEFAULT 0," +
+	"`key_failed` bigint(20) NOT NULL DEFAULT 0," +
+	"`record_count` bigint(20) NOT NULL DEFAULT 0 COMMENT 'collect 输出的记录数量'," +
+	"`output_count` bigint(20) NOT NULL DEFAULT 0 COMMENT '写入output 的结果数量'," +
+	"`dedup_memory` bigint(20) NOT NULL DEFAULT 0 COMMENT '单个去重实例占用内存的最大值，单位字节'," +
+	"`status` tinyint(8) NOT NULL COMMENT '1 执行中，2 成功，3 失败，4 取消'," +
+	"`err_msg` text COLLATE utf8mb4_unicode_ci," +
+	"PRIMARY KEY (`id`)," +
+	"UNIQUE KEY `uniq_run_id` (`run_id`)," +
+	"KEY `idx_task_name_start_time` (`task_name`, `start_time`)" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci"
+
+func CreateTableSQL(tb string) string {
+	return fmt.Sprintf(sqlSchema, tb)
+}
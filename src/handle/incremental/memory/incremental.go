@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/25
+    @desc:
+
+***************************/
+
+type cycleKey struct {
+	taskName   string
+	cycleStart uint64
+	cycleEnd   uint64
+}
+
+// incremental 进程内保存增量计算的进度，进程重启后丢失，下一次执行全量计算。用于单节点部署和测试
+type incremental struct {
+	mutex  sync.Mutex
+	states map[cycleKey]map[string]define.IncrementalState
+}
+
+func New() define.IncrementalStateImpl {
+	return &incremental{
+		states: make(map[cycleKey]map[string]define.IncrementalState),
+	}
+}
+
+func (i *incremental) Get(ctx context.Context, taskName string, cycleStart, cycleEnd uint64, key string) (define.IncrementalState, bool, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	state, ok := i.states[cycleKey{taskName: taskName, cycleStart: cycleStart, cycleEnd: cycleEnd}][key]
+	return state, ok, nil
+}
+
+func (i *incremental) Save(ctx context.Context, state define.IncrementalState) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	ck := cycleKey{taskName: state.TaskName, cycleStart: state.CycleStart, cycleEnd: state.CycleEnd}
+	states, ok := i.states[ck]
+	if !ok {
+		states = make(map[string]define.IncrementalState)
+		i.states[ck] = states
+	}
+	states[state.Key] = state
+	return nil
+}
+
+func (i *incremental) Clear(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.states, cycleKey{taskName: taskName, cycleStart: cycleStart, cycleEnd: cycleEnd})
+	return nil
+}
+
+var _ define.IncrementalStateImpl = (*incremental)(nil)
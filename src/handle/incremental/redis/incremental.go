@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/25
+    @desc:
+
+***************************/
+
+const (
+	// DefaultExpire 周期结束之后记录保留的时间，没有清理的记录在过期后删除
+	DefaultExpire = time.Hour * 24 * 7
+)
+
+type incremental struct {
+	client *redis.Client
+	expire time.Duration
+}
+
+// New 每个任务的每个周期使用一个redis hash 保存key 的进度，记录在周期结束expire 之后过期，
+// expire 为0 的时候使用DefaultExpire
+func New(client *redis.Client, expire time.Duration) define.IncrementalStateImpl {
+	if expire <= 0 {
+		expire = DefaultExpire
+	}
+	return &incremental{
+		client: client,
+		expire: expire,
+	}
+}
+
+func (i *incremental) Get(ctx context.Context, taskName string, cycleStart, cycleEnd uint64, key string) (define.IncrementalState, bool, error) {
+	state := define.IncrementalState{}
+	value, err := i.client.HGet(ctx, redisKey(taskName, cycleStart, cycleEnd), key).Bytes()
+	if err == redis.Nil {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if err := json.Unmarshal(value, &state); err != nil {
+		return state, false, fmt.Errorf("unmarshal incremental state error. key: %s, err: %w", key, err)
+	}
+	return state, true, nil
+}
+
+func (i *incremental) Save(ctx context.Context, state define.IncrementalState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	key := redisKey(state.TaskName, state.CycleStart, state.CycleEnd)
+	pipe := i.client.TxPipeline()
+	pipe.HSet(ctx, key, state.Key, value)
+	pipe.ExpireAt(ctx, key, time.Unix(int64(state.CycleEnd), 0).Add(i.expire))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (i *incremental) Clear(ctx context.Context, taskName string, cycleStart, cycleEnd uint64) error {
+	return i.client.Del(ctx, redisKey(taskName, cycleStart, cycleEnd)).Err()
+}
+
+func redisKey(taskName string, cycleStart, cycleEnd uint64) string {
+	return fmt.Sprintf("%s%s:%d:%d", define.IncrementalStateKeyPrefix, taskName, cycleStart, cycleEnd)
+}
+
+var _ define.IncrementalStateImpl = (*incremental)(nil)
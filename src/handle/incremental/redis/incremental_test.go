@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/25
+    @desc:
+
+***************************/
+
+func initClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	m, err := miniredis.Run()
+	require.NoError(t, err, "mini redis init error")
+
+	client := redis.NewClient(&redis.Options{
+		Network: "tcp",
+		Addr:    m.Addr(),
+		DB:      0,
+	})
+	require.NoError(t, client.Ping(context.TODO()).Err(), "redis ping error")
+	return m, client
+}
+
+func TestIncremental(t *testing.T) {
+	m, client := initClient(t)
+	defer m.Close()
+
+	ctx := context.TODO()
+	i := New(client, time.Hour)
+	cycleEnd := uint64(time.Now().Unix()) + 60
+	state := define.IncrementalState{
+		TaskName:   "task",
+		CycleStart: 1,
+		CycleEnd:   cycleEnd,
+		Key:        "k1",
+		Watermark:  "100",
+		Aggregators: []define.AggregatorState{
+			{Name: "count", State: []byte(`{"value":1}`)},
+		},
+		UpdateTime: 10,
+	}
+	_, exists, err := i.Get(ctx, "task", 1, cycleEnd, "k1")
+	require.NoError(t, err, "get not exists state")
+	require.False(t, exists, "state not exists")
+
+	require.NoError(t, i.Save(ctx, state), "save state")
+	result, exists, err := i.Get(ctx, "task", 1, cycleEnd, "k1")
+	require.NoError(t, err, "get state")
+	require.True(t, exists, "state exists")
+	require.Equal(t, state, result, "state")
+	ttl := m.TTL(redisKey("task", 1, cycleEnd))
+	require.True(t, ttl > time.Hour && ttl <= time.Hour+time.Minute, "state expire after cycle end")
+
+	require.NoError(t, i.Clear(ctx, "task", 1, cycleEnd), "clear state")
+	_, exists, err = i.Get(ctx, "task", 1, cycleEnd, "k1")
+	require.NoError(t, err, "get cleared state")
+	require.False(t, exists, "state cleared")
+}
@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mContext "github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/20
+    @desc:
+
+***************************/
+
+type lockItem struct {
+	owner    string
+	expireAt time.Time
+	token    uint64
+}
+
+// lock 进程内的任务锁，只能保证单个节点上任务互斥，用于单节点部署和测试
+type lock struct {
+	mutex sync.Mutex
+	items map[string]*lockItem
+	// tokens fencingKey 对应的计数器，在锁释放后保留，保证单调递增
+	tokens map[string]uint64
+}
+
+func New() define.Lock {
+	return &lock{
+		items:  make(map[string]*lockItem),
+		tokens: make(map[string]uint64),
+	}
+}
+
+func (l *lock) Lock(ctx context.Context, key, fencingKey string, lockedExpire time.Duration) (uint64, bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	item, ok := l.items[key]
+	if !ok {
+		item = &lockItem{}
+		l.items[key] = item
+	}
+	if item.owner != "" && item.expireAt.After(now) {
+		return 0, false, nil
+	}
+	l.tokens[fencingKey]++
+	item.token = l.tokens[fencingKey]
+	item.owner = ownerID(ctx)
+	item.expireAt = now.Add(lockedExpire)
+	return item.token, true, nil
+}
+
+func (l *lock) Refresh(ctx context.Context, key string, lockedExpire time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	item, ok := l.items[key]
+	if !ok || item.owner != ownerID(ctx) || !item.expireAt.After(now) {
+		return false, nil
+	}
+	item.expireAt = now.Add(lockedExpire)
+	return true, nil
+}
+
+func (l *lock) Unlock(ctx context.Context, key string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	item, ok := l.items[key]
+	if !ok || item.owner != ownerID(ctx) || !item.expireAt.After(time.Now()) {
+		return define.ErrLockUnauthorized
+	}
+	item.owner = ""
+	return nil
+}
+
+func (l *lock) Inspect(ctx context.Context, key string) (define.LockInfo, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	info := define.LockInfo{Key: key}
+	item, ok := l.items[key]
+	if !ok {
+		return info, nil
+	}
+	info.FencingToken = item.token
+	if item.owner != "" && item.expireAt.After(time.Now()) {
+		info.Owner = item.owner
+		info.ExpireTime = item.expireAt.UnixNano() / int64(time.Millisecond)
+	}
+	return info, nil
+}
+
+// ownerID 锁的持有者，与redis 实现保持一致使用log id, 没有log id 的时候使用空字符串以外的固定值
+func ownerID(ctx context.Context) string {
+	rid := mContext.CtxLogID(ctx)
+	if rid == "" {
+		return "-"
+	}
+	return rid
+}
+
+var _ define.Lock = (*lock)(nil)
+var _ define.LockInspector = (*lock)(nil)
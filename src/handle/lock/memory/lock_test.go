@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	mContext "github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/20
+    @desc:
+
+***************************/
+
+func TestLock(t *testing.T) {
+	l := New()
+	key := "metric:test:key"
+	fencingKey := define.FencingKey("test")
+	owner := mContext.Background()
+	other := mContext.Background()
+
+	token, locked, err := l.Lock(owner, key, fencingKey, time.Minute)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "first lock")
+	require.Equal(t, uint64(1), token, "first token")
+
+	_, locked, err = l.Lock(other, key, fencingKey, time.Minute)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, false, locked, "lock held by other")
+
+	ok, err := l.Refresh(other, key, time.Minute)
+	require.NoError(t, err, "refresh error")
+	require.Equal(t, false, ok, "refresh by other")
+
+	ok, err = l.Refresh(owner, key, time.Minute)
+	require.NoError(t, err, "refresh error")
+	require.Equal(t, true, ok, "refresh by owner")
+
+	require.Equal(t, define.ErrLockUnauthorized, l.Unlock(other, key), "unlock by other")
+	require.NoError(t, l.Unlock(owner, key), "unlock by owner")
+
+	token, locked, err = l.Lock(other, key, fencingKey, time.Millisecond*10)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "lock after unlock")
+	require.Equal(t, uint64(2), token, "token increase")
+
+	time.Sleep(time.Millisecond * 20)
+	token, locked, err = l.Lock(context.TODO(), key, fencingKey, time.Minute)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "lock after expire")
+	require.Equal(t, uint64(3), token, "token increase after expire")
+}
+
+func TestInspect(t *testing.T) {
+	l := New().(define.LockInspector)
+	key := "metric:test:inspect"
+	owner := mContext.Background()
+
+	info, err := l.Inspect(owner, key)
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, define.LockInfo{Key: key}, info, "not locked")
+
+	_, _, err = l.(define.Lock).Lock(owner, key, define.FencingKey("test"), time.Minute)
+	require.NoError(t, err, "lock error")
+	info, err = l.Inspect(context.TODO(), key)
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, mContext.CtxLogID(owner), info.Owner, "owner")
+	require.Equal(t, uint64(1), info.FencingToken, "token")
+	require.True(t, info.ExpireTime > time.Now().UnixNano()/int64(time.Millisecond), "expire time")
+
+	require.NoError(t, l.(define.Lock).Unlock(owner, key), "unlock")
+	info, err = l.Inspect(context.TODO(), key)
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, define.LockInfo{Key: key, FencingToken: 1}, info, "unlocked")
+}
+
+func TestSharedFencingKey(t *testing.T) {
+	l := New()
+	fencingKey := define.FencingKey("shared")
+	ctx := mContext.Background()
+
+	token, locked, err := l.Lock(ctx, "metric:test:shared:1", fencingKey, time.Minute)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "first lock")
+	require.Equal(t, uint64(1), token, "first token")
+
+	token, locked, err = l.Lock(ctx, "metric:test:shared:2", fencingKey, time.Minute)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "other lock key")
+	require.Equal(t, uint64(2), token, "token shared by lock keys")
+
+	token, locked, err = l.Lock(ctx, "metric:test:shared:3", define.FencingKey("other"), time.Minute)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "other fencing key")
+	require.Equal(t, uint64(1), token, "token of other fencing key")
+
+	info, err := l.(define.LockInspector).Inspect(ctx, "metric:test:shared:2")
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, uint64(2), info.FencingToken, "token of lock key")
+}
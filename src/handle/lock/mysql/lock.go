@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	mContext "github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/20
+    @desc:
+
+***************************/
+
+const (
+	// nowMillisecond 使用数据库的时间，避免多个节点之间时间不一致
+	nowMillisecond = "ROUND(UNIX_TIMESTAMP(NOW(3)) * 1000)"
+)
+
+// lock 使用数据库中的行实现的租约锁，可以和任务表使用同一个数据库。
+// 没有使用GET_LOCK 是因为GET_LOCK 和连接绑定，连接池中的连接无法保证获取和释放使用同一个连接，并且无法提供fencing token
+type lock struct {
+	tableName string
+	db        *gorm.DB
+}
+
+func New(db *gorm.DB, tableName string) *lock {
+	return &lock{
+		tableName: tableName,
+		db:        db,
+	}
+}
+
+// Lock fencingKey 的计数器保存在同一个表中lock_key 为fencingKey 的行，和获取锁在同一个事务中递增
+func (l *lock) Lock(ctx context.Context, key, fencingKey string, lockedExpire time.Duration) (uint64, bool, error) {
+	db := l.db.WithContext(ctx)
+	// 保证锁和计数器对应的行存在
+	err := db.Exec("INSERT IGNORE INTO `"+l.tableName+"` (lock_key, owner, fencing_token, expire_time, mtime) VALUES (?, '', 0, 0, ?), (?, '', 0, 0, ?)",
+		key, time.Now().Unix(), fencingKey, time.Now().Unix()).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	owner := ownerID(ctx)
+	var token uint64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("UPDATE `"+l.tableName+"` SET owner = ?, "+
+			"expire_time = "+nowMillisecond+" + ?, mtime = ? WHERE lock_key = ? AND (owner = '' OR expire_time < "+nowMillisecond+")",
+			owner, lockedExpire.Milliseconds(), time.Now().Unix(), key)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		// 计数器的行被当前事务锁定，读取到的是递增后的值
+		err := tx.Exec("UPDATE `"+l.tableName+"` SET fencing_token = fencing_token + 1, mtime = ? WHERE lock_key = ?",
+			time.Now().Unix(), fencingKey).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Table(l.tableName).Select("fencing_token").Where("lock_key = ?", fencingKey).Row().Scan(&token)
+		if err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE `"+l.tableName+"` SET fencing_token = ? WHERE lock_key = ?", token, key).Error
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return token, token > 0, nil
+}
+
+func (l *lock) Refresh(ctx context.Context, key string, lockedExpire time.Duration) (bool, error) {
+	result := l.db.WithContext(ctx).Exec("UPDATE `"+l.tableName+"` SET expire_time = "+nowMillisecond+" + ?, mtime = ? "+
+		"WHERE lock_key = ? AND owner = ? AND expire_time >= "+nowMillisecond,
+		lockedExpire.Milliseconds(), time.Now().Unix(), key, ownerID(ctx))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (l *lock) Unlock(ctx context.Context, key string) error {
+	result := l.db.WithContext(ctx).Exec("UPDATE `"+l.tableName+"` SET owner = '', expire_time = 0, mtime = ? "+
+		"WHERE lock_key = ? AND owner = ?", time.Now().Unix(), key, ownerID(ctx))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return define.ErrLockUnauthorized
+	}
+	return nil
+}
+
+// Inspect 使用数据库的时间判断锁是否过期
+func (l *lock) Inspect(ctx context.Context, key string) (define.LockInfo, error) {
+	info := define.LockInfo{Key: key}
+	var held bool
+	err := l.db.WithContext(ctx).Raw("SELECT owner, fencing_token, expire_time, expire_time >= "+nowMillisecond+
+		" FROM `"+l.tableName+"` WHERE lock_key = ?", key).Row().
+		Scan(&info.Owner, &info.FencingToken, &info.ExpireTime, &held)
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	if info.Owner == "" || !held {
+		info.Owner, info.ExpireTime = "", 0
+	}
+	return info, nil
+}
+
+func (l *lock) InitTable(ctx context.Context) error {
+	return l.db.Exec(CreateTableSQL(l.tableName)).Error
+}
+
+// ownerID 锁的持有者，与redis 实现保持一致使用log id，空字符串表示没有持有者，需要替换
+func ownerID(ctx context.Context) string {
+	rid := mContext.CtxLogID(ctx)
+	if rid == "" {
+		return "-"
+	}
+	return rid
+}
+
+var _ define.Lock = (*lock)(nil)
+var _ define.LockInspector = (*lock)(nil)
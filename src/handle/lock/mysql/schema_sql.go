@@ -0,0 +1,23 @@
+package mysql
+
+import "fmt"
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/20
+    @desc:
+
+***************************/
+
+const sqlSchema = "CREATE TABLE if not exists `%s` (" +
+	"`lock_key` varchar(191) COLLATE utf8mb4_unicode_ci NOT NULL COMMENT '锁的名字'," +
+	"`owner` varchar(191) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '' COMMENT '锁的持有者，为空表示没有被持有'," +
+	"`fencing_token` bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '锁最后一次获取时的fencing token，fencing key 的行保存计数器'," +
+	"`expire_time` bigint(20) NOT NULL DEFAULT 0 COMMENT '过期时间，数据库时间，单位毫秒'," +
+	"`mtime` int(10) unsigned NOT NULL," +
+	"PRIMARY KEY (`lock_key`)" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci"
+
+func CreateTableSQL(tb string) string {
+	return fmt.Sprintf(sqlSchema, tb)
+}
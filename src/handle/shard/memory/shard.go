@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/26
+    @desc:
+
+***************************/
+
+// shard 进程内记录已经完成的分片，只能在单个进程中使用，用于测试
+type shard struct {
+	mutex   sync.Mutex
+	results map[string]map[int]define.ShardResult
+}
+
+func New() define.ShardImpl {
+	return &shard{
+		results: make(map[string]map[int]define.ShardResult),
+	}
+}
+
+func (s *shard) Done(ctx context.Context, runKey string, result define.ShardResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	results, ok := s.results[runKey]
+	if !ok {
+		results = make(map[int]define.ShardResult)
+		s.results[runKey] = results
+	}
+	results[result.Shard] = result
+	return nil
+}
+
+func (s *shard) Finished(ctx context.Context, runKey string) (map[int]define.ShardResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	finished := make(map[int]define.ShardResult, len(s.results[runKey]))
+	for idx, result := range s.results[runKey] {
+		finished[idx] = result
+	}
+	return finished, nil
+}
+
+func (s *shard) Clear(ctx context.Context, runKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.results, runKey)
+	return nil
+}
+
+func (s *shard) Reset(ctx context.Context, runKey string, shards []int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, idx := range shards {
+		delete(s.results[runKey], idx)
+	}
+	return nil
+}
+
+var _ define.ShardImpl = (*shard)(nil)
@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/26
+    @desc:
+
+***************************/
+
+const (
+	// DefaultExpire 记录的过期时间，避免没有完成的周期一直占用空间
+	DefaultExpire = time.Hour * 24 * 7
+)
+
+type shard struct {
+	client *redis.Client
+	expire time.Duration
+}
+
+// New 使用redis hash 记录已经完成的分片，field 为分片编号，expire 为0 的时候使用DefaultExpire
+func New(client *redis.Client, expire time.Duration) define.ShardImpl {
+	if expire <= 0 {
+		expire = DefaultExpire
+	}
+	return &shard{
+		client: client,
+		expire: expire,
+	}
+}
+
+func (s *shard) Done(ctx context.Context, runKey string, result define.ShardResult) error {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	redisKey := define.ShardKeyPrefix + runKey
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, redisKey, strconv.Itoa(result.Shard), value)
+	pipe.Expire(ctx, redisKey, s.expire)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *shard) Finished(ctx context.Context, runKey string) (map[int]define.ShardResult, error) {
+	values, err := s.client.HGetAll(ctx, define.ShardKeyPrefix+runKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	finished := make(map[int]define.ShardResult, len(values))
+	for field, value := range values {
+		result := define.ShardResult{}
+		if err := json.Unmarshal([]byte(value), &result); err != nil {
+			return nil, fmt.Errorf("unmarshal shard result error. field: %s, err: %w", field, err)
+		}
+		finished[result.Shard] = result
+	}
+	return finished, nil
+}
+
+func (s *shard) Clear(ctx context.Context, runKey string) error {
+	return s.client.Del(ctx, define.ShardKeyPrefix+runKey).Err()
+}
+
+func (s *shard) Reset(ctx context.Context, runKey string, shards []int) error {
+	if len(shards) == 0 {
+		return nil
+	}
+	fields := make([]string, 0, len(shards))
+	for _, idx := range shards {
+		fields = append(fields, strconv.Itoa(idx))
+	}
+	return s.client.HDel(ctx, define.ShardKeyPrefix+runKey, fields...).Err()
+}
+
+var _ define.ShardImpl = (*shard)(nil)
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/26
+    @desc:
+
+***************************/
+
+func initClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	m, err := miniredis.Run()
+	require.NoError(t, err, "mini redis init error")
+
+	client := redis.NewClient(&redis.Options{
+		Network: "tcp",
+		Addr:    m.Addr(),
+		DB:      0,
+	})
+	require.NoError(t, client.Ping(context.TODO()).Err(), "redis ping error")
+	return m, client
+}
+
+func TestShard(t *testing.T) {
+	m, client := initClient(t)
+	defer m.Close()
+
+	ctx := context.TODO()
+	s := New(client, time.Minute)
+	runKey := "task:1:2:0"
+
+	finished, err := s.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, 0, len(finished), "empty run")
+
+	results := []define.ShardResult{
+		{Shard: 0, Node: "n1", KeyTotal: 10, KeyFailed: 1},
+		{Shard: 2, Node: "n2", KeyTotal: 8, KeyDeferred: 2},
+	}
+	for _, result := range results {
+		require.NoError(t, s.Done(ctx, runKey, result), "done error")
+	}
+	require.NoError(t, s.Done(ctx, "other", define.ShardResult{Shard: 1}), "done other run error")
+
+	finished, err = s.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, map[int]define.ShardResult{0: results[0], 2: results[1]}, finished, "finished shards")
+
+	require.NoError(t, s.Reset(ctx, runKey, []int{0}), "reset error")
+	finished, err = s.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, map[int]define.ShardResult{2: results[1]}, finished, "reset shard")
+
+	require.NoError(t, s.Clear(ctx, runKey), "clear error")
+	finished, err = s.Finished(ctx, runKey)
+	require.NoError(t, err, "finished error")
+	require.Equal(t, 0, len(finished), "cleared run")
+
+	m.FastForward(time.Minute * 2)
+	finished, err = s.Finished(ctx, "other")
+	require.NoError(t, err, "finished error")
+	require.Equal(t, 0, len(finished), "expired run")
+}
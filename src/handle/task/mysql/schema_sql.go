@@ -9,6 +9,14 @@ import "fmt"
 
 ***************************/
 
+// 建表之后新增的字段，建表和给已经存在的表增加字段共用
+const (
+	weightColumn        = "`weight` tinyint(8) unsigned NOT NULL DEFAULT 1 COMMENT '任务权重，执行时占用全局并发的数量'"
+	policyColumn        = "`policy` json DEFAULT NULL COMMENT '执行策略 {worker_num, key_timeout, cycle_timeout, retry_num, retry_delay, retry_backoff, retry_max_delay, lock_lease, finalize, finalize_threshold, key_retry_num, key_retry_delay, key_retry_max_delay, incremental, shard_num, dedup, dedup_scope, dedup_error_rate, dedup_capacity, dedup_memory_limit, batch_size, batch_buffer, log_level, plugin_log_level}'"
+	metricFiltersColumn = "`metric_filters` json DEFAULT NULL COMMENT '[]{Name string,Config []byte}'"
+	outputsColumn       = "`outputs` json DEFAULT NULL COMMENT '[]{Name string, FailurePolicy int}'"
+)
+
 const sqlSchema = "CREATE TABLE if not exists `%s` (" +
 	"`id` int(11) NOT NULL AUTO_INCREMENT," +
 	"`task_name` varchar(128) COLLATE utf8mb4_unicode_ci NOT NULL COMMENT '任务的名字，同时也是指标名字'," +
@@ -18,10 +26,14 @@ const sqlSchema = "CREATE TABLE if not exists `%s` (" +
 	"`output_index_name` varchar(128) COLLATE utf8mb4_unicode_ci NOT NULL COMMENT 'task calculate result storage index name'," +
 	"`task_start` int(11) NOT NULL COMMENT '开始处理任务的时间， 有start+cycle 可以选出结束时间'," +
 	"`task_status` tinyint(8) NOT NULL COMMENT '任务状态， 1 正常，可以允许， 2. 暂停，不被执行 3. 待删除 100.local task正在本地开发调试的任务'," +
+	weightColumn + "," +
+	policyColumn + "," +
 	"`collect` json NOT NULL COMMENT '{Name string, Config []byte}'," +
 	"`filters` json NOT NULL COMMENT '[]{Name string, Config []byte}'," +
 	"`aggregators` json NOT NULL COMMENT '[]{Name string,Config []byte}'," +
+	metricFiltersColumn + "," +
 	"`output` json NOT NULL COMMENT 'type{ Name string}'," +
+	outputsColumn + "," +
 	"`last_finish_time` int(10) unsigned DEFAULT NULL COMMENT 'Last execute finish time. Validate task is already executed in day.'," +
 	"`power` varchar(512) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '{}'," +
 	"`modifier` varchar(64) COLLATE utf8mb4_unicode_ci NOT NULL," +
@@ -32,6 +44,27 @@ const sqlSchema = "CREATE TABLE if not exists `%s` (" +
 	"UNIQUE KEY `uniq_Name` (`task_name`)" +
 	") ENGINE=InnoDB AUTO_INCREMENT=28 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci"
 
+// column 建表之后新增的字段
+type column struct {
+	name       string
+	definition string
+	// after 新增字段的位置
+	after string
+}
+
+// addedColumns 按照新增的顺序排列，InitTable 的时候给已经存在的表补充没有的字段
+var addedColumns = []column{
+	{name: "metric_filters", definition: metricFiltersColumn, after: "aggregators"},
+	{name: "outputs", definition: outputsColumn, after: "output"},
+	{name: "weight", definition: weightColumn, after: "task_status"},
+	{name: "policy", definition: policyColumn, after: "weight"},
+}
+
 func CreateTableSQL(tb string) string {
 	return fmt.Sprintf(sqlSchema, tb)
 }
+
+// AddColumnSQL 给已经存在的表增加字段
+func AddColumnSQL(tb string, c column) string {
+	return fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN %s AFTER `%s`", tb, c.definition, c.after)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -85,6 +86,18 @@ func (m mysql) Get(ctx context.Context) ([]define.MetricTask, error) {
 	return results, nil
 }
 
+func (m mysql) GetByName(ctx context.Context, name string) (define.MetricTask, error) {
+	tasks := make([]dbTask, 0, 1)
+	if err := m.db.Where("task_name = ?", name).Table(m.tableName).Limit(1).Find(&tasks).Error; err != nil {
+		return define.MetricTask{}, err
+	}
+	if len(tasks) == 0 {
+		return define.MetricTask{}, define.ErrTaskNotFound
+	}
+
+	return tasks[0].MetricTask, nil
+}
+
 func (m mysql) TaskDone(ctx context.Context, name string, nextCycleTime, lastFinishTime uint64) error {
 	doc := map[string]interface{}{
 		"task_start":       nextCycleTime,
@@ -97,8 +110,89 @@ func (m mysql) TaskDone(ctx context.Context, name string, nextCycleTime, lastFin
 	return nil
 }
 
+// List 不包括待删除的任务，按照创建的顺序排序
+func (m mysql) List(ctx context.Context) ([]define.MetricTask, error) {
+	tasks := make([]dbTask, 0)
+	if err := m.db.Where("task_status <> ?", define.StatusEnumTypeDelete).Table(m.tableName).Order("id").
+		Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	results := make([]define.MetricTask, len(tasks))
+	for idx, task := range tasks {
+		results[idx] = task.MetricTask
+	}
+
+	return results, nil
+}
+
+// updateIgnoreFields Update 不修改的字段，任务状态通过ChangeStatus 修改，周期状态由任务执行的时候修改
+var updateIgnoreFields = []string{"task_name", "task_status", "task_start", "last_finish_time", "output_index_name"}
+
+func (m mysql) Update(ctx context.Context, info define.MetricTask, user string) error {
+	doc := info.Map()
+	for _, field := range updateIgnoreFields {
+		delete(doc, field)
+	}
+	doc["modifier"] = user
+	doc["mtime"] = time.Now().Unix()
+	result := m.db.Where("task_name = ?", info.TaskName).Table(m.tableName).Updates(doc)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 没有修改任何字段的时候影响的行数也是0
+		_, err := m.GetByName(ctx, info.TaskName)
+		return err
+	}
+
+	return nil
+}
+
+func (m mysql) ChangeStatus(ctx context.Context, name string, from, to define.StatusEnumType, user string) error {
+	doc := map[string]interface{}{
+		"task_status": to,
+		"modifier":    user,
+		"mtime":       time.Now().Unix(),
+	}
+	result := m.db.Where("task_name = ? AND task_status = ?", name, from).Table(m.tableName).Updates(doc)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	task, err := m.GetByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if task.TaskStatus != to {
+		return define.ErrTaskStatusChanged
+	}
+
+	return nil
+}
+
+// InitTable 创建任务表，表已经存在的时候补充新版本增加的字段，可以重复执行
 func (m mysql) InitTable(ctx context.Context) error {
-	return m.db.Exec(CreateTableSQL(m.tableName)).Error
+	db := m.db.WithContext(ctx)
+	if err := db.Exec(CreateTableSQL(m.tableName)).Error; err != nil {
+		return err
+	}
+	for _, c := range addedColumns {
+		var cnt int64
+		err := db.Raw("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+			m.tableName, c.name).Row().Scan(&cnt)
+		if err != nil {
+			return err
+		}
+		if cnt > 0 {
+			continue
+		}
+		if err := db.Exec(AddColumnSQL(m.tableName, c)).Error; err != nil {
+			return fmt.Errorf("add column %s error. %w", c.name, err)
+		}
+	}
+	return nil
 }
 
 var _ define.MetricTaskImpl = (*mysql)(nil)
@@ -156,6 +156,100 @@ func TestMysqlTaskDone(t *testing.T) {
 	}
 }
 
+func TestMysqlList(t *testing.T) {
+	m, deferFn, err := initMysql(t)
+	require.NoError(t, err, "mock mysql error")
+	defer deferFn()
+
+	for idx, status := range []uint8{1, 2, 3, 1} {
+		err := m.db.Table(m.tableName).Create(buildTaskInfo(idx, status)).Error
+		require.NoError(t, err, "create task. index: %d", idx)
+	}
+	tasks, err := m.List(context.Background())
+	require.NoError(t, err, "list task")
+	names := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		names = append(names, task.TaskName)
+	}
+	require.Equal(t, []string{"name-0", "name-1", "name-3"}, names, "without deleted task")
+}
+
+func TestMysqlUpdate(t *testing.T) {
+	m, deferFn, err := initMysql(t)
+	require.NoError(t, err, "mock mysql error")
+	defer deferFn()
+
+	row := buildTaskInfo(1, 2)
+	err = m.db.Table(m.tableName).Create(row).Error
+	require.NoError(t, err, "create task error")
+
+	info := row.MetricTask
+	info.Weight = 3
+	info.Policy.WorkerNum = 5
+	info.TaskStatus = define.StatusEnumTypeNormal
+	info.TaskStart = 1
+	require.NoError(t, m.Update(context.Background(), info, "admin"), "update task")
+	require.NoError(t, m.Update(context.Background(), info, "admin"), "update without change")
+
+	findRow := dbTask{}
+	err = m.db.Table(m.tableName).Find(&findRow, "task_name = ?", row.TaskName).Error
+	require.NoError(t, err, "find task")
+	require.Equal(t, uint8(3), findRow.Weight, "weight")
+	require.Equal(t, 5, findRow.Policy.WorkerNum, "policy")
+	require.Equal(t, "admin", findRow.Modifier, "modifier")
+	require.Equal(t, define.StatusEnumTypePaused, findRow.TaskStatus, "status not changed")
+	require.Equal(t, row.TaskStart, findRow.TaskStart, "task start not changed")
+
+	info.TaskName = "not-found"
+	require.ErrorIs(t, m.Update(context.Background(), info, "admin"), define.ErrTaskNotFound, "not found")
+}
+
+func TestMysqlChangeStatus(t *testing.T) {
+	m, deferFn, err := initMysql(t)
+	require.NoError(t, err, "mock mysql error")
+	defer deferFn()
+
+	row := buildTaskInfo(1, 1)
+	err = m.db.Table(m.tableName).Create(row).Error
+	require.NoError(t, err, "create task error")
+
+	ctx := context.Background()
+	require.NoError(t, m.ChangeStatus(ctx, row.TaskName, define.StatusEnumTypeNormal, define.StatusEnumTypePaused, "admin"))
+	task, err := m.GetByName(ctx, row.TaskName)
+	require.NoError(t, err, "get task")
+	require.Equal(t, define.StatusEnumTypePaused, task.TaskStatus, "paused")
+	require.NoError(t, m.ChangeStatus(ctx, row.TaskName, define.StatusEnumTypeNormal, define.StatusEnumTypePaused, "admin"),
+		"already paused")
+	require.ErrorIs(t, m.ChangeStatus(ctx, row.TaskName, define.StatusEnumTypeDelete, define.StatusEnumTypeNormal, "admin"),
+		define.ErrTaskStatusChanged, "status changed")
+	require.ErrorIs(t, m.ChangeStatus(ctx, "not-found", define.StatusEnumTypeNormal, define.StatusEnumTypePaused, "admin"),
+		define.ErrTaskNotFound, "not found")
+}
+
+func TestMysqlInitTableAddColumns(t *testing.T) {
+	m, deferFn, err := initMysql(t)
+	require.NoError(t, err, "mock mysql error")
+	defer deferFn()
+
+	// 模拟新增字段之前创建的表
+	for _, c := range addedColumns {
+		err = m.db.Exec("ALTER TABLE `" + m.tableName + "` DROP COLUMN `" + c.name + "`").Error
+		require.NoError(t, err, "drop column %s", c.name)
+	}
+	require.NoError(t, m.InitTable(context.Background()), "add columns")
+	require.NoError(t, m.InitTable(context.Background()), "columns exist")
+
+	row := buildTaskInfo(1, 1)
+	row.Weight = 2
+	row.Policy.ShardNum = 4
+	err = m.db.Table(m.tableName).Create(row).Error
+	require.NoError(t, err, "create task error")
+	task, err := m.GetByName(context.Background(), row.TaskName)
+	require.NoError(t, err, "get task")
+	require.Equal(t, uint8(2), task.Weight, "weight")
+	require.Equal(t, 4, task.Policy.ShardNum, "policy")
+}
+
 func buildTaskInfo(idx int, status uint8) dbTask {
 	ts := uint64(time.Now().Unix())
 	name := fmt.Sprintf("name-%d", idx)
@@ -0,0 +1,140 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+const (
+	// DefaultBloomCapacity 第一个过滤器可以保存的记录数量
+	DefaultBloomCapacity = 1000000
+	// DefaultBloomErrorRate 默认的误判率
+	DefaultBloomErrorRate = 0.001
+
+	// bloomGrowth 过滤器满了之后，下一个过滤器容量增加的倍数
+	bloomGrowth = 2
+	// bloomTightening 下一个过滤器误判率缩小的比例，保证全部过滤器总的误判率不超过配置的值
+	bloomTightening = 0.5
+)
+
+// bloomFilter 固定容量的布隆过滤器
+type bloomFilter struct {
+	bits     []uint64
+	m        uint64
+	k        uint64
+	capacity uint64
+	count    uint64
+}
+
+func newBloomFilter(capacity uint64, errorRate float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+	for idx := uint64(0); idx < f.k; idx++ {
+		pos := (h1 + idx*h2) % f.m
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for idx := uint64(0); idx < f.k; idx++ {
+		pos := (h1 + idx*h2) % f.m
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+	f.count++
+}
+
+// bloom 可扩容的布隆过滤器，内存占用固定，记录可能被误判为重复数据而丢弃，不会漏掉重复的数据。
+// 记录数量超过容量后增加新的过滤器，全部过滤器总的误判率不超过errorRate
+type bloom struct {
+	mutex     sync.Mutex
+	filters   []*bloomFilter
+	errorRate float64
+	closed    bool
+}
+
+// NewBloom capacity 为0 的时候使用DefaultBloomCapacity，errorRate 不在(0, 1) 之间的时候使用DefaultBloomErrorRate
+func NewBloom(capacity uint64, errorRate float64) Deduper {
+	if capacity == 0 {
+		capacity = DefaultBloomCapacity
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		errorRate = DefaultBloomErrorRate
+	}
+	return &bloom{
+		filters:   []*bloomFilter{newBloomFilter(capacity, errorRate*(1-bloomTightening))},
+		errorRate: errorRate,
+	}
+}
+
+func (b *bloom) Seen(uuid string) (bool, error) {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(uuid))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	// h2 为奇数，保证和m 互质的概率更高，减少位置重复
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return false, ErrClosed
+	}
+	for _, filter := range b.filters {
+		if filter.has(h1, h2) {
+			return true, nil
+		}
+	}
+	last := b.filters[len(b.filters)-1]
+	if last.count >= last.capacity {
+		errorRate := b.errorRate * (1 - bloomTightening) * math.Pow(bloomTightening, float64(len(b.filters)))
+		last = newBloomFilter(last.capacity*bloomGrowth, errorRate)
+		b.filters = append(b.filters, last)
+	}
+	last.add(h1, h2)
+	return false, nil
+}
+
+func (b *bloom) MemoryUsage() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	size := int64(0)
+	for _, filter := range b.filters {
+		size += int64(len(filter.bits)) * 8
+	}
+	return size
+}
+
+func (b *bloom) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.filters = nil
+	return nil
+}
@@ -0,0 +1,47 @@
+package dedup
+
+import (
+	"errors"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+// entryOverhead map 中每个元素除了key 的内容之外，估算占用的内存
+const entryOverhead = 48
+
+// ErrClosed 已经关闭之后调用Seen
+var ErrClosed = errors.New("dedup closed")
+
+// Deduper 按照记录的uuid 去重，实现需要保证并发安全
+type Deduper interface {
+	// Seen 记录uuid，uuid 之前已经出现过的时候返回true
+	Seen(uuid string) (bool, error)
+	// MemoryUsage 估算当前占用的内存，单位字节
+	MemoryUsage() int64
+	// Close 释放内存和临时文件，关闭之后调用Seen 返回ErrClosed
+	Close() error
+}
+
+type none struct{}
+
+// NewNone 不去重，所有记录都当作第一次出现
+func NewNone() Deduper {
+	return none{}
+}
+
+func (none) Seen(uuid string) (bool, error) {
+	return false, nil
+}
+
+func (none) MemoryUsage() int64 {
+	return 0
+}
+
+func (none) Close() error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+// checkExact 精确去重，第一次出现返回false，再次出现返回true
+func checkExact(t *testing.T, d Deduper, cnt int) {
+	for idx := 0; idx < cnt; idx++ {
+		seen, err := d.Seen(fmt.Sprintf("uuid-%d", idx))
+		require.NoError(t, err, "seen error")
+		require.False(t, seen, "first seen. idx: %d", idx)
+	}
+	for idx := 0; idx < cnt; idx++ {
+		seen, err := d.Seen(fmt.Sprintf("uuid-%d", idx))
+		require.NoError(t, err, "seen error")
+		require.True(t, seen, "seen again. idx: %d", idx)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	d := NewMemory()
+	checkExact(t, d, 1000)
+	require.True(t, d.MemoryUsage() > 0, "memory usage")
+	require.NoError(t, d.Close(), "close")
+	_, err := d.Seen("uuid-0")
+	require.Equal(t, ErrClosed, err, "seen after close")
+}
+
+func TestNone(t *testing.T) {
+	d := NewNone()
+	for idx := 0; idx < 2; idx++ {
+		seen, err := d.Seen("uuid")
+		require.NoError(t, err, "seen error")
+		require.False(t, seen, "none never seen")
+	}
+}
+
+// TestBloom 不会漏掉重复的数据，超过容量后误判率不超过配置的值
+func TestBloom(t *testing.T) {
+	d := NewBloom(1000, 0.01)
+	cnt := 20000
+	falsePositive := 0
+	for idx := 0; idx < cnt; idx++ {
+		seen, err := d.Seen(fmt.Sprintf("uuid-%d", idx))
+		require.NoError(t, err, "seen error")
+		if seen {
+			falsePositive++
+		}
+	}
+	for idx := 0; idx < cnt; idx++ {
+		seen, err := d.Seen(fmt.Sprintf("uuid-%d", idx))
+		require.NoError(t, err, "seen error")
+		require.True(t, seen, "seen again. idx: %d", idx)
+	}
+	require.True(t, float64(falsePositive)/float64(cnt) < 0.01, "false positive: %d", falsePositive)
+	require.True(t, d.(*bloom).filters[0].capacity < uint64(cnt), "bloom grow")
+	require.True(t, len(d.(*bloom).filters) > 1, "bloom grow")
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDisk(100, dir)
+	checkExact(t, d, 1050)
+	require.Equal(t, int64(1000), d.(*disk).count, "spill count")
+	require.Equal(t, int64(50*(digestSize+entryOverhead)), d.MemoryUsage(), "memory usage")
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err, "list temp file")
+	require.Equal(t, 1, len(files), "only merged file")
+	require.NoError(t, d.Close(), "close")
+	_, err = os.Stat(files[0])
+	require.True(t, os.IsNotExist(err), "remove temp file")
+}
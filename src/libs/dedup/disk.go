@@ -0,0 +1,190 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+const (
+	// DefaultDiskMemoryLimit 内存中最多保存的记录数量，超过后写入磁盘
+	DefaultDiskMemoryLimit = 1000000
+
+	// digestSize 每条记录保存uuid sha256 的前16 字节，冲突的概率可以忽略
+	digestSize = 16
+)
+
+type digest [digestSize]byte
+
+func newDigest(uuid string) digest {
+	sum := sha256.Sum256([]byte(uuid))
+	d := digest{}
+	copy(d[:], sum[:digestSize])
+	return d
+}
+
+// disk 精确去重，内存中的记录数量超过memoryLimit 后，和磁盘中已经排序的记录合并写入新的临时文件。
+// 查询时先查内存，再在文件中二分查找，内存占用固定，磁盘占用每条记录16 字节
+type disk struct {
+	mutex       sync.Mutex
+	dir         string
+	memoryLimit int
+	values      map[digest]struct{}
+	// file 已经排序的记录，count 是文件中记录的数量
+	file   *os.File
+	count  int64
+	closed bool
+}
+
+// NewDisk memoryLimit 为0 的时候使用DefaultDiskMemoryLimit，dir 为空的时候使用系统的临时目录
+func NewDisk(memoryLimit int, dir string) Deduper {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultDiskMemoryLimit
+	}
+	return &disk{
+		dir:         dir,
+		memoryLimit: memoryLimit,
+		values:      make(map[digest]struct{}),
+	}
+}
+
+func (d *disk) Seen(uuid string) (bool, error) {
+	key := newDigest(uuid)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return false, ErrClosed
+	}
+	if _, ok := d.values[key]; ok {
+		return true, nil
+	}
+	exists, err := d.fileContains(key)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return true, nil
+	}
+	d.values[key] = struct{}{}
+	if len(d.values) >= d.memoryLimit {
+		if err := d.spill(); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// fileContains 在文件中二分查找
+func (d *disk) fileContains(key digest) (bool, error) {
+	buf := make([]byte, digestSize)
+	low, high := int64(0), d.count
+	for low < high {
+		mid := low + (high-low)/2
+		if _, err := d.file.ReadAt(buf, mid*digestSize); err != nil {
+			return false, err
+		}
+		switch cmp := bytes.Compare(buf, key[:]); {
+		case cmp == 0:
+			return true, nil
+		case cmp < 0:
+			low = mid + 1
+		default:
+			high = mid
+		}
+	}
+	return false, nil
+}
+
+// spill 内存中的记录排序后和文件中的记录合并，写入新的文件
+func (d *disk) spill() error {
+	keys := make([]digest, 0, len(d.values))
+	for key := range d.values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+
+	file, err := os.CreateTemp(d.dir, "incenses-dedup-*")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	var reader *bufio.Reader
+	if d.file != nil {
+		reader = bufio.NewReader(io.NewSectionReader(d.file, 0, d.count*digestSize))
+	}
+	buf := make([]byte, digestSize)
+	remain := d.count
+	hasBuf := false
+	idx := 0
+	for idx < len(keys) || remain > 0 || hasBuf {
+		if !hasBuf && remain > 0 {
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return d.removeTemp(file, err)
+			}
+			remain--
+			hasBuf = true
+		}
+		if hasBuf && (idx >= len(keys) || bytes.Compare(buf, keys[idx][:]) < 0) {
+			_, err = writer.Write(buf)
+			hasBuf = false
+		} else {
+			_, err = writer.Write(keys[idx][:])
+			idx++
+		}
+		if err != nil {
+			return d.removeTemp(file, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return d.removeTemp(file, err)
+	}
+
+	if d.file != nil {
+		_ = d.file.Close()
+		_ = os.Remove(d.file.Name())
+	}
+	d.file = file
+	d.count += int64(len(keys))
+	d.values = make(map[digest]struct{})
+	return nil
+}
+
+func (d *disk) removeTemp(file *os.File, err error) error {
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+	return err
+}
+
+func (d *disk) MemoryUsage() int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return int64(len(d.values)) * (digestSize + entryOverhead)
+}
+
+func (d *disk) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	d.values = nil
+	if d.file == nil {
+		return nil
+	}
+	_ = d.file.Close()
+	return os.Remove(d.file.Name())
+}
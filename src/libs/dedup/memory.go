@@ -0,0 +1,53 @@
+package dedup
+
+import (
+	"sync"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/27
+    @desc:
+
+***************************/
+
+// memory 在内存中保存全部的uuid，精确去重，内存占用和记录数量成正比
+type memory struct {
+	mutex  sync.Mutex
+	values map[string]struct{}
+	size   int64
+}
+
+func NewMemory() Deduper {
+	return &memory{
+		values: make(map[string]struct{}),
+	}
+}
+
+func (m *memory) Seen(uuid string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.values == nil {
+		return false, ErrClosed
+	}
+	if _, ok := m.values[uuid]; ok {
+		return true, nil
+	}
+	m.values[uuid] = struct{}{}
+	m.size += int64(len(uuid)) + entryOverhead
+	return false, nil
+}
+
+func (m *memory) MemoryUsage() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.size
+}
+
+func (m *memory) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.values = nil
+	m.size = 0
+	return nil
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("test_records_total", "records\ncount", "task", "stage")
+	gauge := r.NewGauge("test_running", "running tasks")
+	histogram := r.NewHistogram("test_duration_seconds", "duration", []float64{1, 0.5}, "task")
+	r.NewCounter("test_empty_total", "no series", "task")
+
+	counter.Inc("t1", "collected")
+	counter.Add(2, "t1", "collected")
+	counter.Add(-1, "t1", "collected")
+	counter.Inc(`a"b\c`, "filtered")
+	gauge.Set(3)
+	gauge.Add(-1)
+	histogram.Observe(0.2, "t1")
+	histogram.Observe(0.5, "t1")
+	histogram.Observe(0.7, "t1")
+	histogram.Observe(2, "t1")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.WriteText(buf), "write text")
+	require.Equal(t, `# HELP test_duration_seconds duration
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{task="t1",le="0.5"} 2
+test_duration_seconds_bucket{task="t1",le="1"} 3
+test_duration_seconds_bucket{task="t1",le="+Inf"} 4
+test_duration_seconds_sum{task="t1"} 3.4
+test_duration_seconds_count{task="t1"} 4
+# HELP test_records_total records\ncount
+# TYPE test_records_total counter
+test_records_total{task="a\"b\\c",stage="filtered"} 1
+test_records_total{task="t1",stage="collected"} 3
+# HELP test_running running tasks
+# TYPE test_running gauge
+test_running 2
+`, buf.String())
+}
+
+func TestConcurrent(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("test_total", "total", "task")
+	wg := sync.WaitGroup{}
+	for idx := 0; idx < 10; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cnt := 0; cnt < 100; cnt++ {
+				counter.Inc("t1")
+				_ = r.WriteText(io.Discard)
+			}
+		}()
+	}
+	wg.Wait()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.WriteText(buf), "write text")
+	require.Contains(t, buf.String(), `test_total{task="t1"} 1000`)
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("test_total", "total", "task")
+	require.Panics(t, func() { r.NewGauge("test_total", "total") }, "duplicate name")
+	require.Panics(t, func() { counter.Inc() }, "label count mismatch")
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "total").Inc()
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, contentType, recorder.Header().Get("Content-Type"), "content type")
+	require.Equal(t, "# HELP test_total total\n# TYPE test_total counter\ntest_total 1\n", recorder.Body.String())
+}
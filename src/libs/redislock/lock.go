@@ -2,12 +2,13 @@ package redislock
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/go-redis/redis/v9"
 
 	mContext "github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
 )
 
 /***************************
@@ -23,36 +24,131 @@ func SetClient(c *redis.Client) {
 	cache = c
 }
 
-// Lock 获取执行锁, lockedExpireMinute 单位是Nanosecond， 最后回转换为 Millisecond
-func Lock(ctx context.Context, key string, lockedExpireMinute time.Duration) (bool, error) {
+const (
+	// fencingKeySuffix 保存锁最后一次获取时得到的fencing token，用于查询锁的状态
+	fencingKeySuffix = ":fencing"
+)
+
+var (
+	// lockWithFencingScript 获取锁成功的时候，递增fencing key 的计数器并返回，获取失败返回0
+	lockWithFencingScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	local token = redis.call("INCR", KEYS[2])
+	redis.call("SET", KEYS[3], token)
+	return token
+end
+return 0
+`)
+	// refreshScript 只有锁的持有者可以续约
+	refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+type lock struct {
+	client *redis.Client
+}
+
+// New 使用redis 实现的任务锁
+func New(client *redis.Client) define.Lock {
+	return &lock{
+		client: client,
+	}
+}
+
+func (l *lock) Lock(ctx context.Context, key, fencingKey string, lockedExpire time.Duration) (uint64, bool, error) {
 	rid := mContext.CtxLogID(ctx)
 
-	// 是否可以执行任务
-	ok, err := cache.SetNX(ctx, key, []byte(rid), lockedExpireMinute/time.Millisecond).Result()
+	token, err := lockWithFencingScript.Run(ctx, l.client, []string{key, fencingKey, key + fencingKeySuffix},
+		rid, lockedExpire.Milliseconds()).Uint64()
 	if err != nil {
-		return false, err
+		return 0, false, err
 	}
 
-	return ok, nil
+	return token, token > 0, nil
+}
+
+func (l *lock) Refresh(ctx context.Context, key string, lockedExpire time.Duration) (bool, error) {
+	rid := mContext.CtxLogID(ctx)
+
+	ok, err := refreshScript.Run(ctx, l.client, []string{key}, rid, lockedExpire.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
 
+	return ok == 1, nil
 }
 
-// Unlock 释放锁
-func Unlock(ctx context.Context, key string) error {
+func (l *lock) Unlock(ctx context.Context, key string) error {
 	rid := mContext.CtxLogID(ctx)
 
-	val, err := cache.Get(ctx, key).Result()
+	val, err := l.client.Get(ctx, key).Result()
 	if err != nil {
 		return err
 	}
 	// 判断是否是否当前任务锁的
 	if string(val) == rid {
-		if err := cache.Del(ctx, key).Err(); err != nil {
+		if err := l.client.Del(ctx, key).Err(); err != nil {
 			return err
 		}
 	} else {
-		return fmt.Errorf("unauthorized operation")
+		return define.ErrLockUnauthorized
 	}
 
 	return nil
 }
+
+func (l *lock) Inspect(ctx context.Context, key string) (define.LockInfo, error) {
+	info := define.LockInfo{Key: key}
+	pipe := l.client.Pipeline()
+	owner := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	token := pipe.Get(ctx, key+fencingKeySuffix)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return info, err
+	}
+	if val, err := token.Uint64(); err == nil {
+		info.FencingToken = val
+	}
+	if val, err := owner.Result(); err == nil && ttl.Val() > 0 {
+		info.Owner = val
+		info.ExpireTime = time.Now().Add(ttl.Val()).UnixNano() / int64(time.Millisecond)
+	}
+	return info, nil
+}
+
+// Lock 获取执行锁, lockedExpireMinute 锁的过期时间，redis 中精确到 Millisecond
+func Lock(ctx context.Context, key string, lockedExpireMinute time.Duration) (bool, error) {
+	rid := mContext.CtxLogID(ctx)
+
+	// 是否可以执行任务
+	ok, err := cache.SetNX(ctx, key, []byte(rid), lockedExpireMinute).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+
+}
+
+// Unlock 释放锁
+func Unlock(ctx context.Context, key string) error {
+	return New(cache).Unlock(ctx, key)
+}
+
+// LockWithFencing 获取执行锁，获取成功的时候返回fencingKey 计数器单调递增的fencing token。
+// 锁过期后被其他节点获取，新的持有者拿到更大的token，output 可以根据token 拒绝过期持有者的写入
+func LockWithFencing(ctx context.Context, key, fencingKey string, lockedExpire time.Duration) (uint64, bool, error) {
+	return New(cache).Lock(ctx, key, fencingKey, lockedExpire)
+}
+
+// Refresh 续约执行锁，锁已经过期或者被其他人持有的时候返回false
+func Refresh(ctx context.Context, key string, lockedExpire time.Duration) (bool, error) {
+	return New(cache).Refresh(ctx, key, lockedExpire)
+}
+
+var _ define.Lock = (*lock)(nil)
+var _ define.LockInspector = (*lock)(nil)
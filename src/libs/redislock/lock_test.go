@@ -8,6 +8,9 @@ import (
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v9"
+
+	mContext "github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
 )
 
 /***************************
@@ -105,3 +108,95 @@ func TestUnlock(t *testing.T) {
 	err = Unlock(ctx, key)
 	require.NoError(t, err, "unlock error")
 }
+
+func TestLockWithFencing(t *testing.T) {
+
+	miniredisDB, err := initClient()
+	require.NoError(t, err, "test lock  init error")
+
+	key := "metric:test:fencing"
+	fencingKey := define.FencingKey("test")
+	keyExpire := time.Minute
+
+	token, locked, err := LockWithFencing(context.TODO(), key, fencingKey, keyExpire)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "first lock")
+	require.Equal(t, uint64(1), token, "first token")
+
+	token, locked, err = LockWithFencing(context.TODO(), key, fencingKey, keyExpire)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, false, locked, "lock held")
+	require.Equal(t, uint64(0), token, "lock held token")
+
+	miniredisDB.FastForward(keyExpire)
+	token, locked, err = LockWithFencing(context.TODO(), key, fencingKey, keyExpire)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "expire lock")
+	require.Equal(t, uint64(2), token, "token increase after expire")
+
+	// 使用同一个fencing key 的锁共享计数器
+	token, locked, err = LockWithFencing(context.TODO(), key+":shard", fencingKey, keyExpire)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "other lock key")
+	require.Equal(t, uint64(3), token, "token shared by lock keys")
+}
+
+func TestRefresh(t *testing.T) {
+
+	miniredisDB, err := initClient()
+	require.NoError(t, err, "test lock  init error")
+
+	key := "metric:test:refresh"
+	keyExpire := time.Minute
+
+	ok, err := Refresh(context.TODO(), key, keyExpire)
+	require.NoError(t, err, "refresh error")
+	require.Equal(t, false, ok, "refresh not locked key")
+
+	_, locked, err := LockWithFencing(context.TODO(), key, define.FencingKey("refresh"), keyExpire)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "lock")
+
+	miniredisDB.FastForward(keyExpire / 2)
+	ok, err = Refresh(context.TODO(), key, keyExpire)
+	require.NoError(t, err, "refresh error")
+	require.Equal(t, true, ok, "refresh owner")
+
+	// 续约后，超过原来的过期时间，锁依然有效
+	miniredisDB.FastForward(keyExpire * 3 / 4)
+	require.Equal(t, true, miniredisDB.Exists(key), "lock exists after refresh")
+
+	require.NoError(t, miniredisDB.Set(key, "other"), "change owner")
+	ok, err = Refresh(context.TODO(), key, keyExpire)
+	require.NoError(t, err, "refresh error")
+	require.Equal(t, false, ok, "refresh other owner")
+}
+
+func TestInspect(t *testing.T) {
+
+	miniredisDB, err := initClient()
+	require.NoError(t, err, "test lock  init error")
+
+	l := New(cache).(define.LockInspector)
+	key := "metric:test:inspect"
+	keyExpire := time.Minute
+	ctx := mContext.Background()
+
+	info, err := l.Inspect(ctx, key)
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, define.LockInfo{Key: key}, info, "not locked")
+
+	_, locked, err := LockWithFencing(ctx, key, define.FencingKey("inspect"), keyExpire)
+	require.NoError(t, err, "lock error")
+	require.Equal(t, true, locked, "lock")
+	info, err = l.Inspect(context.TODO(), key)
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, mContext.CtxLogID(ctx), info.Owner, "owner")
+	require.Equal(t, uint64(1), info.FencingToken, "token")
+	require.True(t, info.ExpireTime > time.Now().UnixNano()/int64(time.Millisecond), "expire time")
+
+	miniredisDB.FastForward(keyExpire)
+	info, err = l.Inspect(context.TODO(), key)
+	require.NoError(t, err, "inspect error")
+	require.Equal(t, define.LockInfo{Key: key, FencingToken: 1}, info, "expired")
+}
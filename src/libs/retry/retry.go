@@ -1,6 +1,9 @@
 package retry
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 /***************************
     @author: tiansheng.ren
@@ -21,19 +24,44 @@ type fn func(idx int) (next bool, err error)
 //       f: 执行的函数, 返回值next 表示是否需要继续执行，不会判断err
 //       delay: 重试间隔时间， 最小 time.Millisecond * 100
 func Retry(retryNum int, f fn, delay time.Duration) error {
+	return Backoff(context.Background(), retryNum, f, delay, 1, 0)
+}
+
+// Backoff 间隔时间递增的重试，ctx 取消的时候停止等待，返回ctx 的错误
+//    Params:
+//       ctx: 等待重试间隔的时候检查是否取消
+//       retryNum: 最大重试次数
+//       f: 执行的函数, 返回值next 表示是否需要继续执行，不会判断err
+//       delay: 第一次重试间隔时间， 最小 time.Millisecond * 100
+//       factor: 每次重试后间隔时间增加的倍数，小于等于1 的时候间隔时间不变
+//       maxDelay: 间隔时间的上限，小于等于0 不限制
+func Backoff(ctx context.Context, retryNum int, f fn, delay time.Duration, factor float64, maxDelay time.Duration) error {
 	if retryNum < 1 {
 		retryNum = 1
 	}
 	if delay < minDelay {
 		delay = minDelay
 	}
+	if maxDelay > 0 && maxDelay < delay {
+		maxDelay = delay
+	}
 
 	next, err := bool(false), error(nil)
 	for idx := 0; idx < retryNum; idx++ {
 		next, err = f(idx)
 		// 需要继续执行
 		if next {
-			time.Sleep(delay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			if factor > 1 {
+				delay = time.Duration(float64(delay) * factor)
+			}
+			if maxDelay > 0 && delay > maxDelay {
+				delay = maxDelay
+			}
 			continue
 		}
 		// 错误不需要继续执行
@@ -1,6 +1,8 @@
 package retry
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -90,3 +92,57 @@ func TestRetry10Success(t *testing.T) {
 		return
 	}
 }
+
+func TestBackoffDelay(t *testing.T) {
+	cnt := 0
+	retryNum := 4
+	delays := make([]time.Duration, 0, retryNum)
+	lastTime := time.Now()
+	err := Backoff(context.Background(), retryNum, func(idx int) (next bool, err error) {
+		cnt = idx + 1
+		if idx > 0 {
+			delays = append(delays, time.Now().Sub(lastTime))
+		}
+		lastTime = time.Now()
+		return true, fmt.Errorf("test error. idx: %d", idx)
+	}, time.Millisecond*100, 2, time.Millisecond*300)
+	if err == nil {
+		t.Errorf("retry return error. actual: nil, expect: error")
+		return
+	}
+	if cnt != retryNum {
+		t.Errorf("execute count error. actual: %d, expect: %d", cnt, retryNum)
+		return
+	}
+	// 100ms, 200ms, 300ms(超过上限)
+	expectDelays := []time.Duration{time.Millisecond * 100, time.Millisecond * 200, time.Millisecond * 300}
+	for idx, expect := range expectDelays {
+		if delays[idx] < expect || delays[idx] > expect+time.Millisecond*90 {
+			t.Errorf("retry delay error. index: %d, actual: %s, expect: %s", idx, delays[idx], expect)
+			return
+		}
+	}
+}
+
+func TestBackoffCancel(t *testing.T) {
+	cnt := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	startTime := time.Now()
+	err := Backoff(ctx, 3, func(idx int) (next bool, err error) {
+		cnt = idx + 1
+		cancel()
+		return true, fmt.Errorf("test error. idx: %d", idx)
+	}, time.Second*10, 1, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("retry return error. actual: %v, expect: %s", err, context.Canceled)
+		return
+	}
+	if cnt != 1 {
+		t.Errorf("execute count error. actual: %d, expect: %d", cnt, 1)
+		return
+	}
+	if time.Now().Sub(startTime) >= time.Second*10 {
+		t.Errorf("retry not stop waiting after context canceled")
+		return
+	}
+}
@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/21
+    @desc:
+
+***************************/
+
+// Scheduler 按照权重限制同时执行的任务，超过限制的任务按照提交的顺序等待执行
+type Scheduler interface {
+	// Submit 提交任务，同名任务正在执行或者等待执行的时候不会重复提交，返回值表示是否提交成功。
+	// weight 任务占用的并发数量，小于1 按照1 计算，大于总的并发数量按照总的并发数量计算
+	Submit(ctx context.Context, name string, weight int, f func(ctx context.Context)) bool
+	// Stats 当前执行的情况
+	Stats() Stats
+	// Wait 等待所有已经提交的任务结束
+	Wait()
+}
+
+// Stats 调度器的状态
+type Stats struct {
+	// Capacity 总的并发数量
+	Capacity int `json:"capacity"`
+	// Used 正在执行的任务占用的并发数量
+	Used int `json:"used"`
+	// Running 正在执行的任务数量
+	Running int `json:"running"`
+	// Waiting 等待执行的任务数量，队列深度
+	Waiting int `json:"waiting"`
+}
+
+type waiter struct {
+	weight int
+	ready  chan struct{}
+}
+
+type scheduler struct {
+	mutex    sync.Mutex
+	capacity int
+	used     int
+	running  int
+	waiters  *list.List
+	// names 已经提交并且没有结束的任务
+	names map[string]struct{}
+	wg    sync.WaitGroup
+}
+
+func New(capacity int) Scheduler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &scheduler{
+		capacity: capacity,
+		waiters:  list.New(),
+		names:    make(map[string]struct{}),
+	}
+}
+
+func (s *scheduler) Submit(ctx context.Context, name string, weight int, f func(ctx context.Context)) bool {
+	if weight < 1 {
+		weight = 1
+	}
+	if weight > s.capacity {
+		weight = s.capacity
+	}
+
+	s.mutex.Lock()
+	if _, ok := s.names[name]; ok {
+		s.mutex.Unlock()
+		return false
+	}
+	s.names[name] = struct{}{}
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.names, name)
+			s.mutex.Unlock()
+			s.wg.Done()
+		}()
+		if !s.acquire(ctx, weight) {
+			return
+		}
+		defer s.release(weight)
+		f(ctx)
+	}()
+
+	return true
+}
+
+func (s *scheduler) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return Stats{
+		Capacity: s.capacity,
+		Used:     s.used,
+		Running:  s.running,
+		Waiting:  s.waiters.Len(),
+	}
+}
+
+func (s *scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// acquire 获取执行需要的并发数量，ctx 结束的时候放弃等待
+func (s *scheduler) acquire(ctx context.Context, weight int) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	s.mutex.Lock()
+	// 有等待的任务时需要排队，避免权重大的任务一直等待
+	if s.waiters.Len() == 0 && s.used+weight <= s.capacity {
+		s.used += weight
+		s.running++
+		s.mutex.Unlock()
+		return true
+	}
+	w := &waiter{weight: weight, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-ctx.Done():
+		s.mutex.Lock()
+		select {
+		case <-w.ready:
+			// 取消的同时已经获取到了，需要归还
+			s.mutex.Unlock()
+			s.release(weight)
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// 队首的任务放弃等待，后面的任务可能可以执行
+			if isFront {
+				s.notifyWaiters()
+			}
+			s.mutex.Unlock()
+		}
+		return false
+	}
+}
+
+func (s *scheduler) release(weight int) {
+	s.mutex.Lock()
+	s.used -= weight
+	s.running--
+	s.notifyWaiters()
+	s.mutex.Unlock()
+}
+
+// notifyWaiters 按照顺序唤醒可以执行的任务, 调用前需要持有锁
+func (s *scheduler) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*waiter)
+		if s.used+w.weight > s.capacity {
+			return
+		}
+		s.used += w.weight
+		s.running++
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
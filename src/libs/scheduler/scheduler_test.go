@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/21
+    @desc:
+
+***************************/
+
+// TestSchedulerLimit 同时执行的任务权重不能超过总的并发数量
+func TestSchedulerLimit(t *testing.T) {
+	s := New(3)
+	mutex := sync.Mutex{}
+	used, maxUsed, execCnt := 0, 0, 0
+	for idx := 0; idx < 20; idx++ {
+		weight := idx%2 + 1
+		ok := s.Submit(context.TODO(), fmt.Sprintf("task-%d", idx), weight, func(ctx context.Context) {
+			mutex.Lock()
+			used += weight
+			execCnt++
+			if used > maxUsed {
+				maxUsed = used
+			}
+			mutex.Unlock()
+			time.Sleep(time.Millisecond * 5)
+			mutex.Lock()
+			used -= weight
+			mutex.Unlock()
+		})
+		require.Equal(t, true, ok, "submit task. index: %d", idx)
+	}
+	s.Wait()
+	require.Equal(t, 20, execCnt, "execute count")
+	require.LessOrEqual(t, maxUsed, 3, "max used")
+	require.Equal(t, Stats{Capacity: 3}, s.Stats(), "stats after wait")
+}
+
+// TestSchedulerSameName 同名的任务没有结束的时候不能重复提交
+func TestSchedulerSameName(t *testing.T) {
+	s := New(2)
+	block := make(chan struct{})
+	ok := s.Submit(context.TODO(), "task", 1, func(ctx context.Context) { <-block })
+	require.Equal(t, true, ok, "first submit")
+	ok = s.Submit(context.TODO(), "task", 1, func(ctx context.Context) {})
+	require.Equal(t, false, ok, "submit running task")
+	close(block)
+	s.Wait()
+	ok = s.Submit(context.TODO(), "task", 1, func(ctx context.Context) {})
+	require.Equal(t, true, ok, "submit finished task")
+	s.Wait()
+}
+
+// TestSchedulerWaiting 超过并发的任务排队，ctx 结束的时候放弃等待
+func TestSchedulerWaiting(t *testing.T) {
+	s := New(1)
+	block := make(chan struct{})
+	s.Submit(context.TODO(), "running", 1, func(ctx context.Context) { <-block })
+	require.Eventually(t, func() bool {
+		return s.Stats().Running == 1
+	}, time.Second, time.Millisecond, "running count")
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	executed := false
+	s.Submit(ctx, "waiting", 1, func(ctx context.Context) { executed = true })
+	require.Eventually(t, func() bool {
+		return s.Stats().Waiting == 1
+	}, time.Second, time.Millisecond, "waiting count")
+
+	cancel()
+	require.Eventually(t, func() bool {
+		return s.Stats().Waiting == 0
+	}, time.Second, time.Millisecond, "waiting cancel")
+	close(block)
+	s.Wait()
+	require.Equal(t, false, executed, "canceled task executed")
+}
@@ -22,6 +22,23 @@ func GetTimeIntervalMill(timestamp uint64, cycle CycleType) (TimeInterval, error
 	return getTimeInterval(timestamp, cycle, timeStampTypeMillisecond)
 }
 
+// GetTimeIntervalRange 输出[start, end] 时间范围内的所有周期，按照时间顺序排列，时间戳单位秒
+func GetTimeIntervalRange(start, end uint64, cycle CycleType) ([]TimeInterval, error) {
+	if start > end {
+		return nil, fmt.Errorf("start time greater than end time. start: %d, end: %d", start, end)
+	}
+	results := make([]TimeInterval, 0)
+	for ts := start; ts <= end; {
+		interval, err := GetTimeInterval(ts, cycle)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, interval)
+		ts = interval.End + 1
+	}
+	return results, nil
+}
+
 // getTimeInterval 输入时间戳，输出时间戳所在周期的开始时间和结束时间
 func getTimeInterval(timestamp uint64, cycle CycleType, timeStampType timeStampType) (TimeInterval, error) {
 	var res TimeInterval
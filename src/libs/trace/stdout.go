@@ -0,0 +1,39 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+// stdout 每个span 输出一行json
+type stdout struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewStdoutExporter w 一般是os.Stdout
+func NewStdoutExporter(w io.Writer) Exporter {
+	return &stdout{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (s *stdout) Export(_ context.Context, spans []SpanData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, span := range spans {
+		if err := s.encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+const (
+	// queueSize 等待导出的span 数量上限，超过后丢弃
+	queueSize = 4096
+	// batchSize 每次导出的span 数量上限
+	batchSize = 512
+	// exportInterval 没有凑满批次时，导出的间隔时间
+	exportInterval = 5 * time.Second
+	// exportTimeout 单次导出的超时时间
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter 导出已经结束的span
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Attribute span 的属性，Value 支持string, bool, int, int64, float64，其他类型导出时转换成字符串
+type Attribute struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Float(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData 已经结束的span
+type SpanData struct {
+	TraceID      string      `json:"trace_id"`
+	SpanID       string      `json:"span_id"`
+	ParentSpanID string      `json:"parent_span_id,omitempty"`
+	Name         string      `json:"name"`
+	StartTime    time.Time   `json:"start_time"`
+	EndTime      time.Time   `json:"end_time"`
+	Attributes   []Attribute `json:"attributes,omitempty"`
+	// Error 不为空的时候表示执行失败
+	Error string `json:"error,omitempty"`
+}
+
+// Tracer 生成span，结束的span 在后台批量导出。Tracer 为nil 的时候不生成span，方法都可以在nil 上调用
+type Tracer struct {
+	exporter Exporter
+	onError  func(err error)
+	queue    chan SpanData
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	dropped  int64
+}
+
+// NewTracer onError 在导出失败的时候调用，可以为nil
+func NewTracer(exporter Exporter, onError func(err error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		queue:    make(chan SpanData, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+// Start parent 为nil 的时候开始新的trace
+func (t *Tracer) Start(parent *Span, name string, attrs ...Attribute) *Span {
+	return t.StartAt(parent, name, time.Now(), attrs...)
+}
+
+// StartAt 指定开始时间，用于汇总多次调用的span
+func (t *Tracer) StartAt(parent *Span, name string, start time.Time, attrs ...Attribute) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			SpanID:     newID(8),
+			Name:       name,
+			StartTime:  start,
+			Attributes: attrs,
+		},
+	}
+	if parent != nil {
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentSpanID = parent.data.SpanID
+	} else {
+		span.data.TraceID = newID(16)
+	}
+	return span
+}
+
+// Dropped 队列满了或者Shutdown 之后被丢弃的span 数量
+func (t *Tracer) Dropped() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.dropped)
+}
+
+// Shutdown 导出队列中全部的span 后退出，之后结束的span 会被丢弃
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.stop:
+		atomic.AddInt64(&t.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case t.queue <- data:
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, batchSize)
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case <-t.stop:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+					if len(batch) >= batchSize {
+						batch = t.export(batch)
+					}
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export 返回清空后的batch
+func (t *Tracer) export(batch []SpanData) []SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := t.exporter.Export(ctx, batch); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+	return make([]SpanData, 0, batchSize)
+}
+
+// Span 一段执行过程，为nil 的时候方法不做任何处理
+type Span struct {
+	tracer *Tracer
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// End 结束span，err 不为nil 的时候记录为失败，只有第一次调用有效
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mutex.Unlock()
+	s.tracer.enqueue(data)
+}
+
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.data.TraceID
+}
+
+func (s *Span) SpanID() string {
+	if s == nil {
+		return ""
+	}
+	return s.data.SpanID
+}
+
+func newID(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
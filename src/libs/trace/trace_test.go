@@ -0,0 +1,135 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+type memoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (m *memoryExporter) Export(_ context.Context, spans []SpanData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter, nil)
+	root := tracer.Start(nil, "task", String("task", "t1"))
+	child := tracer.StartAt(root, "key", time.Now().Add(-time.Second), Int("records", 3))
+	child.SetAttributes(Bool("retry", true))
+	child.End(errors.New("collect error"))
+	child.End(nil)
+	root.End(nil)
+	require.NoError(t, tracer.Shutdown(context.Background()), "shutdown")
+
+	require.Equal(t, 2, len(exporter.spans), "span count")
+	key, task := exporter.spans[0], exporter.spans[1]
+	require.Equal(t, "key", key.Name)
+	require.Equal(t, task.TraceID, key.TraceID, "same trace")
+	require.Equal(t, task.SpanID, key.ParentSpanID, "parent span")
+	require.Equal(t, "", task.ParentSpanID, "root span")
+	require.Equal(t, 32, len(task.TraceID), "trace id")
+	require.Equal(t, 16, len(task.SpanID), "span id")
+	require.Equal(t, "collect error", key.Error, "first end")
+	require.Equal(t, []Attribute{Int("records", 3), Bool("retry", true)}, key.Attributes)
+	require.True(t, key.EndTime.Sub(key.StartTime) >= time.Second, "start at")
+
+	tracer.Start(nil, "after shutdown").End(nil)
+	require.Equal(t, int64(1), tracer.Dropped(), "dropped after shutdown")
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start(nil, "task")
+	require.Nil(t, span, "nil span")
+	span.SetAttributes(String("k", "v"))
+	span.End(nil)
+	require.Equal(t, "", span.TraceID())
+	require.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestStdoutExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := NewTracer(NewStdoutExporter(buf), nil)
+	tracer.Start(nil, "task", String("task", "t1")).End(errors.New("failed"))
+	require.NoError(t, tracer.Shutdown(context.Background()), "shutdown")
+
+	span := SpanData{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &span), "one json line")
+	require.Equal(t, "task", span.Name)
+	require.Equal(t, "failed", span.Error)
+	require.Equal(t, []Attribute{String("task", "t1")}, span.Attributes)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, otlpTracesPath, r.URL.Path, "default path")
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPExporter(server.URL, "incenses", map[string]string{"Authorization": "Bearer token"})
+	require.NoError(t, err, "new exporter")
+	start := time.Unix(1, 5)
+	err = exporter.Export(context.Background(), []SpanData{{
+		TraceID:      "0102030405060708090a0b0c0d0e0f10",
+		SpanID:       "0102030405060708",
+		ParentSpanID: "0807060504030201",
+		Name:         "collect run",
+		StartTime:    start,
+		EndTime:      start.Add(time.Second),
+		Attributes:   []Attribute{String("key", "k1"), Int("attempt", 2), Float("rate", 0.5), Bool("retry", true)},
+		Error:        "source down",
+	}})
+	require.NoError(t, err, "export")
+	require.Equal(t, "application/json", header.Get("Content-Type"))
+	require.Equal(t, "Bearer token", header.Get("Authorization"))
+	require.JSONEq(t, `{"resourceSpans":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"incenses"}}]},
+		"scopeSpans":[{"scope":{"name":"github.com/rentiansheng/incenses"},"spans":[{
+			"traceId":"0102030405060708090a0b0c0d0e0f10","spanId":"0102030405060708","parentSpanId":"0807060504030201",
+			"name":"collect run","kind":1,"startTimeUnixNano":"1000000005","endTimeUnixNano":"2000000005",
+			"attributes":[
+				{"key":"key","value":{"stringValue":"k1"}},
+				{"key":"attempt","value":{"intValue":"2"}},
+				{"key":"rate","value":{"doubleValue":0.5}},
+				{"key":"retry","value":{"boolValue":true}}],
+			"status":{"code":2,"message":"source down"}}]}]}]}`, string(body))
+
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failServer.Close()
+	exporter, err = NewOTLPExporter(failServer.URL+"/custom", "incenses", nil)
+	require.NoError(t, err, "new exporter")
+	require.Error(t, exporter.Export(context.Background(), []SpanData{{Name: "x"}}), "status error")
+
+	_, err = NewOTLPExporter("127.0.0.1:4318", "incenses", nil)
+	require.Error(t, err, "scheme required")
+}
@@ -24,6 +24,7 @@ type worker struct {
 	exitSignalChn  chan struct{}
 	cntChn         chan struct{}
 	execErr        error
+	execErrMutex   sync.Mutex
 	exitSignalOnce *sync.Once
 	waitAllExit    bool
 }
@@ -87,15 +88,15 @@ func (w *worker) Wait() error {
 		select {
 		case <-ticker.C:
 			if len(w.cntChn) == w.num {
-				return w.execErr
+				return w.getExecErr()
 			}
 		case <-w.exitSignalChn:
-			return w.execErr
+			return w.getExecErr()
 		}
 
 	}
 
-	return w.execErr
+	return w.getExecErr()
 
 }
 
@@ -104,7 +105,9 @@ func (w *worker) setExecErr(err error) {
 	w.exitSignalOnce.Do(func() {
 		// 设置执行错误, 必须放到 close(w.exitSignalChn) 前，避免现收到退出信号，
 		// 错误没有赋值，实际有错误，但是返回没有错
+		w.execErrMutex.Lock()
 		w.execErr = err
+		w.execErrMutex.Unlock()
 		if !w.waitAllExit {
 			// 通知其他人退出
 			close(w.exitSignalChn)
@@ -112,3 +115,9 @@ func (w *worker) setExecErr(err error) {
 	})
 
 }
+
+func (w *worker) getExecErr() error {
+	w.execErrMutex.Lock()
+	defer w.execErrMutex.Unlock()
+	return w.execErr
+}
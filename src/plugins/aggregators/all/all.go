@@ -2,6 +2,7 @@ package all
 
 import (
 	_ "github.com/rentiansheng/incenses/src/plugins/aggregators/count"
+	_ "github.com/rentiansheng/incenses/src/plugins/aggregators/distinct"
 	_ "github.com/rentiansheng/incenses/src/plugins/aggregators/two_sum_field_rate"
 )
 
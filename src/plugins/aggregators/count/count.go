@@ -20,6 +20,8 @@ import (
 
 const (
 	name = "count"
+	// totalField 作为多级聚合的上一级时，传递给下一级的数据中全部数据数量的字段
+	totalField = "total"
 )
 
 func init() {
@@ -46,14 +48,22 @@ type configOption struct {
 	ExtraRule *extraRule `json:"extra_rule"`
 }
 
+// Count 统计满足条件的数据数量。
+// 作为多级聚合的上一级时，数据不传递给下一级，key 处理完成后把统计的中间状态作为一条数据传递给下一级，
+// 字段output_key 是满足条件的数量，字段total 是全部数据的数量，例如下一级用two_field_sum_rate 计算比率
 type Count struct {
 	hasFilter      bool
 	config         []byte
 	configOpt      configOption
 	metricMetadata define.MetricMetadata
 
-	value     float64
+	value float64
+	// total 全部数据的数量，包括不满足条件的数据
+	total     float64
 	extraRows []interface{}
+	// restoredValue, restoredTotal 增量计算恢复的数量，下一级已经保存了这部分，Flush 只传递新增的数量
+	restoredValue float64
+	restoredTotal float64
 }
 
 func (c *Count) SetConfig(ctx context.Context, config []byte) error {
@@ -67,6 +77,7 @@ func (c *Count) SetConfig(ctx context.Context, config []byte) error {
 
 func (c *Count) Run(ctx context.Context, key string, record define.Record) error {
 	rowData := record.Data()
+	c.total += 1
 
 	for _, rule := range c.configOpt.Rules {
 		fieldValue := rowData[rule.Field]
@@ -111,6 +122,44 @@ func (c *Count) MetricExtra(ctx context.Context) (string, interface{}, bool) {
 	return outputKey, c.extraRows, true
 }
 
+// Emit 数据在当前级别计数，不传递给下一级
+func (c *Count) Emit(ctx context.Context, key string, data define.Record) ([]define.Record, error) {
+	return nil, nil
+}
+
+// Flush 传递统计的中间状态，字段output_key 是满足条件的数量，字段total 是全部数据的数量。
+// 增量计算的时候只传递恢复状态之后新增的数量
+func (c *Count) Flush(ctx context.Context, key string) ([]define.Record, error) {
+	field := map[string]float64{
+		totalField:            c.total - c.restoredTotal,
+		c.configOpt.OutputKey: c.value - c.restoredValue,
+	}
+	return []define.Record{define.NewRecord(key, map[string]string{"key": key}, field)}, nil
+}
+
+// countState 增量计算保存的状态
+type countState struct {
+	Value     float64       `json:"value"`
+	Total     float64       `json:"total"`
+	ExtraRows []interface{} `json:"extra_rows"`
+}
+
+func (c *Count) State(ctx context.Context) ([]byte, error) {
+	return json.Marshal(countState{Value: c.value, Total: c.total, ExtraRows: c.extraRows})
+}
+
+func (c *Count) Restore(ctx context.Context, state []byte) error {
+	s := countState{}
+	if err := json.Unmarshal(state, &s); err != nil {
+		return err
+	}
+	c.value = s.Value
+	c.total = s.Total
+	c.restoredValue, c.restoredTotal = s.Value, s.Total
+	c.extraRows = s.ExtraRows
+	return nil
+}
+
 func (c *Count) Name() string {
 	return "count"
 }
@@ -131,10 +180,13 @@ func (c *Count) Description() string {
 	extra: 指针类型，不存在的时候，没有附加需要存储的数据
 	extra_rule.field: 需要存储数据的字段，
 	extra_rule.output_key: 当前统计保存统计使用的名字，为空使用output_key
+	作为多级聚合的上一级时，传递给下一级一条数据，字段output_key 是满足条件的数量，字段total 是全部数据的数量
 
 `
 }
 
 var (
-	_ define.Aggregator = (*Count)(nil)
+	_ define.Aggregator         = (*Count)(nil)
+	_ define.AggregatorEmitter  = (*Count)(nil)
+	_ define.StatefulAggregator = (*Count)(nil)
 )
@@ -0,0 +1,129 @@
+package distinct
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/aggregators"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/14
+    @desc:
+
+***************************/
+
+const (
+	name = "distinct"
+)
+
+func init() {
+	aggregators.Add(name, func() define.Aggregator {
+		return &distinct{}
+	})
+}
+
+type config struct {
+	Field     string `json:"field"`
+	OutputKey string `json:"output_key"`
+}
+
+// distinct 按照字段去重，统计不同值的数量。
+// 作为多级聚合的上一级时，每个值只有第一次出现的数据会传递给下一级
+type distinct struct {
+	config         config
+	metricMetadata define.MetricMetadata
+	values         map[string]struct{}
+	// lastIsNew 最近一次Run 的数据是否是第一次出现
+	lastIsNew bool
+}
+
+func (d *distinct) Name() string {
+	return name
+}
+
+func (d *distinct) Run(ctx context.Context, key string, data define.Record) error {
+	val := data.Data()[d.config.Field]
+	if _, ok := d.values[val]; ok {
+		d.lastIsNew = false
+		return nil
+	}
+	d.values[val] = struct{}{}
+	d.lastIsNew = true
+	return nil
+}
+
+func (d *distinct) Emit(ctx context.Context, key string, data define.Record) ([]define.Record, error) {
+	if !d.lastIsNew {
+		return nil, nil
+	}
+	return []define.Record{data}, nil
+}
+
+func (d *distinct) Flush(ctx context.Context, key string) ([]define.Record, error) {
+	return nil, nil
+}
+
+// State 增量计算保存已经出现过的值，恢复后只有新出现的值会传递给下一级
+func (d *distinct) State(ctx context.Context) ([]byte, error) {
+	values := make([]string, 0, len(d.values))
+	for val := range d.values {
+		values = append(values, val)
+	}
+	return json.Marshal(values)
+}
+
+func (d *distinct) Restore(ctx context.Context, state []byte) error {
+	values := make([]string, 0)
+	if err := json.Unmarshal(state, &values); err != nil {
+		return err
+	}
+	d.values = make(map[string]struct{}, len(values))
+	for _, val := range values {
+		d.values[val] = struct{}{}
+	}
+	return nil
+}
+
+func (d *distinct) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &d.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	if d.config.Field == "" {
+		return errors.New("distinct field is empty")
+	}
+	d.values = make(map[string]struct{})
+	return nil
+}
+
+func (d *distinct) Description() string {
+	return `功能描述： 按照字段去重，统计字段不同值的数量。配置next 的时候，每个值第一次出现的数据传递给下一级aggregator
+参数描述: {"field":"", "output_key":""}
+	field: 去重使用的字段
+	output_key: 当前统计保存统计使用的名字，作为中间层级时为空表示不保存统计结果
+
+`
+}
+
+func (d *distinct) Metric(ctx context.Context) (string, float64) {
+	return d.config.OutputKey, float64(len(d.values))
+}
+
+func (d *distinct) MetricExtra(ctx context.Context) (string, interface{}, bool) {
+	return "", nil, false
+}
+
+func (d *distinct) SetMetricMetadata(ctx context.Context, data define.MetricMetadata) error {
+	d.metricMetadata = data
+	return nil
+}
+
+var (
+	_ define.Aggregator         = (*distinct)(nil)
+	_ define.AggregatorEmitter  = (*distinct)(nil)
+	_ define.StatefulAggregator = (*distinct)(nil)
+)
@@ -1,6 +1,8 @@
 package aggregators
 
 import (
+	"sort"
+
 	"github.com/rentiansheng/incenses/src/define"
 )
 
@@ -26,3 +28,13 @@ func Get(name string) define.Aggregator {
 	}
 	return c()
 }
+
+// Names 已经注册的插件名字，按照名字排序
+func Names() []string {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
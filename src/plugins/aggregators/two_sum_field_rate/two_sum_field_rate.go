@@ -133,9 +133,38 @@ func (t twoFieldSumRate) MetricExtra(ctx context.Context) (string, interface{},
 	return t.config.Extra.OutputKey, t.extraValueArr, true
 }
 
+// twoFieldSumRateState 增量计算保存的状态
+type twoFieldSumRateState struct {
+	TotalMolecular   float64  `json:"total_molecular"`
+	TotalDenominator float64  `json:"total_denominator"`
+	ExtraValueArr    []string `json:"extra_value_arr"`
+}
+
+func (t *twoFieldSumRate) State(ctx context.Context) ([]byte, error) {
+	return json.Marshal(twoFieldSumRateState{
+		TotalMolecular:   t.totalMolecular,
+		TotalDenominator: t.totalDenominator,
+		ExtraValueArr:    t.extraValueArr,
+	})
+}
+
+func (t *twoFieldSumRate) Restore(ctx context.Context, state []byte) error {
+	s := twoFieldSumRateState{}
+	if err := json.Unmarshal(state, &s); err != nil {
+		return err
+	}
+	t.totalMolecular = s.TotalMolecular
+	t.totalDenominator = s.TotalDenominator
+	t.extraValueArr = s.ExtraValueArr
+	return nil
+}
+
 func (t *twoFieldSumRate) SetMetricMetadata(ctx context.Context, data define.MetricMetadata) error {
 	t.metricMetadata = data
 	return nil
 }
 
-var _ define.Aggregator = (*twoFieldSumRate)(nil)
+var (
+	_ define.Aggregator         = (*twoFieldSumRate)(nil)
+	_ define.StatefulAggregator = (*twoFieldSumRate)(nil)
+)
@@ -1,6 +1,8 @@
 package collects
 
 import (
+	"sort"
+
 	"github.com/rentiansheng/incenses/src/define"
 )
 
@@ -26,3 +28,13 @@ func Get(name string) define.Collect {
 	}
 	return c()
 }
+
+// Names 已经注册的插件名字，按照名字排序
+func Names() []string {
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
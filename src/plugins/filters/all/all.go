@@ -1,5 +1,11 @@
 package all
 
+import (
+	_ "github.com/rentiansheng/incenses/src/plugins/filters/drop"
+	_ "github.com/rentiansheng/incenses/src/plugins/filters/rename"
+	_ "github.com/rentiansheng/incenses/src/plugins/filters/split"
+)
+
 /***************************
     @author: tiansheng.ren
     @date: 2022/9/28
@@ -0,0 +1,90 @@
+package drop
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/context/log"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/libs/rules/rule"
+	"github.com/rentiansheng/incenses/src/plugins/filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/12
+    @desc:
+
+***************************/
+
+const (
+	name = "drop"
+
+	modeDrop = "drop"
+	modeKeep = "keep"
+)
+
+func init() {
+	filters.Add(name, func() define.Filter {
+		return &drop{}
+	})
+}
+
+type config struct {
+	// Mode drop: 丢弃满足规则的数据，keep: 只保留满足规则的数据
+	Mode  string     `json:"mode"`
+	Rules rule.Rules `json:"rules"`
+}
+
+type drop struct {
+	config config
+}
+
+func (d *drop) Name() string {
+	return name
+}
+
+func (d *drop) Run(ctx context.Context, key string, data define.Record) ([]define.Record, error) {
+	match, err := d.config.Rules.Compare(key, data.Data())
+	if err != nil {
+		ctx.Log().Fields(log.Field("data", data.Data()), log.Field("rules", d.config.Rules)).
+			Errorf("compare rule error. key: %s, err: %s", key, err.Error())
+		return nil, err
+	}
+	// 满足规则并且是drop模式，或者不满足规则并且是keep模式，丢弃数据
+	if match == (d.config.Mode == modeDrop) {
+		return nil, nil
+	}
+	return []define.Record{data}, nil
+}
+
+func (d *drop) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &d.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	if d.config.Mode == "" {
+		d.config.Mode = modeDrop
+	}
+	if d.config.Mode != modeDrop && d.config.Mode != modeKeep {
+		return fmt.Errorf("%s mode. unimplement", d.config.Mode)
+	}
+	return nil
+}
+
+func (d *drop) Description() string {
+	return `功能描述： 根据规则丢弃数据
+参数描述: {"mode":"", "rules":[]{"field":"", "value":"", "operator":""}}
+	mode: 可选值:[drop,keep], 默认drop. drop: 丢弃满足规则的数据，keep: 只保留满足规则的数据
+	rule: 判断的规则, 多个规则需要同时满足
+	rule[x].field: 筛选数据要用到的字段
+	rule[x].value: 筛选数据需要比较值。
+	rule[x].operator: 判断筛选是否满足条件的规则，可选值:[equal,equal_key], equal: 等于，equal_key:是否等于key
+
+`
+}
+
+var (
+	_ define.Filter = (*drop)(nil)
+)
@@ -0,0 +1,82 @@
+package rename
+
+import (
+	"encoding/json"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/12
+    @desc:
+
+***************************/
+
+const (
+	name = "rename"
+)
+
+func init() {
+	filters.Add(name, func() define.Filter {
+		return &rename{}
+	})
+}
+
+type config struct {
+	// Labels 字段改名, key: 原字段名，value: 新字段名
+	Labels map[string]string `json:"labels"`
+	// Fields 数值字段改名, key: 原字段名，value: 新字段名
+	Fields map[string]string `json:"fields"`
+}
+
+type rename struct {
+	config config
+}
+
+func (r *rename) Name() string {
+	return name
+}
+
+// Run 生成改名后的新数据，不修改原数据，避免影响其他使用原数据的地方
+func (r *rename) Run(ctx context.Context, key string, data define.Record) ([]define.Record, error) {
+	newData := make(map[string]string, len(data.Data()))
+	for k, v := range data.Data() {
+		if newKey, ok := r.config.Labels[k]; ok {
+			k = newKey
+		}
+		newData[k] = v
+	}
+	newField := make(map[string]float64, len(data.Field()))
+	for k, v := range data.Field() {
+		if newKey, ok := r.config.Fields[k]; ok {
+			k = newKey
+		}
+		newField[k] = v
+	}
+
+	return []define.Record{define.NewRecord(data.UUID(), newData, newField)}, nil
+}
+
+func (r *rename) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &r.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	return nil
+}
+
+func (r *rename) Description() string {
+	return `功能描述： 修改数据字段的名字
+参数描述: {"labels":{"old":"new"}, "fields":{"old":"new"}}
+	labels: 文本字段改名，key 为原字段名，value 为新字段名
+	fields: 数值字段改名，key 为原字段名，value 为新字段名
+
+`
+}
+
+var (
+	_ define.Filter = (*rename)(nil)
+)
@@ -0,0 +1,102 @@
+package split
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/12
+    @desc:
+
+***************************/
+
+const (
+	name = "split"
+)
+
+func init() {
+	filters.Add(name, func() define.Filter {
+		return &split{}
+	})
+}
+
+type config struct {
+	Field       string `json:"field"`
+	Separator   string `json:"separator"`
+	OutputField string `json:"output_field"`
+}
+
+type split struct {
+	config config
+}
+
+func (s *split) Name() string {
+	return name
+}
+
+// Run 按照分隔符拆分字段，每一个值生成一条新的数据，新数据的uuid 为原uuid 加上序号
+func (s *split) Run(ctx context.Context, key string, data define.Record) ([]define.Record, error) {
+	rowData := data.Data()
+	value, ok := rowData[s.config.Field]
+	if !ok || value == "" {
+		return []define.Record{data}, nil
+	}
+
+	parts := strings.Split(value, s.config.Separator)
+	results := make([]define.Record, 0, len(parts))
+	for idx, part := range parts {
+		newData := make(map[string]string, len(rowData)+1)
+		for k, v := range rowData {
+			newData[k] = v
+		}
+		newData[s.config.OutputField] = strings.TrimSpace(part)
+
+		newField := make(map[string]float64, len(data.Field()))
+		for k, v := range data.Field() {
+			newField[k] = v
+		}
+		uuid := fmt.Sprintf("%s:%d", data.UUID(), idx)
+		results = append(results, define.NewRecord(uuid, newData, newField))
+	}
+
+	return results, nil
+}
+
+func (s *split) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &s.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	if s.config.Field == "" {
+		return errors.New("split field is empty")
+	}
+	if s.config.Separator == "" {
+		s.config.Separator = ","
+	}
+	if s.config.OutputField == "" {
+		s.config.OutputField = s.config.Field
+	}
+	return nil
+}
+
+func (s *split) Description() string {
+	return `功能描述： 将字段按照分隔符拆分成多条数据
+参数描述: {"field":"", "separator":"", "output_field":""}
+	field: 需要拆分的字段，字段不存在或者为空的时候，数据原样传递
+	separator: 分隔符，默认","
+	output_field: 拆分后的值存放的字段，为空使用field
+
+`
+}
+
+var (
+	_ define.Filter = (*split)(nil)
+)
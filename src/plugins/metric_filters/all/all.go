@@ -0,0 +1,15 @@
+package all
+
+import (
+	_ "github.com/rentiansheng/incenses/src/plugins/metric_filters/drop"
+	_ "github.com/rentiansheng/incenses/src/plugins/metric_filters/rename"
+	_ "github.com/rentiansheng/incenses/src/plugins/metric_filters/scale"
+	_ "github.com/rentiansheng/incenses/src/plugins/metric_filters/skip_empty"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/15
+    @desc:
+
+***************************/
@@ -0,0 +1,91 @@
+package drop
+
+import (
+	"encoding/json"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/metric_filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/15
+    @desc:
+
+***************************/
+
+const (
+	name = "drop"
+)
+
+func init() {
+	metric_filters.Add(name, func() define.MetricFilter {
+		return &drop{}
+	})
+}
+
+type config struct {
+	// Value 需要丢弃的统计结果名字
+	Value []string `json:"value"`
+	// Extra 需要丢弃的附加数据名字
+	Extra []string `json:"extra"`
+}
+
+type drop struct {
+	config config
+	value  map[string]struct{}
+	extra  map[string]struct{}
+}
+
+func (d *drop) Name() string {
+	return name
+}
+
+func (d *drop) Run(ctx context.Context, data define.MetricData) (define.MetricData, bool, error) {
+	result := define.MetricData{
+		MetricKey: data.MetricKey,
+		Value:     make(map[string]float64, len(data.Value)),
+		Extra:     make(map[string]interface{}, len(data.Extra)),
+	}
+	for k, v := range data.Value {
+		if _, ok := d.value[k]; !ok {
+			result.Value[k] = v
+		}
+	}
+	for k, v := range data.Extra {
+		if _, ok := d.extra[k]; !ok {
+			result.Extra[k] = v
+		}
+	}
+	return result, true, nil
+}
+
+func (d *drop) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &d.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	d.value = make(map[string]struct{}, len(d.config.Value))
+	for _, item := range d.config.Value {
+		d.value[item] = struct{}{}
+	}
+	d.extra = make(map[string]struct{}, len(d.config.Extra))
+	for _, item := range d.config.Extra {
+		d.extra[item] = struct{}{}
+	}
+	return nil
+}
+
+func (d *drop) Description() string {
+	return `功能描述： 丢弃部分统计结果，不保存
+参数描述: {"value":[], "extra":[]}
+	value: 需要丢弃的统计结果名字
+	extra: 需要丢弃的附加数据名字
+
+`
+}
+
+var (
+	_ define.MetricFilter = (*drop)(nil)
+)
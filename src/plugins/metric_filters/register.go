@@ -0,0 +1,40 @@
+package metric_filters
+
+import (
+	"sort"
+
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/15
+    @desc:
+
+***************************/
+
+type Creator func() define.MetricFilter
+
+var metricFilters = map[string]Creator{}
+
+func Add(name string, creator Creator) {
+	metricFilters[name] = creator
+}
+
+func Get(name string) define.MetricFilter {
+	c := metricFilters[name]
+	if c == nil {
+		return nil
+	}
+	return c()
+}
+
+// Names 已经注册的插件名字，按照名字排序
+func Names() []string {
+	names := make([]string, 0, len(metricFilters))
+	for name := range metricFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
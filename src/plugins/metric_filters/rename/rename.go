@@ -0,0 +1,83 @@
+package rename
+
+import (
+	"encoding/json"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/metric_filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/15
+    @desc:
+
+***************************/
+
+const (
+	name = "rename"
+)
+
+func init() {
+	metric_filters.Add(name, func() define.MetricFilter {
+		return &rename{}
+	})
+}
+
+type config struct {
+	// Value 统计结果改名, key: 原名字，value: 新名字
+	Value map[string]string `json:"value"`
+	// Extra 附加数据改名, key: 原名字，value: 新名字
+	Extra map[string]string `json:"extra"`
+}
+
+type rename struct {
+	config config
+}
+
+func (r *rename) Name() string {
+	return name
+}
+
+func (r *rename) Run(ctx context.Context, data define.MetricData) (define.MetricData, bool, error) {
+	result := define.MetricData{
+		MetricKey: data.MetricKey,
+		Value:     make(map[string]float64, len(data.Value)),
+		Extra:     make(map[string]interface{}, len(data.Extra)),
+	}
+	for k, v := range data.Value {
+		if newKey, ok := r.config.Value[k]; ok {
+			k = newKey
+		}
+		result.Value[k] = v
+	}
+	for k, v := range data.Extra {
+		if newKey, ok := r.config.Extra[k]; ok {
+			k = newKey
+		}
+		result.Extra[k] = v
+	}
+	return result, true, nil
+}
+
+func (r *rename) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &r.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	return nil
+}
+
+func (r *rename) Description() string {
+	return `功能描述： 修改统计结果的名字
+参数描述: {"value":{"old":"new"}, "extra":{"old":"new"}}
+	value: 统计结果改名，key 为原名字，value 为新名字
+	extra: 附加数据改名，key 为原名字，value 为新名字
+
+`
+}
+
+var (
+	_ define.MetricFilter = (*rename)(nil)
+)
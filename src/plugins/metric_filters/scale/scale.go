@@ -0,0 +1,99 @@
+package scale
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/metric_filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/15
+    @desc:
+
+***************************/
+
+const (
+	name = "scale"
+)
+
+func init() {
+	metric_filters.Add(name, func() define.MetricFilter {
+		return &scale{}
+	})
+}
+
+type rule struct {
+	// Factor 乘以的系数，为0 的时候不做缩放
+	Factor float64 `json:"factor"`
+	// Precision 保留的小数位数, 为nil 的时候不做四舍五入
+	Precision *int `json:"precision"`
+}
+
+type config struct {
+	// Value key: 统计结果的名字，为"*"的时候对所有没有单独配置的结果生效
+	Value map[string]rule `json:"value"`
+}
+
+type scale struct {
+	config config
+}
+
+func (s *scale) Name() string {
+	return name
+}
+
+func (s *scale) Run(ctx context.Context, data define.MetricData) (define.MetricData, bool, error) {
+	result := define.MetricData{
+		MetricKey: data.MetricKey,
+		Value:     make(map[string]float64, len(data.Value)),
+		Extra:     data.Extra,
+	}
+	for k, v := range data.Value {
+		r, ok := s.config.Value[k]
+		if !ok {
+			r, ok = s.config.Value["*"]
+		}
+		if ok {
+			v = r.apply(v)
+		}
+		result.Value[k] = v
+	}
+	return result, true, nil
+}
+
+func (r rule) apply(val float64) float64 {
+	if r.Factor != 0 {
+		val = val * r.Factor
+	}
+	if r.Precision != nil {
+		power := math.Pow10(*r.Precision)
+		val = math.Round(val*power) / power
+	}
+	return val
+}
+
+func (s *scale) SetConfig(ctx context.Context, config []byte) error {
+	if err := json.Unmarshal(config, &s.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *scale) Description() string {
+	return `功能描述： 对统计结果进行缩放和四舍五入
+参数描述: {"value":{"name":{"factor":0, "precision":*int}}}
+	value: key 为统计结果的名字，"*" 对所有没有单独配置的结果生效
+	value[x].factor: 统计结果乘以的系数，为0 的时候不做缩放
+	value[x].precision: 保留的小数位数，不配置的时候不做四舍五入
+
+`
+}
+
+var (
+	_ define.MetricFilter = (*scale)(nil)
+)
@@ -0,0 +1,83 @@
+package skip_empty
+
+import (
+	"encoding/json"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+	"github.com/rentiansheng/incenses/src/plugins/metric_filters"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/15
+    @desc:
+
+***************************/
+
+const (
+	name = "skip_empty"
+)
+
+func init() {
+	metric_filters.Add(name, func() define.MetricFilter {
+		return &skipEmpty{}
+	})
+}
+
+type config struct {
+	// Value 判断是否为空使用的统计结果名字，为空的时候使用全部统计结果
+	Value []string `json:"value"`
+}
+
+type skipEmpty struct {
+	config config
+}
+
+func (s *skipEmpty) Name() string {
+	return name
+}
+
+// Run key 为空，或者统计结果全部为0 的时候，不保存数据
+func (s *skipEmpty) Run(ctx context.Context, data define.MetricData) (define.MetricData, bool, error) {
+	if data.MetricKey == "" {
+		return data, false, nil
+	}
+	if len(s.config.Value) == 0 {
+		for _, v := range data.Value {
+			if v != 0 {
+				return data, true, nil
+			}
+		}
+		return data, false, nil
+	}
+	for _, item := range s.config.Value {
+		if data.Value[item] != 0 {
+			return data, true, nil
+		}
+	}
+	return data, false, nil
+}
+
+func (s *skipEmpty) SetConfig(ctx context.Context, config []byte) error {
+	if len(config) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(config, &s.config); err != nil {
+		ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(config), err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *skipEmpty) Description() string {
+	return `功能描述： key 为空或者统计结果全部为0 的时候，不保存数据
+参数描述: {"value":[]}
+	value: 判断是否为空使用的统计结果名字，为空的时候使用全部统计结果
+
+`
+}
+
+var (
+	_ define.MetricFilter = (*skipEmpty)(nil)
+)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -28,10 +29,18 @@ const (
 	tableNum = 12
 	//tableNameFormat
 	tableNameFormat = "metric_%s_tab"
+
+	// DefaultConnection SetDB 设置的连接的名字，没有配置connection 的任务使用
+	DefaultConnection = "default"
+
+	// writeModeUpsert 数据存在的时候更新，不存在的时候插入
+	writeModeUpsert = "upsert"
+	// writeModeInsert 每次计算结果都插入一条新的数据, 保留历史计算结果
+	writeModeInsert = "insert"
 )
 
 var (
-	db *gorm.DB
+	dbs = map[string]*gorm.DB{}
 )
 
 func init() {
@@ -40,12 +49,55 @@ func init() {
 	})
 }
 
+// SetDB 设置默认连接
 func SetDB(client *gorm.DB) {
-	db = client
+	AddDB(DefaultConnection, client)
 	return
 }
 
+// AddDB 添加命名的连接，任务通过配置中connection 选择写入的数据库
+func AddDB(name string, client *gorm.DB) {
+	dbs[name] = client
+}
+
+// fencingTokenColumn 建表之后新增的字段，InitTables 的时候给已经存在的表补充
+const fencingTokenColumn = "`fencing_token` bigint(20) unsigned NOT NULL DEFAULT 0"
+
+// InitTables 根据表名格式创建分表，tableFormat 为空的时候使用默认的格式。
+// 表已经存在的时候补充新增的字段，可以重复执行
+func InitTables(ctx context.Context, db *gorm.DB, tableFormat string) error {
+	if tableFormat == "" {
+		tableFormat = tableNameFormat
+	}
+	db = db.WithContext(ctx)
+	for idx, sql := range InitSQLWithFormat(ctx, tableFormat) {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+		tableName := tableNameByID(tableFormat, uint32(idx))
+		var cnt int64
+		err := db.Raw("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+			tableName, "fencing_token").Row().Scan(&cnt)
+		if err != nil {
+			return err
+		}
+		if cnt > 0 {
+			continue
+		}
+		if err := db.Exec("ALTER TABLE `" + tableName + "` ADD COLUMN " + fencingTokenColumn).Error; err != nil {
+			return fmt.Errorf("add column fencing_token error. table: %s, err: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+// InitSQL 使用默认的表名格式生成建表语句
 func InitSQL(ctx context.Context) []string {
+	return InitSQLWithFormat(ctx, tableNameFormat)
+}
+
+// InitSQLWithFormat 根据表名格式生成建表语句, 任务配置了table_format 的时候使用
+func InitSQLWithFormat(ctx context.Context, tableFormat string) []string {
 	sqls := make([]string, tableNum)
 	sqlSchema := "CREATE TABLE if not exists `%s` (" +
 		"`id` bigint(20) unsigned NOT NULL AUTO_INCREMENT," +
@@ -57,26 +109,74 @@ func InitSQL(ctx context.Context) []string {
 		"`extra` json DEFAULT NULL COMMENT '{“key”: any}'," +
 		"`mtime` int(10) unsigned NOT NULL," +
 		"`ctime` int(10) unsigned DEFAULT NULL," +
+		fencingTokenColumn + "," +
 		"PRIMARY KEY (`id`)," +
 		"KEY `idx_Name_StartTime_EndTime` (`metric_name`,`start_time`,`end_time`)," +
 		"KEY `idx_Name_MetricKey_StartTime_EndTime` (`metric_name`,`metric_key`,`start_time`,`end_time`)" +
 		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci"
 	for i := 0; i < tableNum; i++ {
-		sqls[i] = fmt.Sprintf(sqlSchema, tableNameByID(uint32(i)))
+		sqls[i] = fmt.Sprintf(sqlSchema, tableNameByID(tableFormat, uint32(i)))
 	}
 
 	return sqls
 
 }
 
+type config struct {
+	// Connection 使用的连接名字，通过AddDB 添加，默认使用SetDB 设置的连接
+	Connection string `json:"connection"`
+	// TableFormat 分表名字的格式，%s 为分表的序号，默认 metric_%s_tab
+	TableFormat string `json:"table_format"`
+	// WriteMode 写入方式，可选值[upsert,insert]，默认upsert
+	WriteMode string `json:"write_mode"`
+	// CheckFencing 写入的时候保存任务锁的fencing token，已经存在更大token 的数据时拒绝写入。
+	// 需要表中有fencing_token 字段，之前创建的表通过InitTables 补充
+	CheckFencing bool `json:"check_fencing"`
+}
+
 type Mysql struct {
 	metricMetadata define.MetricMetadata
+	config         config
+	db             *gorm.DB
 }
 
 func (m Mysql) Name() string {
 	return name
 }
 
+func (m *Mysql) SetConfig(ctx context.Context, cfg []byte) error {
+	m.config = config{}
+	if len(cfg) != 0 {
+		if err := json.Unmarshal(cfg, &m.config); err != nil {
+			ctx.Log().Errorf("unmarshal config error. config: %s, err: %s", string(cfg), err.Error())
+			return err
+		}
+	}
+	if m.config.Connection == "" {
+		m.config.Connection = DefaultConnection
+	}
+	if m.config.TableFormat == "" {
+		m.config.TableFormat = tableNameFormat
+	}
+	if strings.Count(m.config.TableFormat, "%s") != 1 {
+		return fmt.Errorf("table format must contain one %%s. table format: %s", m.config.TableFormat)
+	}
+	switch m.config.WriteMode {
+	case "":
+		m.config.WriteMode = writeModeUpsert
+	case writeModeUpsert, writeModeInsert:
+	default:
+		return fmt.Errorf("%s write mode. unimplement", m.config.WriteMode)
+	}
+
+	db, ok := dbs[m.config.Connection]
+	if !ok || db == nil {
+		return fmt.Errorf("mysql connection not found. connection: %s", m.config.Connection)
+	}
+	m.db = db
+	return nil
+}
+
 func (m Mysql) Write(ctx context.Context, data define.OutputData) error {
 
 	saveData, err := convertOutputData(data, m.metricMetadata)
@@ -84,11 +184,31 @@ func (m Mysql) Write(ctx context.Context, data define.OutputData) error {
 		ctx.Log().Errorf("convert data to store struct error. data: %#v, err: %s", data, err)
 		return fmt.Errorf("convert data to store struct error. err: %s", err)
 	}
+	tableName := m.tableName(saveData)
 
 	dataQuery := func() *gorm.DB {
-		return db.Table(saveData.TableName()).Where("metric_name=? and metric_key=? and start_time=?",
+		return m.db.Table(tableName).Where("metric_name=? and metric_key=? and start_time=?",
 			m.metricMetadata.MetricName, data.MetricKey, m.metricMetadata.Start)
 	}
+	if m.config.CheckFencing {
+		if err := m.checkFencingToken(ctx, dataQuery()); err != nil {
+			return err
+		}
+	}
+	omitFields := []string{}
+	if !m.config.CheckFencing {
+		omitFields = append(omitFields, "fencing_token")
+	}
+
+	if m.config.WriteMode == writeModeInsert {
+		saveData.Ctime = saveData.Mtime
+		if err := m.db.Table(tableName).Omit(omitFields...).Create(saveData).Error; err != nil {
+			ctx.Log().Errorf("mysql create execute error. data: %#v, err: %s", data, err)
+			return err
+		}
+		return nil
+	}
+
 	var cnt int64
 	err = dataQuery().Count(&cnt).Error
 	if err != nil {
@@ -97,15 +217,39 @@ func (m Mysql) Write(ctx context.Context, data define.OutputData) error {
 	}
 	if cnt == 0 {
 		saveData.Ctime = saveData.Mtime
-		if err := db.Create(saveData).Error; err != nil {
+		if err := m.db.Table(tableName).Omit(omitFields...).Create(saveData).Error; err != nil {
 			ctx.Log().Errorf("mysql create execute error. data: %#v, err: %s", data, err)
 			return err
 		}
 	} else {
-		if err := dataQuery().Updates(saveData).Error; err != nil {
-			ctx.Log().Errorf("mysql update execute error. data: %#v, err: %s", data, err)
-			return err
+		updateQuery := dataQuery()
+		if m.config.CheckFencing {
+			// 检查和更新之间，数据可能被更大token 的任务修改
+			updateQuery = updateQuery.Where("fencing_token <= ?", saveData.FencingToken)
+		}
+		result := updateQuery.Omit(omitFields...).Updates(saveData)
+		if result.Error != nil {
+			ctx.Log().Errorf("mysql update execute error. data: %#v, err: %s", data, result.Error)
+			return result.Error
 		}
+		// 没有更新数据，可能是被更大token 的任务修改，也可能是数据没有变化，需要重新检查
+		if m.config.CheckFencing && result.RowsAffected == 0 {
+			return m.checkFencingToken(ctx, dataQuery())
+		}
+	}
+	return nil
+}
+
+// checkFencingToken 已经存在更大fencing token 写入的数据，说明当前任务的锁已经过期，拒绝写入
+func (m Mysql) checkFencingToken(ctx context.Context, query *gorm.DB) error {
+	var cnt int64
+	if err := query.Where("fencing_token > ?", m.metricMetadata.FencingToken).Count(&cnt).Error; err != nil {
+		ctx.Log().Errorf("mysql count fencing token execute error. err: %s", err)
+		return err
+	}
+	if cnt > 0 {
+		ctx.Log().Field("meta", m.metricMetadata).Errorf("reject write. reason: stale fencing token")
+		return define.ErrStaleFencingToken
 	}
 	return nil
 }
@@ -130,7 +274,7 @@ func (m Mysql) Exists(ctx context.Context, key string) (bool, error) {
 		"metric_key":  key,
 		"start_time":  paramData.Start,
 	}
-	countQueryEngine := db.Table(metricMetadata.MetricName).Where(condData)
+	countQueryEngine := m.db.Table(m.tableName(paramData)).Where(condData)
 
 	// 如果指标已经存在的数据，大于指标上次完成指标计算的时间， 则证明改key 已经计算过了， 可以跳过
 	countQueryEngine = countQueryEngine.Where("mtime > ?", metricMetadata.LastFinishTime)
@@ -146,6 +290,11 @@ func (m Mysql) Exists(ctx context.Context, key string) (bool, error) {
 
 func (m Mysql) Description() string {
 	return `功能描述: 将结果存放到分表mysql 中
+参数描述: {"connection":"", "table_format":"", "write_mode":"", "check_fencing":false}
+	connection: 使用的连接名字，通过AddDB 添加，默认使用SetDB 设置的连接
+	table_format: 分表名字的格式，%s 为分表的序号，默认 metric_%s_tab
+	write_mode: 写入方式，可选值:[upsert,insert], 默认upsert. upsert: 存在的时候更新，insert: 每次写入新的数据
+	check_fencing: 是否根据任务锁的fencing token 拒绝锁过期任务的写入，默认false，需要表中有fencing_token 字段，InitTables 会给已经存在的表补充
 	其他：
 		指标数据数据存放的表，在任务 writer 字段中
 `
@@ -157,7 +306,7 @@ func (m Mysql) IndexName(ctx context.Context) (string, error) {
 		ctx.Log().Field("data", m.metricMetadata).Errorf("get index error. err: %s", err.Error())
 		return "", err
 	}
-	return paramData.TableName(), nil
+	return m.tableName(paramData), nil
 }
 
 func (m *Mysql) SetMetricMetadata(ctx context.Context, data define.MetricMetadata) error {
@@ -165,6 +314,14 @@ func (m *Mysql) SetMetricMetadata(ctx context.Context, data define.MetricMetadat
 	return nil
 }
 
+func (m Mysql) tableName(data outputData) string {
+	tableFormat := m.config.TableFormat
+	if tableFormat == "" {
+		tableFormat = tableNameFormat
+	}
+	return tableNameByID(tableFormat, data.tableID())
+}
+
 type outputData struct {
 	// 指标名字
 	MetricName string `gorm:"column:metric_name"`
@@ -175,6 +332,8 @@ type outputData struct {
 	End        uint64 `gorm:"column:end_time"`
 	Ctime      uint64 `gorm:"column:ctime"`
 	Mtime      uint64 `gorm:"column:mtime"`
+	// FencingToken 写入数据的任务持有的锁的token
+	FencingToken uint64 `gorm:"column:fencing_token"`
 }
 
 func convertOutputData(data define.OutputData, meta define.MetricMetadata) (outputData, error) {
@@ -206,12 +365,18 @@ func convertOutputData(data define.OutputData, meta define.MetricMetadata) (outp
 		Start:      meta.Start,
 		End:        meta.End,
 		Mtime:      uint64(time.Now().Unix()),
+
+		FencingToken: meta.FencingToken,
 	}, nil
 }
 
+// TableName 使用默认表名格式的表名
 func (o outputData) TableName() string {
-	idx := hashCode(o.MetricName) % 12
-	return tableNameByID(idx)
+	return tableNameByID(tableNameFormat, o.tableID())
+}
+
+func (o outputData) tableID() uint32 {
+	return hashCode(o.MetricName) % tableNum
 }
 
 // hashCode hashes using fnv32a algorithm
@@ -230,8 +395,8 @@ func (t tableSuffix) String() string {
 	return fmt.Sprintf("%d", t)
 }
 
-func tableNameByID(idx uint32) string {
-	return fmt.Sprintf(tableNameFormat, tableSuffix(idx).String())
+func tableNameByID(tableFormat string, idx uint32) string {
+	return fmt.Sprintf(tableFormat, tableSuffix(idx).String())
 }
 
 var (
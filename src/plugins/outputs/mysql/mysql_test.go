@@ -0,0 +1,107 @@
+package mysql
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/orlangure/gnomock"
+	mockMysql "github.com/orlangure/gnomock/preset/mysql"
+	"github.com/stretchr/testify/require"
+	gormMysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/rentiansheng/incenses/src/context"
+	"github.com/rentiansheng/incenses/src/define"
+)
+
+/***************************
+    @author: tiansheng.ren
+    @date: 2022/10/28
+    @desc:
+
+***************************/
+
+func initMysql(t *testing.T) (db *gorm.DB, deferFn func(), err error) {
+	deferFn = func() {}
+	mysqlUser, mysqlPWD, dbName := "metric", "metric", "metric"
+	p := mockMysql.Preset(
+		mockMysql.WithUser(mysqlUser, mysqlPWD),
+		mockMysql.WithDatabase(dbName),
+	)
+
+	container, err := gnomock.Start(p)
+	if err != nil {
+		return nil, deferFn, err
+	}
+	// 必须返回
+	deferFn = func() { _ = gnomock.Stop(container) }
+	defer func() {
+		if err != nil {
+			deferFn()
+		}
+	}()
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", mysqlUser, mysqlPWD, container.DefaultAddress(), dbName)
+
+	db, err = gorm.Open(gormMysql.Open(dsn))
+	if err != nil {
+		return nil, deferFn, err
+	}
+	if err := InitTables(context.Background(), db, ""); err != nil {
+		return nil, deferFn, err
+	}
+	SetDB(db)
+	return db, deferFn, nil
+}
+
+func newFencingOutput(t *testing.T, start, token uint64) *Mysql {
+	ctx := context.Background()
+	m := &Mysql{}
+	require.NoError(t, m.SetConfig(ctx, []byte(`{"check_fencing":true}`)), "set config")
+	require.NoError(t, m.SetMetricMetadata(ctx, define.MetricMetadata{
+		MetricName:   "fencing",
+		Start:        start,
+		End:          start + 3600,
+		FencingToken: token,
+	}), "set metric metadata")
+	return m
+}
+
+func TestWriteStaleFencingToken(t *testing.T) {
+	db, deferFn, err := initMysql(t)
+	require.NoError(t, err, "mock mysql error")
+	defer deferFn()
+
+	ctx := context.Background()
+	data := func(value float64) define.OutputData {
+		return define.OutputData{MetricData: define.MetricData{MetricKey: "k1", Value: map[string]float64{"cnt": value}}}
+	}
+	start := uint64(time.Now().Unix())
+	current := newFencingOutput(t, start, 2)
+	require.NoError(t, current.Write(ctx, data(2)), "insert")
+	require.NoError(t, current.Write(ctx, data(2)), "rewrite without change")
+
+	stale := newFencingOutput(t, start, 1)
+	require.ErrorIs(t, stale.Write(ctx, data(1)), define.ErrStaleFencingToken, "stale token")
+
+	// 检查token 之后，更新之前，更大token 的任务写入了数据
+	racer := newFencingOutput(t, start, 3)
+	tableName, err := racer.IndexName(ctx)
+	require.NoError(t, err, "index name")
+	newerWrite := "UPDATE `" + tableName + "` SET fencing_token = 4, metric_value = '{\"cnt\":4}' WHERE metric_name = ? AND metric_key = ?"
+	err = db.Callback().Update().Before("gorm:update").Register("test:newer_writer", func(tx *gorm.DB) {
+		if err := db.Session(&gorm.Session{NewDB: true}).Exec(newerWrite, "fencing", "k1").Error; err != nil {
+			_ = tx.AddError(err)
+		}
+	})
+	require.NoError(t, err, "register callback")
+	err = racer.Write(ctx, data(3))
+	require.NoError(t, db.Callback().Update().Remove("test:newer_writer"), "remove callback")
+	require.ErrorIs(t, err, define.ErrStaleFencingToken, "newer write between check and update")
+
+	row := outputData{}
+	err = db.Table(tableName).Where("metric_name = ? AND metric_key = ?", "fencing", "k1").Take(&row).Error
+	require.NoError(t, err, "find data")
+	require.Equal(t, uint64(4), row.FencingToken, "newer token kept")
+	require.JSONEq(t, `{"cnt":4}`, row.Value, "newer value kept")
+}
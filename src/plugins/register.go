@@ -4,6 +4,7 @@ import (
 	_ "github.com/rentiansheng/incenses/src/plugins/aggregators/all"
 	_ "github.com/rentiansheng/incenses/src/plugins/collects/all"
 	_ "github.com/rentiansheng/incenses/src/plugins/filters/all"
+	_ "github.com/rentiansheng/incenses/src/plugins/metric_filters/all"
 	_ "github.com/rentiansheng/incenses/src/plugins/outputs/all"
 )
 